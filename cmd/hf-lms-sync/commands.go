@@ -0,0 +1,1055 @@
+// cmd/hf-lms-sync/commands.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jmfirth/hf-lms-sync/internal/config"
+	"github.com/jmfirth/hf-lms-sync/internal/desired"
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+	"github.com/jmfirth/hf-lms-sync/internal/journal"
+	"github.com/jmfirth/hf-lms-sync/internal/lock"
+	"github.com/jmfirth/hf-lms-sync/internal/logger"
+	"github.com/jmfirth/hf-lms-sync/internal/rules"
+	"github.com/jmfirth/hf-lms-sync/internal/watch"
+)
+
+// command runs a subcommand with the arguments that follow its name
+type command func(ctx context.Context, args []string, cfg *config.Config, appLogger *logger.Logger) error
+
+// commands maps subcommand names to their implementations
+var commands = map[string]command{
+	"apply":    runApply,
+	"config":   runConfig,
+	"export":   runExport,
+	"history":  runHistory,
+	"import":   runImport,
+	"link-all": runLinkAll,
+	"list":     runList,
+	"prune":    runPrune,
+	"resume":   runResume,
+	"undo":     runUndo,
+	"verify":   runVerify,
+	"watch":    runWatch,
+}
+
+// listEntry is the machine-readable form of a model in `list --json`
+type listEntry struct {
+	RepoID     string `json:"repo_id"`
+	RepoType   string `json:"repo_type"`
+	Format     string `json:"format,omitempty"`
+	Status     string `json:"status"`
+	Size       int64  `json:"size"`
+	LinkedSize int64  `json:"linked_size"`
+	SourcePath string `json:"source_path,omitempty"`
+	TargetPath string `json:"target_path,omitempty"`
+	ExcludedBy string `json:"excluded_by,omitempty"`
+	Outdated   string `json:"outdated,omitempty"`
+}
+
+// listOutput is the document printed by `list --json`
+type listOutput struct {
+	HfCacheDir  string             `json:"hf_cache_dir"`
+	ExtraCaches []string           `json:"extra_caches,omitempty"`
+	TargetDir   string             `json:"target_dir"`
+	Models      []listEntry        `json:"models"`
+	Totals      fsutils.SizeTotals `json:"totals"`
+}
+
+// linkEvent is a line of `link-all --json`: a model that was skipped, deferred, planned,
+// linked or failed, or a file being linked
+type linkEvent struct {
+	Event      string `json:"event"`
+	Repo       string `json:"repo"`
+	Index      int    `json:"index,omitempty"`
+	Total      int    `json:"total,omitempty"`
+	File       string `json:"file,omitempty"`
+	BytesDone  int64  `json:"bytes_done,omitempty"`
+	BytesTotal int64  `json:"bytes_total,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// linkSummary is the last line of `link-all --json`
+type linkSummary struct {
+	Event     string `json:"event"`
+	DryRun    bool   `json:"dry_run,omitempty"`
+	Linked    int    `json:"linked"`
+	Failed    int    `json:"failed"`
+	Excluded  int    `json:"excluded"`
+	Deferred  int    `json:"deferred"`
+	Cancelled bool   `json:"cancelled,omitempty"`
+}
+
+// progressLine keeps a single line of progress on stderr, rewritten in place
+type progressLine struct {
+	width int
+}
+
+func newProgressLine() *progressLine {
+	return &progressLine{}
+}
+
+// show replaces the progress line
+func (p *progressLine) show(line string) {
+	pad := ""
+	if n := p.width - len(line); n > 0 {
+		pad = strings.Repeat(" ", n)
+	}
+	fmt.Fprint(os.Stderr, "\r"+line+pad)
+	p.width = len(line)
+}
+
+// clear erases the progress line so regular output can follow
+func (p *progressLine) clear() {
+	if p.width > 0 {
+		fmt.Fprint(os.Stderr, "\r"+strings.Repeat(" ", p.width)+"\r")
+		p.width = 0
+	}
+}
+
+// waitForLock makes commands wait for another instance to release its lock instead of
+// failing; set by --wait
+var waitForLock bool
+
+// lockDirs locks target directories and the HF cache against other hf-lms-sync processes
+// for a command that changes them, then cleans up links an interrupted run left
+// half-built. The caller releases the lock when it is done.
+func lockDirs(ctx context.Context, appLogger *logger.Logger, targetDirs ...string) (*lock.Lock, error) {
+	caches, err := fsutils.GetHfCacheDirs()
+	if err != nil {
+		return nil, err
+	}
+	dirs := append(append([]string{}, targetDirs...), caches...)
+	var l *lock.Lock
+	if waitForLock {
+		l, err = lock.Wait(ctx, func(held *lock.HeldError) {
+			fmt.Fprintf(os.Stderr, "Waiting for %s to finish...\n", held.Holder)
+		}, dirs...)
+	} else {
+		l, err = lock.Acquire(dirs...)
+	}
+	if ctx.Err() != nil {
+		return nil, errInterrupted
+	}
+	if errors.Is(err, lock.ErrLocked) {
+		return nil, fmt.Errorf("%v (use --wait to wait for it)", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range targetDirs {
+		cleanupStaging(dir, appLogger)
+	}
+	return l, nil
+}
+
+// loadRules parses the include and exclude rules of the configuration
+func loadRules(cfg *config.Config) (*rules.Set, error) {
+	return rules.NewSet(cfg.Strings("include"), cfg.Strings("exclude"))
+}
+
+// scanModels loads models and stale links for a target directory with sizes and
+// exclusions filled in
+func scanModels(targetDir string, ruleSet *rules.Set) ([]fsutils.ModelInfo, error) {
+	models, err := fsutils.LoadModels(targetDir)
+	if err != nil {
+		return nil, err
+	}
+	stale, err := fsutils.FindStaleLinks(targetDir)
+	if err != nil {
+		return nil, err
+	}
+	all := append(models, stale...)
+	cache := fsutils.LoadDefaultSizeCache()
+	fsutils.ComputeSizes(all, cache)
+	cache.Save()
+	ruleSet.Apply(all)
+	return all, nil
+}
+
+// runList prints every model with its status and disk usage
+func runList(ctx context.Context, args []string, cfg *config.Config, appLogger *logger.Logger) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	jsonFlag := flags.Bool("json", false, "Print machine-readable JSON")
+	flags.Parse(args)
+
+	targetDir := findTargetDir(flags.Args(), cfg, appLogger)
+	caches, err := fsutils.GetHfCacheDirs()
+	if err != nil {
+		return err
+	}
+	ruleSet, err := loadRules(cfg)
+	if err != nil {
+		return err
+	}
+	models, err := scanModels(targetDir, ruleSet)
+	if err != nil {
+		return err
+	}
+	totals := fsutils.SummarizeSizes(models)
+
+	if *jsonFlag {
+		out := listOutput{HfCacheDir: caches[0], ExtraCaches: caches[1:], TargetDir: targetDir, Models: []listEntry{}, Totals: totals}
+		for _, m := range models {
+			entry := listEntry{
+				RepoID:     m.RepoID(),
+				RepoType:   string(m.RepoType),
+				Format:     string(m.Format),
+				Status:     m.Status(),
+				Size:       m.Size,
+				LinkedSize: m.LinkedSize,
+				TargetPath: m.TargetPath,
+				ExcludedBy: m.ExcludedBy,
+				Outdated:   m.OutdatedReason,
+			}
+			if !m.IsStale {
+				entry.SourcePath = m.SourcePath
+			}
+			out.Models = append(out.Models, entry)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REPO\tSTATUS\tFORMAT\tSIZE\tLINKED\tNOTE")
+	for _, m := range models {
+		linked := "-"
+		if m.IsLinked && !m.IsStale {
+			linked = fsutils.FormatSize(m.LinkedSize)
+		}
+		note := ""
+		if m.IsExcluded {
+			note = rules.Describe(m.ExcludedBy)
+		} else if m.IsOutdated {
+			note = "outdated: " + m.OutdatedReason
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", m.RepoID(), m.Status(), m.Format.String(), fsutils.FormatSize(m.Size), linked, note)
+	}
+	w.Flush()
+	fmt.Printf("\nLinked %d (%s, %s in links), unlinked %d (%s), not linkable %d (%s), stale %d, cache total %s\n",
+		totals.LinkedCount, fsutils.FormatSize(totals.LinkedBytes), fsutils.FormatSize(totals.LinkedFileBytes),
+		totals.UnlinkedCount, fsutils.FormatSize(totals.UnlinkedBytes),
+		totals.OtherCount, fsutils.FormatSize(totals.OtherBytes),
+		totals.StaleCount, fsutils.FormatSize(totals.TotalBytes))
+	return nil
+}
+
+// confirm asks a yes/no question on stdin and reports whether the answer was yes. An
+// interrupt while waiting for the answer counts as no.
+func confirm(ctx context.Context, question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answers := make(chan string, 1)
+	go func() {
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answers <- answer
+	}()
+	select {
+	case <-ctx.Done():
+		fmt.Println()
+		return false
+	case answer := <-answers:
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}
+}
+
+// runPrune removes unused revisions, orphan blobs and abandoned downloads from the HF cache
+func runPrune(ctx context.Context, args []string, cfg *config.Config, appLogger *logger.Logger) error {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Only print what would be deleted")
+	yes := flags.Bool("yes", false, "Delete without asking for confirmation")
+	incompleteAge := flags.Duration("incomplete-age", fsutils.DefaultIncompleteMinAge, "Minimum age of *.incomplete files to delete")
+	flags.Parse(args)
+
+	targetDir := findTargetDir(flags.Args(), cfg, appLogger)
+	// Links in any configured target keep their revisions alive, so every target whose
+	// links are read is locked, not just the one being pruned for.
+	targets := append([]string{targetDir}, cfg.Strings("extra_targets")...)
+	if !*dryRun {
+		var locked []string
+		for _, dir := range targets {
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				locked = append(locked, dir)
+			}
+		}
+		l, err := lockDirs(ctx, appLogger, locked...)
+		if err != nil {
+			return err
+		}
+		defer l.Release()
+	}
+	caches, err := fsutils.GetHfCacheDirs()
+	if err != nil {
+		return err
+	}
+	plan, err := fsutils.PlanPruneCaches(ctx, caches, targets, fsutils.PruneOptions{IncompleteMinAge: *incompleteAge})
+	if err != nil {
+		return err
+	}
+	if len(plan.Items) == 0 {
+		fmt.Println("Nothing to prune.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tREPO\tSIZE\tPATH")
+	for _, item := range plan.Items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", item.Kind, item.CacheDirName, fsutils.FormatSize(item.Size), item.Path)
+	}
+	w.Flush()
+	fmt.Printf("\nReclaimable: %s\n", fsutils.FormatSize(plan.ReclaimableBytes))
+
+	if *dryRun {
+		return nil
+	}
+	if !*yes && !confirm(ctx, "Delete these items?") {
+		fmt.Println("Aborted.")
+		return nil
+	}
+	reclaimed, err := fsutils.ExecutePrune(ctx, plan, targets)
+	if reclaimed > 0 {
+		if err := journal.OpenDefault().Record("prune", targetDir, fmt.Sprintf("%d item(s), %s", len(plan.Items), fsutils.FormatSize(reclaimed))); err != nil {
+			appLogger.Error("PRUNE", "Failed to record prune in the journal: %v", err)
+		}
+	}
+	appLogger.Info("PRUNE", "Reclaimed %d bytes", reclaimed)
+	fmt.Printf("Reclaimed %s\n", fsutils.FormatSize(reclaimed))
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("%w: the remaining items were kept", errInterrupted)
+	}
+	return err
+}
+
+// runVerify hashes the files of every linked model and reports corrupted or missing blobs
+func runVerify(ctx context.Context, args []string, cfg *config.Config, appLogger *logger.Logger) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	workers := flags.Int("workers", 0, "Number of files to hash in parallel (default: one per CPU)")
+	flags.Parse(args)
+
+	targetDir := findTargetDir(flags.Args(), cfg, appLogger)
+	models, err := fsutils.LoadModels(targetDir)
+	if err != nil {
+		return err
+	}
+	var linked []fsutils.ModelInfo
+	for _, m := range models {
+		if m.IsLinked {
+			linked = append(linked, m)
+		}
+	}
+	if len(linked) == 0 {
+		fmt.Println("No linked models to verify.")
+		return nil
+	}
+
+	cache := fsutils.LoadDefaultVerifyCache()
+	var state fsutils.VerifyProgress
+	results := fsutils.VerifyModels(ctx, linked, cache, *workers, func(p fsutils.VerifyProgress) {
+		state = p
+		fmt.Fprintf(os.Stderr, "\r%d/%d files, %s of %s", p.FilesDone, p.FilesTotal,
+			fsutils.FormatSize(p.BytesDone), fsutils.FormatSize(p.BytesTotal))
+	})
+	fmt.Fprintln(os.Stderr)
+	if err := cache.Save(); err != nil {
+		appLogger.Error("VERIFY", "Error saving verify cache: %v", err)
+	}
+
+	var files, cached, corrupt int
+	for _, r := range results {
+		files += r.Checked
+		cached += r.Cached
+		if len(r.Issues) == 0 {
+			continue
+		}
+		corrupt++
+		fmt.Printf("%s: corrupt\n", r.Model.RepoID())
+		for _, issue := range r.Issues {
+			fmt.Printf("  %s %s: %s\n", issue.Kind, issue.File, issue.Detail)
+		}
+	}
+	fmt.Printf("Verified %d file(s) in %d model(s) (%d unchanged since last run), %d corrupt\n", files, len(results), cached, corrupt)
+	appLogger.Info("VERIFY", "Verified %d files in %d models, %d corrupt", files, len(results), corrupt)
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w after %d of %d file(s)", errInterrupted, files, state.FilesTotal)
+	}
+	if corrupt > 0 {
+		return fmt.Errorf("%d model(s) failed verification", corrupt)
+	}
+	return nil
+}
+
+// runLinkAll links every unlinked model of a supported format that the include and
+// exclude rules allow, like "link all" in the UI
+func runLinkAll(ctx context.Context, args []string, cfg *config.Config, appLogger *logger.Logger) error {
+	flags := flag.NewFlagSet("link-all", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Only print what would be linked")
+	jsonFlag := flags.Bool("json", false, "Print progress as one JSON event per line")
+	flags.Parse(args)
+
+	ruleSet, err := loadRules(cfg)
+	if err != nil {
+		return err
+	}
+	targetDir := findTargetDir(flags.Args(), cfg, appLogger)
+	if !*dryRun {
+		l, err := lockDirs(ctx, appLogger, targetDir)
+		if err != nil {
+			return err
+		}
+		defer l.Release()
+	}
+	models, err := fsutils.LoadModels(targetDir)
+	if err != nil {
+		return err
+	}
+	ruleSet.Apply(models)
+
+	// With --json every line is an event; without it, progress goes to stderr
+	var events *json.Encoder
+	if *jsonFlag {
+		events = json.NewEncoder(os.Stdout)
+	}
+	excluded, deferred := 0, 0
+	var steps []journal.Step
+	for _, m := range models {
+		if m.IsLinked || !m.IsLinkable() || m.Format == fsutils.FormatUnsupported {
+			continue
+		}
+		switch {
+		case m.IsExcluded:
+			excluded++
+			if events != nil {
+				events.Encode(linkEvent{Event: "skip", Repo: m.RepoID(), Reason: rules.Describe(m.ExcludedBy)})
+			} else {
+				fmt.Printf("skip   %s: %s\n", m.RepoID(), rules.Describe(m.ExcludedBy))
+			}
+		case m.IsIncomplete:
+			deferred++
+			if events != nil {
+				events.Encode(linkEvent{Event: "defer", Repo: m.RepoID(), Reason: m.IncompleteReason})
+			} else {
+				fmt.Printf("defer  %s: %s\n", m.RepoID(), m.IncompleteReason)
+			}
+		default:
+			steps = append(steps, journal.LinkStep(m, fsutils.LinkOptions{Files: fsutils.ExactFiles(m.RuleFiles), Mode: fsutils.LinkMode(cfg.String("link_mode"))}))
+		}
+	}
+	if *dryRun {
+		for _, step := range steps {
+			if events != nil {
+				events.Encode(linkEvent{Event: "plan", Repo: step.Repo})
+			} else {
+				fmt.Printf("link   %s\n", step.Repo)
+			}
+		}
+		if events != nil {
+			return events.Encode(linkSummary{Event: "done", DryRun: true, Linked: len(steps), Excluded: excluded, Deferred: deferred})
+		}
+		fmt.Printf("\nWould link %d model(s); %d excluded by rules, %d incomplete download(s) deferred\n", len(steps), excluded, deferred)
+		return nil
+	}
+
+	linked := 0
+	var failures []string
+	progress := newProgressLine()
+	_, err = journal.OpenDefault().RunWith(ctx, "link all", targetDir, steps, journal.RunOptions{
+		Workers: cfg.Int("workers"),
+		OnStep: func(i int, stepErr error) {
+			if errors.Is(stepErr, context.Canceled) {
+				return
+			}
+			if stepErr != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", steps[i].Repo, stepErr))
+				appLogger.Error("LINK", "link %s failed: %v", steps[i].Repo, stepErr)
+				if events != nil {
+					events.Encode(linkEvent{Event: "failed", Repo: steps[i].Repo, Index: i + 1, Total: len(steps), Error: stepErr.Error()})
+				} else {
+					progress.clear()
+					fmt.Fprintf(os.Stderr, "link %s failed: %v\n", steps[i].Repo, stepErr)
+				}
+				return
+			}
+			linked++
+			appLogger.Info("LINK", "Linked %s", steps[i].Repo)
+			if events != nil {
+				events.Encode(linkEvent{Event: "linked", Repo: steps[i].Repo, Index: i + 1, Total: len(steps)})
+			} else {
+				progress.clear()
+				fmt.Printf("linked %s\n", steps[i].Repo)
+			}
+		},
+		OnProgress: func(i int, p fsutils.LinkProgress) {
+			if events != nil {
+				events.Encode(linkEvent{Event: "file", Repo: steps[i].Repo, Index: i + 1, Total: len(steps), File: p.File, BytesDone: p.BytesDone, BytesTotal: p.BytesTotal})
+				return
+			}
+			line := fmt.Sprintf("[%d/%d] %s %s", i+1, len(steps), steps[i].Repo, p.File)
+			if p.BytesTotal > 0 {
+				line += fmt.Sprintf(" %s of %s", fsutils.FormatSize(p.BytesDone), fsutils.FormatSize(p.BytesTotal))
+			}
+			progress.show(line)
+		},
+	})
+	progress.clear()
+	if err != nil {
+		return err
+	}
+	if events != nil {
+		events.Encode(linkSummary{Event: "done", Linked: linked, Failed: len(failures), Excluded: excluded, Deferred: deferred, Cancelled: ctx.Err() != nil})
+	} else {
+		fmt.Printf("\nLinked %d model(s); %d excluded by rules, %d incomplete download(s) deferred\n", linked, excluded, deferred)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: linked %d of %d model(s), the rest were skipped", errInterrupted, linked, len(steps))
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return fmt.Errorf("%d link(s) failed:\n  %s", len(failures), strings.Join(failures, "\n  "))
+	}
+	return nil
+}
+
+// watchf prints a timestamped line of `watch` output and logs it
+func watchf(appLogger *logger.Logger, format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	fmt.Printf("%s %s\n", time.Now().Format("15:04:05"), line)
+	appLogger.Info("WATCH", "%s", line)
+}
+
+// runWatch watches the HF cache and applies the watch policy after every change until
+// interrupted: new downloads are linked once complete, outdated links are reported and
+// stale links purged, as configured by watch_policy
+func runWatch(ctx context.Context, args []string, cfg *config.Config, appLogger *logger.Logger) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	debounce := flags.Duration("debounce", watch.DefaultDebounce, "How long the cache must be quiet before acting on a change")
+	poll := flags.Bool("poll", false, "Poll for changes instead of using inotify")
+	interval := flags.Duration("interval", watch.DefaultPollInterval, "How often to poll for changes")
+	linkExisting := flags.Bool("link-existing", false, "Also link models that were downloaded before the watch started")
+	dryRun := flags.Bool("dry-run", false, "Only print what would be done")
+	flags.Parse(args)
+
+	policy, err := watch.ParsePolicy(cfg.Strings("watch_policy"))
+	if err != nil {
+		return fmt.Errorf("invalid watch_policy from %s: %v", cfg.Source("watch_policy"), err)
+	}
+	ruleSet, err := loadRules(cfg)
+	if err != nil {
+		return err
+	}
+	targetDir := findTargetDir(flags.Args(), cfg, appLogger)
+	caches, err := fsutils.GetHfCacheDirs()
+	if err != nil {
+		return err
+	}
+	w, err := watch.Watch(ctx, watch.Options{
+		Debounce:     *debounce,
+		PollInterval: *interval,
+		Poll:         *poll,
+		Ignore:       func(name string) bool { return name == lock.FileName },
+	}, caches...)
+	if err != nil {
+		return err
+	}
+	backend := w.Backend
+	if w.Backend == watch.BackendPolling {
+		backend += " every " + interval.String()
+	}
+	if w.Fallback != nil {
+		backend += fmt.Sprintf("; inotify unavailable: %v", w.Fallback)
+	}
+	watchf(appLogger, "Watching %s (%s) for %s, policy: %s", strings.Join(caches, ", "), backend, targetDir, policy)
+
+	planner := watch.NewPlanner(policy, fsutils.LinkOptions{Mode: fsutils.LinkMode(cfg.String("link_mode"))}, *linkExisting)
+	pass := func() {
+		if err := watchPass(ctx, planner, targetDir, caches, ruleSet, *dryRun, cfg.Int("workers"), appLogger); err != nil && ctx.Err() == nil {
+			watchf(appLogger, "Pass failed: %v", err)
+		}
+	}
+	pass()
+	for range w.C {
+		pass()
+	}
+	watchf(appLogger, "Stopped watching")
+	return nil
+}
+
+// watchPass scans the cache and the target directory and carries out what the planner
+// decides, taking the lock for the length of the pass
+func watchPass(ctx context.Context, planner *watch.Planner, targetDir string, caches []string, ruleSet *rules.Set, dryRun bool, workers int, appLogger *logger.Logger) error {
+	if !dryRun {
+		l, err := lock.Wait(ctx, func(held *lock.HeldError) {
+			watchf(appLogger, "Waiting for %s to finish...", held.Holder)
+		}, append([]string{targetDir}, caches...)...)
+		if err != nil {
+			return err
+		}
+		defer l.Release()
+		cleanupStaging(targetDir, appLogger)
+	}
+	models, err := fsutils.LoadModels(targetDir)
+	if err != nil {
+		return err
+	}
+	ruleSet.Apply(models)
+	stale, err := fsutils.FindStaleLinks(targetDir)
+	if err != nil {
+		return err
+	}
+
+	plan := planner.Plan(models, stale)
+	for _, n := range plan.Notices {
+		switch n.Kind {
+		case watch.NoticeDeferred:
+			watchf(appLogger, "waiting  %s: %s", n.Repo, n.Reason)
+		case watch.NoticeExcluded:
+			watchf(appLogger, "skip     %s: %s", n.Repo, n.Reason)
+		case watch.NoticeOutdated:
+			watchf(appLogger, "outdated %s: %s", n.Repo, n.Reason)
+		}
+	}
+	if dryRun {
+		actions := map[string]string{journal.StepLink: "link", journal.StepUnlink: "purge"}
+		for _, step := range plan.Steps {
+			watchf(appLogger, "would %s %s", actions[step.Action], step.Repo)
+		}
+		return nil
+	}
+	verbs := map[string]string{journal.StepLink: "linked", journal.StepUnlink: "purged"}
+	if len(plan.Steps) == 0 {
+		return nil
+	}
+	_, err = journal.OpenDefault().RunWith(ctx, "watch", targetDir, plan.Steps, journal.RunOptions{
+		Workers: workers,
+		OnStep: func(i int, stepErr error) {
+			step := plan.Steps[i]
+			switch {
+			case errors.Is(stepErr, context.Canceled):
+			case stepErr != nil:
+				watchf(appLogger, "failed   %s: %v", step.Repo, stepErr)
+			default:
+				watchf(appLogger, "%-8s %s", verbs[step.Action], step.Repo)
+			}
+		},
+	})
+	return err
+}
+
+// runApply converges the target directory to a desired-state file: it links missing
+// models, relinks changed ones and unlinks managed links that are not listed
+func runApply(ctx context.Context, args []string, cfg *config.Config, appLogger *logger.Logger) error {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	file := flags.String("file", cfg.String("desired_file"), "Desired-state file (default: models.toml or models.yaml in the current or config directory)")
+	dryRun := flags.Bool("dry-run", false, "Only print the drift from the desired state")
+	yes := flags.Bool("yes", false, "Apply without asking for confirmation")
+	keepExtras := flags.Bool("keep-extras", false, "Do not unlink managed models missing from the file")
+	flags.Parse(args)
+
+	targetDir := findTargetDir(flags.Args(), cfg, appLogger)
+	path := *file
+	if path == "" {
+		found, err := desired.FindFile()
+		if err != nil {
+			return err
+		}
+		path = found
+	}
+	state, err := desired.Load(path)
+	if err != nil {
+		return err
+	}
+	appLogger.Info("APPLY", "Using desired state from %s", path)
+	ruleSet, err := loadRules(cfg)
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		l, err := lockDirs(ctx, appLogger, targetDir)
+		if err != nil {
+			return err
+		}
+		defer l.Release()
+	}
+	models, err := fsutils.LoadModels(targetDir)
+	if err != nil {
+		return err
+	}
+	ruleSet.Apply(models)
+	stale, err := fsutils.FindStaleLinks(targetDir)
+	if err != nil {
+		return err
+	}
+	plan := desired.Diff(state, models, stale, desired.Options{KeepExtras: *keepExtras, DefaultMode: fsutils.LinkMode(cfg.String("link_mode"))})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tREPO\tREASON")
+	for _, a := range plan.Actions {
+		if a.Kind != desired.ActionOK {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", a.Kind, a.Repo, a.Reason)
+		}
+	}
+	w.Flush()
+	fmt.Printf("\n%s: %s\n", path, plan.Summary())
+
+	if *dryRun || plan.Count(desired.ActionLink)+plan.Count(desired.ActionRelink)+plan.Count(desired.ActionUnlink) == 0 {
+		return nil
+	}
+	if !*yes && !confirm(ctx, "Apply these changes?") {
+		fmt.Println("Aborted.")
+		return nil
+	}
+	results, err := desired.Apply(ctx, plan, journal.OpenDefault(), "apply", targetDir)
+	if err != nil {
+		return err
+	}
+	failed, skipped := 0, 0
+	for _, r := range results {
+		if errors.Is(r.Err, context.Canceled) {
+			skipped++
+			continue
+		}
+		if r.Err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s %s failed: %v\n", r.Action.Kind, r.Action.Repo, r.Err)
+			appLogger.Error("APPLY", "%s %s failed: %v", r.Action.Kind, r.Action.Repo, r.Err)
+			continue
+		}
+		appLogger.Info("APPLY", "%s %s", r.Action.Kind, r.Action.Repo)
+	}
+	if skipped > 0 {
+		return fmt.Errorf("%w: %d of %d action(s) were skipped", errInterrupted, skipped, len(results))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d action(s) failed", failed)
+	}
+	fmt.Println("Applied.")
+	return nil
+}
+
+// runExport writes the current link set to a portable file that import can reproduce
+func runExport(ctx context.Context, args []string, cfg *config.Config, appLogger *logger.Logger) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("output", "", "File to write (.toml, .yaml or .yml); default prints TOML to stdout")
+	flags.Parse(args)
+
+	targetDir := findTargetDir(flags.Args(), cfg, appLogger)
+	models, err := fsutils.LoadModels(targetDir)
+	if err != nil {
+		return err
+	}
+	state := desired.Export(models, targetDir)
+	if *output == "" {
+		return toml.NewEncoder(os.Stdout).Encode(state)
+	}
+	if err := desired.Save(*output, state); err != nil {
+		return err
+	}
+	appLogger.Info("EXPORT", "Exported %d links to %s", len(state.Models), *output)
+	fmt.Printf("Exported %d linked model(s) to %s\n", len(state.Models), *output)
+	return nil
+}
+
+// runImport links the models of an exported link set into the local target directory and
+// reports the entries that cannot be satisfied from the local HF cache
+func runImport(ctx context.Context, args []string, cfg *config.Config, appLogger *logger.Logger) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Only print what would be linked")
+	yes := flags.Bool("yes", false, "Link without asking for confirmation")
+	mode := flags.String("mode", "", "Override the link mode of every entry (symlink, hardlink or copy)")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		return fmt.Errorf("usage: import [options] <file> [target_directory]")
+	}
+	state, err := desired.Load(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *mode != "" {
+		linkMode, err := fsutils.ParseLinkMode(*mode)
+		if err != nil {
+			return err
+		}
+		for i := range state.Models {
+			state.Models[i].Mode = linkMode
+		}
+	}
+	ruleSet, err := loadRules(cfg)
+	if err != nil {
+		return err
+	}
+	targetDir := findTargetDir(flags.Args()[1:], cfg, appLogger)
+	if !*dryRun {
+		l, err := lockDirs(ctx, appLogger, targetDir)
+		if err != nil {
+			return err
+		}
+		defer l.Release()
+	}
+	models, err := fsutils.LoadModels(targetDir)
+	if err != nil {
+		return err
+	}
+	ruleSet.Apply(models)
+	// Links that exist only on this machine are kept.
+	plan := desired.Diff(state, models, nil, desired.Options{KeepExtras: true, DefaultMode: fsutils.LinkMode(cfg.String("link_mode"))})
+
+	var unavailable []desired.Action
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tREPO\tREASON")
+	for _, a := range plan.Actions {
+		switch a.Kind {
+		case desired.ActionOK:
+		case desired.ActionUnavailable:
+			unavailable = append(unavailable, a)
+		default:
+			fmt.Fprintf(w, "%s\t%s\t%s\n", a.Kind, a.Repo, a.Reason)
+		}
+	}
+	w.Flush()
+	if state.Target != "" {
+		fmt.Printf("\nImporting link set exported from %s into %s: %s\n", state.Target, targetDir, plan.Summary())
+	} else {
+		fmt.Printf("\nImporting into %s: %s\n", targetDir, plan.Summary())
+	}
+
+	failed, skipped := 0, 0
+	if !*dryRun && plan.Count(desired.ActionLink)+plan.Count(desired.ActionRelink) > 0 {
+		if !*yes && !confirm(ctx, "Link these models?") {
+			fmt.Println("Aborted.")
+			return nil
+		}
+		results, err := desired.Apply(ctx, plan, journal.OpenDefault(), "import", targetDir)
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			if errors.Is(r.Err, context.Canceled) {
+				skipped++
+				continue
+			}
+			if r.Err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "%s %s failed: %v\n", r.Action.Kind, r.Action.Repo, r.Err)
+				appLogger.Error("IMPORT", "%s %s failed: %v", r.Action.Kind, r.Action.Repo, r.Err)
+				continue
+			}
+			appLogger.Info("IMPORT", "%s %s", r.Action.Kind, r.Action.Repo)
+		}
+	}
+
+	if len(unavailable) > 0 {
+		fmt.Printf("\n%d of %d entries could not be satisfied locally:\n", len(unavailable), len(state.Models))
+		for _, a := range unavailable {
+			fmt.Printf("  %s: %s\n", a.Repo, a.Reason)
+		}
+	}
+	if skipped > 0 {
+		return fmt.Errorf("%w: %d link(s) were skipped", errInterrupted, skipped)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d link(s) failed", failed)
+	}
+	return nil
+}
+
+// configEntry is the machine-readable form of a setting in `config show --json`
+type configEntry struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// runConfig prints the effective configuration with the source of every value
+func runConfig(ctx context.Context, args []string, cfg *config.Config, appLogger *logger.Logger) error {
+	if len(args) == 0 || args[0] != "show" {
+		return fmt.Errorf("usage: config show [--json]")
+	}
+	flags := flag.NewFlagSet("config show", flag.ExitOnError)
+	jsonFlag := flags.Bool("json", false, "Print machine-readable JSON")
+	flags.Parse(args[1:])
+
+	var entries []configEntry
+	for _, key := range config.Keys() {
+		entries = append(entries, configEntry{Key: key, Value: cfg.Format(key), Source: cfg.Source(key).String()})
+	}
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	if len(cfg.Files) == 0 {
+		fmt.Println("No config files found.")
+	} else {
+		fmt.Println("Config files (later files win):")
+		for _, path := range cfg.Files {
+			fmt.Printf("  %s\n", path)
+		}
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.Key, e.Value, e.Source)
+	}
+	return w.Flush()
+}
+
+// runHistory prints the journaled operations, newest first
+func runHistory(ctx context.Context, args []string, cfg *config.Config, appLogger *logger.Logger) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	limit := flags.Int("n", 20, "Number of operations to show (0 for all)")
+	showSteps := flags.Bool("steps", false, "Also print the steps of every operation")
+	flags.Parse(args)
+
+	ops, err := journal.OpenDefault().List()
+	if err != nil {
+		return err
+	}
+	if len(ops) == 0 {
+		fmt.Println("No operations recorded.")
+		return nil
+	}
+	if *limit > 0 && len(ops) > *limit {
+		ops = ops[:*limit]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tSTATUS\tOPERATION\tTARGET")
+	for _, op := range ops {
+		status := string(op.Status)
+		switch {
+		case op.Status == journal.StatusRunning:
+			status = "interrupted"
+		case op.Irreversible:
+			status = "irreversible"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", op.Started.Format("2006-01-02 15:04:05"), status, op.Describe(), op.TargetDir)
+		if *showSteps {
+			for _, step := range op.Steps {
+				state := "pending"
+				switch {
+				case step.Done:
+					state = "done"
+				case step.Error != "":
+					state = "failed: " + step.Error
+				case step.Started:
+					state = "started"
+				}
+				fmt.Fprintf(w, "\t\t  %s %s\t%s\n", step.Action, step.Repo, state)
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// runUndo reverses the last N journaled operations. An interrupted operation is rolled back.
+func runUndo(ctx context.Context, args []string, cfg *config.Config, appLogger *logger.Logger) error {
+	flags := flag.NewFlagSet("undo", flag.ExitOnError)
+	count := flags.Int("n", 1, "Number of operations to undo")
+	yes := flags.Bool("yes", false, "Undo without asking for confirmation")
+	flags.Parse(args)
+
+	j := journal.OpenDefault()
+	ops, err := j.Undoable(*count)
+	if err != nil {
+		return err
+	}
+	l, err := lockDirs(ctx, appLogger, opTargets(ops)...)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	// The history may have changed while waiting for another instance.
+	if ops, err = j.Undoable(*count); err != nil {
+		return err
+	}
+	fmt.Println("Operations to undo, newest first:")
+	for _, op := range ops {
+		fmt.Printf("  %s  %s\n", op.Started.Format("2006-01-02 15:04:05"), op.Describe())
+	}
+	if len(ops) < *count {
+		fmt.Printf("(only %d of %d operation(s) can be undone)\n", len(ops), *count)
+	}
+	if !*yes && !confirm(ctx, "Undo these operations?") {
+		fmt.Println("Aborted.")
+		return nil
+	}
+	undone, err := j.Undo(len(ops))
+	for _, op := range undone {
+		appLogger.Info("UNDO", "Undid %s", op.Describe())
+	}
+	fmt.Printf("Undid %d operation(s).\n", len(undone))
+	return err
+}
+
+// opTargets returns the target directories of journaled operations
+func opTargets(ops []*journal.Op) []string {
+	var dirs []string
+	for _, op := range ops {
+		dirs = append(dirs, op.TargetDir)
+	}
+	return dirs
+}
+
+// runResume completes operations that were interrupted before they finished
+func runResume(ctx context.Context, args []string, cfg *config.Config, appLogger *logger.Logger) error {
+	flags := flag.NewFlagSet("resume", flag.ExitOnError)
+	yes := flags.Bool("yes", false, "Resume without asking for confirmation")
+	flags.Parse(args)
+
+	j := journal.OpenDefault()
+	interrupted, err := j.Interrupted()
+	if err != nil {
+		return err
+	}
+	// Operations of another running instance look interrupted until it finishes them.
+	l, err := lockDirs(ctx, appLogger, opTargets(interrupted)...)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	if interrupted, err = j.Interrupted(); err != nil {
+		return err
+	}
+	if len(interrupted) == 0 {
+		fmt.Println("No interrupted operations.")
+		return nil
+	}
+	failed := 0
+	// Resume oldest first so that later operations see the state they expected.
+	for i := len(interrupted) - 1; i >= 0; i-- {
+		op := interrupted[i]
+		if !*yes && !confirm(ctx, fmt.Sprintf("Resume %s started %s?", op.Describe(), op.Started.Format("2006-01-02 15:04:05"))) {
+			fmt.Println("Skipped; run `undo` to roll it back instead.")
+			continue
+		}
+		for _, err := range op.Resume(ctx) {
+			if errors.Is(err, context.Canceled) {
+				return fmt.Errorf("%w: %s is still interrupted", errInterrupted, op.Describe())
+			}
+			failed++
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			appLogger.Error("RESUME", "%v", err)
+		}
+		appLogger.Info("RESUME", "Resumed %s", op.Describe())
+		fmt.Printf("Resumed %s\n", op.Describe())
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d step(s) failed", failed)
+	}
+	return nil
+}
@@ -2,12 +2,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jmfirth/hf-lms-sync/internal/config"
 	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
 	"github.com/jmfirth/hf-lms-sync/internal/logger"
 	"github.com/jmfirth/hf-lms-sync/internal/ui"
@@ -19,20 +24,124 @@ func printUsage() {
 	fmt.Println("")
 	fmt.Println("Usage:")
 	fmt.Println("  hf-lms-sync [options] [target_directory]")
+	fmt.Println("  hf-lms-sync [options] <command> [command options] [target_directory]")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	fmt.Println("  config show  Print the effective configuration and where each value came from")
+	fmt.Println("  apply        Link, relink and unlink models to match models.toml/models.yaml (--file, --dry-run)")
+	fmt.Println("  export       Write the current link set to a portable file (--output models.toml)")
+	fmt.Println("  history      List recorded operations, newest first (-n N, --steps)")
+	fmt.Println("  import       Reproduce an exported link set here: import [--mode copy] <file> [target_directory]")
+	fmt.Println("  link-all     Link every unlinked model the include/exclude rules allow (--dry-run)")
+	fmt.Println("  list         Print models with their status and disk usage (--json for machine-readable output)")
+	fmt.Println("  prune        Delete unused revisions, orphan blobs and abandoned downloads from the HF cache")
+	fmt.Println("  resume       Finish operations that were interrupted by a crash")
+	fmt.Println("  undo         Reverse the last operations or roll back an interrupted one (-n N, --yes)")
+	fmt.Println("  verify       Hash linked files and check them against their blob names (--workers N)")
+	fmt.Println("  watch        Link new downloads as they finish and apply watch_policy until interrupted (--poll)")
 	fmt.Println("")
 	fmt.Println("Options:")
 	fmt.Println("  --verbose    Enable detailed logging to hf-lmfs-sync.log in the current directory")
+	fmt.Println("  --extra-target dir")
+	fmt.Println("               Another LM Studio models directory to unlink from when deleting models (repeatable)")
+	fmt.Println("  --desired file")
+	fmt.Println("               Desired-state file compared against in the drift view (default: models.toml or models.yaml)")
+	fmt.Println("  --config file")
+	fmt.Println("               User config file (default: config.toml in the hf-lms-sync user config directory)")
+	fmt.Println("  --hf-cache dir")
+	fmt.Println("               Hugging Face cache directory (default: detected for your operating system)")
+	fmt.Println("  --extra-cache dir")
+	fmt.Println("               Another Hugging Face cache to read models from after --hf-cache (repeatable)")
+	fmt.Println("  --link-mode mode")
+	fmt.Println("               How to link files: symlink (default), hardlink or copy")
+	fmt.Println("  --workers n  Models linked or unlinked at once by bulk operations (default 4)")
+	fmt.Println("  --wait       Wait for another running hf-lms-sync to finish instead of failing (for scripts)")
 	fmt.Println("  --help       Display this help message")
 	fmt.Println("")
 	fmt.Println("If no target_directory is provided, the tool will automatically determine")
 	fmt.Println("the LM Studio models cache directory based on your operating system.")
+	fmt.Println("")
+	fmt.Println("Settings are read from the user config file, a .hf-lms-sync.toml in the current")
+	fmt.Println("directory or a parent, HF_LMS_SYNC_* environment variables and flags, in")
+	fmt.Println("increasing order of precedence.")
 	os.Exit(0)
 }
 
+// errInterrupted is returned by commands stopped with Ctrl+C
+var errInterrupted = errors.New("interrupted")
+
+// interruptContext returns a context that the first Ctrl+C cancels, so that commands can
+// stop after cleaning up. A second Ctrl+C exits immediately.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		fmt.Fprintln(os.Stderr, "\nInterrupted, stopping... (press Ctrl+C again to quit immediately)")
+		cancel()
+	}()
+	return ctx
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// cleanupStaging removes staging directories from a target directory the caller has locked
+func cleanupStaging(targetDir string, appLogger *logger.Logger) {
+	cleaned, err := fsutils.CleanupStaging(targetDir)
+	if err != nil && !os.IsNotExist(err) {
+		appLogger.Error("MAIN", "Error cleaning up staging directories: %v", err)
+	}
+	for _, path := range cleaned {
+		appLogger.Info("MAIN", "Cleaned up interrupted link: %s", path)
+	}
+}
+
+// findTargetDir returns the target directory from the first positional argument, the
+// configured target, or the default LM Studio models directory, in that order
+func findTargetDir(args []string, cfg *config.Config, appLogger *logger.Logger) string {
+	if len(args) > 0 {
+		appLogger.Info("MAIN", "Using provided target directory: %s", args[0])
+		return args[0]
+	}
+	if target := cfg.String("target"); target != "" {
+		appLogger.Info("MAIN", "Using target directory from %s: %s", cfg.Source("target"), target)
+		return target
+	}
+	targetDir, err := fsutils.GetLmStudioModelsDir()
+	if err != nil {
+		appLogger.Error("MAIN", "Error determining LM Studio Models directory: %v", err)
+		log.Fatalf("Error determining LM Studio Models directory: %v", err)
+	}
+	appLogger.Info("MAIN", "Using default LM Studio Models directory: %s", targetDir)
+	return targetDir
+}
+
 func main() {
 	// Define command line flags
 	verboseFlag := flag.Bool("verbose", false, "Enable verbose logging to file")
 	helpFlag := flag.Bool("help", false, "Display help message")
+	var extraTargets stringList
+	flag.Var(&extraTargets, "extra-target", "Additional LM Studio models directory (repeatable)")
+	desiredFlag := flag.String("desired", "", "Desired-state file for the drift view")
+	configFlag := flag.String("config", "", "User config file")
+	hfCacheFlag := flag.String("hf-cache", "", "Hugging Face cache directory")
+	var extraCaches stringList
+	flag.Var(&extraCaches, "extra-cache", "Additional Hugging Face cache directory (repeatable)")
+	linkModeFlag := flag.String("link-mode", "", "Link mode: symlink, hardlink or copy")
+	workersFlag := flag.Int("workers", 0, "Models linked or unlinked at once by bulk operations")
+	flag.BoolVar(&waitForLock, "wait", false, "Wait for another instance to finish instead of failing")
 	
 	// Parse flags
 	flag.Parse()
@@ -42,54 +151,101 @@ func main() {
 		printUsage()
 	}
 
+	// Flags given on the command line override every config layer.
+	flags := map[string]config.Flag{}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "verbose":
+			flags["verbose"] = config.Flag{Name: "--verbose", Value: *verboseFlag}
+		case "extra-target":
+			flags["extra_targets"] = config.Flag{Name: "--extra-target", Value: []string(extraTargets)}
+		case "desired":
+			flags["desired_file"] = config.Flag{Name: "--desired", Value: *desiredFlag}
+		case "hf-cache":
+			flags["hf_cache"] = config.Flag{Name: "--hf-cache", Value: *hfCacheFlag}
+		case "extra-cache":
+			flags["extra_caches"] = config.Flag{Name: "--extra-cache", Value: []string(extraCaches)}
+		case "link-mode":
+			flags["link_mode"] = config.Flag{Name: "--link-mode", Value: *linkModeFlag}
+		case "workers":
+			flags["workers"] = config.Flag{Name: "--workers", Value: *workersFlag}
+		}
+	})
+	cfg, err := config.Load(config.Options{UserFile: *configFlag, Flags: flags})
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	linkMode, err := fsutils.ParseLinkMode(cfg.String("link_mode"))
+	if err != nil {
+		log.Fatalf("Invalid link_mode from %s: %v", cfg.Source("link_mode"), err)
+	}
+	fsutils.SetHfCacheDir(cfg.String("hf_cache"))
+	fsutils.SetExtraHfCacheDirs(cfg.Strings("extra_caches"))
+
 	// Initialize the logger
-	appLogger, err := logger.New(*verboseFlag)
+	verbose := cfg.Bool("verbose")
+	appLogger, err := logger.New(verbose)
 	if err != nil {
 		log.Fatalf("Failed to initialize logger: %v", err)
 	}
 	defer appLogger.Close()
 
-	// Determine LM Studio Models directory.
+	// Dispatch subcommands before falling back to the interactive UI.
 	args := flag.Args()
-	var targetDir string
 	if len(args) > 0 {
-		targetDir = args[0]
-		if *verboseFlag {
-			appLogger.Info("MAIN", "Using provided target directory: %s", targetDir)
-		}
-	} else {
-		var err error
-		targetDir, err = fsutils.GetLmStudioModelsDir()
-		if err != nil {
-			appLogger.Error("MAIN", "Error determining LM Studio Models directory: %v", err)
-			log.Fatalf("Error determining LM Studio Models directory: %v", err)
-		}
-		if *verboseFlag {
-			appLogger.Info("MAIN", "Using default LM Studio Models directory: %s", targetDir)
+		if command, ok := commands[args[0]]; ok {
+			if err := command(interruptContext(), args[1:], cfg, appLogger); err != nil {
+				appLogger.Error("MAIN", "%s: %v", args[0], err)
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				appLogger.Close()
+				if errors.Is(err, errInterrupted) {
+					os.Exit(130)
+				}
+				os.Exit(1)
+			}
+			return
 		}
 	}
 
-	// Determine and print Hugging Face cache directory.
-	hfCacheDir, err := fsutils.GetHfCacheDir()
+	// Determine LM Studio Models directory.
+	targetDir := findTargetDir(args, cfg, appLogger)
+
+	// Determine and print Hugging Face cache directories.
+	hfCacheDirs, err := fsutils.GetHfCacheDirs()
 	if err != nil {
 		appLogger.Error("MAIN", "Error determining Hugging Face cache directory: %v", err)
 		log.Fatalf("Error determining Hugging Face cache directory: %v", err)
 	}
 	
-	if *verboseFlag {
-		appLogger.Info("MAIN", "Hugging Face cache directory: %s", hfCacheDir)
+	if verbose {
+		appLogger.Info("MAIN", "Hugging Face cache directories: %s", strings.Join(hfCacheDirs, ", "))
 		appLogger.Info("MAIN", "Starting UI with target directory: %s", targetDir)
 	}
 
+	ruleSet, err := loadRules(cfg)
+	if err != nil {
+		appLogger.Error("MAIN", "Invalid include/exclude rules: %v", err)
+		log.Fatalf("Invalid include/exclude rules: %v", err)
+	}
+
 	// Start the Bubble Tea program with the logger
-	p := tea.NewProgram(ui.New(targetDir, appLogger))
+	p := tea.NewProgram(ui.New(ui.Options{
+		TargetDir:    targetDir,
+		ExtraTargets: cfg.Strings("extra_targets"),
+		DesiredFile:  cfg.String("desired_file"),
+		LinkMode:     linkMode,
+		Theme:        cfg.Theme(),
+		KeyBindings:  cfg.KeyBindings(),
+		Rules:        ruleSet,
+		Workers:      cfg.Int("workers"),
+	}, appLogger))
 	if err := p.Start(); err != nil {
 		appLogger.Error("MAIN", "Error running program: %v", err)
 		fmt.Fprintf(os.Stderr, "Error running program: %v\n", err)
 		os.Exit(1)
 	}
 	
-	if *verboseFlag {
+	if verbose {
 		appLogger.Info("MAIN", "Application terminated normally")
 	}
 }
@@ -0,0 +1,51 @@
+// Package cachetest builds Hugging Face cache fixtures for tests, laid out the way
+// huggingface_hub writes them so that code resolving snapshot symlinks into blobs/ is
+// exercised against the real layout.
+package cachetest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// MakeRepo creates a Hugging Face cache repository fixture under hfCache. Each file is
+// stored as a blob named by its sha256 and symlinked from snapshots/<revision>, mirroring
+// the layout written by huggingface_hub. refs/main points at the given revision.
+func MakeRepo(t *testing.T, hfCache, cacheDirName, revision string, files map[string]string) string {
+	t.Helper()
+	repoPath := filepath.Join(hfCache, cacheDirName)
+	blobsPath := filepath.Join(repoPath, "blobs")
+	snapPath := filepath.Join(repoPath, "snapshots", revision)
+	refsPath := filepath.Join(repoPath, "refs")
+	for _, dir := range []string{blobsPath, snapPath, refsPath} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+	}
+	for name, content := range files {
+		sum := sha256.Sum256([]byte(content))
+		blob := filepath.Join(blobsPath, hex.EncodeToString(sum[:]))
+		if err := ioutil.WriteFile(blob, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write blob: %v", err)
+		}
+		link := filepath.Join(snapPath, name)
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			t.Fatalf("failed to create snapshot subdirectory: %v", err)
+		}
+		rel, err := filepath.Rel(filepath.Dir(link), blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(rel, link); err != nil {
+			t.Fatalf("failed to create snapshot symlink: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(refsPath, "main"), []byte(revision), 0644); err != nil {
+		t.Fatalf("failed to write ref: %v", err)
+	}
+	return repoPath
+}
@@ -0,0 +1,450 @@
+// Package config loads settings from layered sources. Later layers win:
+// defaults < user config file < project config file < environment < flags.
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	// ProjectFileName is looked up in the working directory and its parents.
+	ProjectFileName = ".hf-lms-sync.toml"
+	// UserFileName is the config file inside the user configuration directory.
+	UserFileName = "config.toml"
+	// EnvPrefix prefixes the environment variable of every key, e.g. HF_LMS_SYNC_LINK_MODE.
+	EnvPrefix = "HF_LMS_SYNC_"
+)
+
+// Layer names reported as the source of a value.
+const (
+	LayerDefault = "default"
+	LayerUser    = "user"
+	LayerProject = "project"
+	LayerEnv     = "env"
+	LayerFlag    = "flag"
+)
+
+// kind is the type of a setting.
+type kind int
+
+const (
+	kindString kind = iota
+	kindList
+	kindBool
+	kindInt
+)
+
+// settings lists the top-level keys with their types and defaults.
+var settings = map[string]struct {
+	kind  kind
+	value interface{}
+}{
+	"hf_cache":      {kindString, ""},
+	"extra_caches":  {kindList, []string{}},
+	"target":        {kindString, ""},
+	"extra_targets": {kindList, []string{}},
+	"link_mode":     {kindString, "symlink"},
+	"desired_file":  {kindString, ""},
+	"include":       {kindList, []string{}},
+	"exclude":       {kindList, []string{}},
+	"verbose":       {kindBool, false},
+	"workers":       {kindInt, 4},
+	"watch_policy":  {kindList, []string{"link", "mark_outdated"}},
+}
+
+// DefaultTheme holds the built-in colors, settable as theme.<name>.
+var DefaultTheme = map[string]string{
+	"accent":     "#7D56F4",
+	"info":       "#3B82F6",
+	"status":     "#333333",
+	"text":       "#FFFFFF",
+	"muted":      "#888888",
+	"linked":     "#48BB78",
+	"unlinked":   "#F6AD55",
+	"stale":      "#F56565",
+	"incomplete": "#63B3ED",
+	"corrupt":    "#F56565",
+	"excluded":   "#555555",
+}
+
+// DefaultKeys holds the built-in key bindings, settable as keys.<action>.
+var DefaultKeys = map[string][]string{
+	"up":          {"up", "k"},
+	"down":        {"down", "j"},
+	"home":        {"home"},
+	"end":         {"end"},
+	"search":      {"/"},
+	"link":        {"l"},
+	"unlink":      {"u"},
+	"purge":       {"c"},
+	"link_all":    {"L"},
+	"unlink_all":  {"U"},
+	"purge_all":   {"C"},
+	"prune":       {"P"},
+	"delete":      {"X"},
+	"format":      {"f"},
+	"status":      {"s"},
+	"sort":        {"o"},
+	"details":     {"i"},
+	"detail_up":   {"K", "shift+up"},
+	"detail_down": {"J", "shift+down"},
+	"verify":      {"v"},
+	"verify_all":  {"V"},
+	"drift":       {"D"},
+	"apply":       {"a"},
+	"undo":        {"z"},
+	"cancel":      {"esc"},
+	"errors":      {"e"},
+	"retry":       {"r"},
+	"retry_all":   {"R"},
+	"rescan":      {"ctrl+r"},
+	"mark":        {"space"},
+	"mark_range":  {"m"},
+	"mark_all":    {"ctrl+a"},
+	"toggle_help": {"?"},
+	"quit":        {"q", "ctrl+c"},
+}
+
+// Source tells where an effective value came from.
+type Source struct {
+	Layer string
+	// Origin is the file path, environment variable or flag name that set the value.
+	Origin string
+}
+
+// String renders a source as e.g. "project (/work/.hf-lms-sync.toml)".
+func (s Source) String() string {
+	if s.Origin == "" {
+		return s.Layer
+	}
+	return s.Layer + " (" + s.Origin + ")"
+}
+
+// Config holds the effective value and source of every key.
+type Config struct {
+	values  map[string]interface{}
+	sources map[string]Source
+	// Files are the config files that were read, in precedence order.
+	Files []string
+}
+
+// Options tells Load where to look.
+type Options struct {
+	// UserFile replaces the default user config file when set.
+	UserFile string
+	// Dir is where the project file search starts; defaults to the working directory.
+	Dir string
+	// Flags holds values given on the command line, keyed like the config file.
+	Flags map[string]Flag
+}
+
+// Flag is a value set on the command line.
+type Flag struct {
+	// Name is the flag as typed, e.g. "--extra-target".
+	Name  string
+	Value interface{}
+}
+
+// lookup returns the kind and default of a key, including theme.* and keys.* keys.
+func lookup(key string) (kind, interface{}, bool) {
+	if s, ok := settings[key]; ok {
+		return s.kind, s.value, true
+	}
+	if name, ok := strings.CutPrefix(key, "theme."); ok {
+		if v, ok := DefaultTheme[name]; ok {
+			return kindString, v, true
+		}
+	}
+	if name, ok := strings.CutPrefix(key, "keys."); ok {
+		if v, ok := DefaultKeys[name]; ok {
+			return kindList, v, true
+		}
+	}
+	return 0, nil, false
+}
+
+// Keys returns every known key in sorted order.
+func Keys() []string {
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	for name := range DefaultTheme {
+		keys = append(keys, "theme."+name)
+	}
+	for name := range DefaultKeys {
+		keys = append(keys, "keys."+name)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EnvVar returns the environment variable for a key, e.g. HF_LMS_SYNC_THEME_LINKED.
+func EnvVar(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// DefaultUserFile returns the path of the user config file.
+func DefaultUserFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "hf-lms-sync", UserFileName), nil
+}
+
+// FindProjectFile returns the closest project config file in dir or its parents.
+func FindProjectFile(dir string) (string, bool) {
+	for {
+		path := filepath.Join(dir, ProjectFileName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// Load merges all layers into the effective configuration.
+func Load(opts Options) (*Config, error) {
+	c := &Config{values: map[string]interface{}{}, sources: map[string]Source{}}
+	for _, key := range Keys() {
+		_, value, _ := lookup(key)
+		c.values[key] = value
+		c.sources[key] = Source{Layer: LayerDefault}
+	}
+
+	userFile := opts.UserFile
+	if userFile == "" {
+		userFile = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if userFile == "" {
+		if path, err := DefaultUserFile(); err == nil {
+			userFile = path
+		}
+	}
+	if userFile != "" {
+		if _, err := os.Stat(userFile); err == nil {
+			if err := c.loadFile(userFile, LayerUser); err != nil {
+				return nil, err
+			}
+		} else if opts.UserFile != "" {
+			return nil, fmt.Errorf("config file %s: %v", userFile, err)
+		}
+	}
+
+	dir := opts.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	if dir != "" {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		if path, ok := FindProjectFile(dir); ok && path != userFile {
+			if err := c.loadFile(path, LayerProject); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, key := range Keys() {
+		name := EnvVar(key)
+		if raw, ok := os.LookupEnv(name); ok {
+			if err := c.set(key, raw, Source{Layer: LayerEnv, Origin: name}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for key, flag := range opts.Flags {
+		if err := c.set(key, flag.Value, Source{Layer: LayerFlag, Origin: flag.Name}); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// loadFile reads a TOML config file into the configuration.
+func (c *Config) loadFile(path, layer string) error {
+	var raw map[string]interface{}
+	if _, err := toml.DecodeFile(path, &raw); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	flat := map[string]interface{}{}
+	flatten("", raw, flat)
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := c.set(key, flat[key], Source{Layer: layer, Origin: path}); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	c.Files = append(c.Files, path)
+	return nil
+}
+
+// flatten turns nested tables into dotted keys.
+func flatten(prefix string, in map[string]interface{}, out map[string]interface{}) {
+	for key, value := range in {
+		if table, ok := value.(map[string]interface{}); ok {
+			flatten(prefix+key+".", table, out)
+			continue
+		}
+		out[prefix+key] = value
+	}
+}
+
+// set converts a value to the key's type and records its source. Strings are accepted for
+// every type so that environment variables can set lists ("a,b") and booleans.
+func (c *Config) set(key string, value interface{}, source Source) error {
+	k, _, ok := lookup(key)
+	if !ok {
+		return fmt.Errorf("unknown config key %q", key)
+	}
+	var converted interface{}
+	switch k {
+	case kindString:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", key)
+		}
+		converted = s
+	case kindBool:
+		switch v := value.(type) {
+		case bool:
+			converted = v
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false", key)
+			}
+			converted = b
+		default:
+			return fmt.Errorf("%s must be true or false", key)
+		}
+	case kindInt:
+		switch v := value.(type) {
+		case int:
+			converted = v
+		case int64:
+			converted = int(v)
+		case string:
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return fmt.Errorf("%s must be a whole number", key)
+			}
+			converted = n
+		default:
+			return fmt.Errorf("%s must be a whole number", key)
+		}
+	case kindList:
+		switch v := value.(type) {
+		case []string:
+			converted = v
+		case string:
+			converted = splitList(v)
+		case []interface{}:
+			list := make([]string, 0, len(v))
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("%s must be a list of strings", key)
+				}
+				list = append(list, s)
+			}
+			converted = list
+		default:
+			return fmt.Errorf("%s must be a list of strings", key)
+		}
+	}
+	c.values[key] = converted
+	c.sources[key] = source
+	return nil
+}
+
+// splitList splits a comma-separated environment value.
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// String returns a string setting.
+func (c *Config) String(key string) string {
+	s, _ := c.values[key].(string)
+	return s
+}
+
+// Strings returns a list setting.
+func (c *Config) Strings(key string) []string {
+	list, _ := c.values[key].([]string)
+	return list
+}
+
+// Bool returns a boolean setting.
+func (c *Config) Bool(key string) bool {
+	b, _ := c.values[key].(bool)
+	return b
+}
+
+// Int returns a numeric setting.
+func (c *Config) Int(key string) int {
+	n, _ := c.values[key].(int)
+	return n
+}
+
+// Source returns where the effective value of a key came from.
+func (c *Config) Source(key string) Source {
+	return c.sources[key]
+}
+
+// Format renders the effective value of a key for display.
+func (c *Config) Format(key string) string {
+	switch v := c.values[key].(type) {
+	case []string:
+		return "[" + strings.Join(v, ", ") + "]"
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case string:
+		return strconv.Quote(v)
+	}
+	return ""
+}
+
+// Theme returns the effective colors by name.
+func (c *Config) Theme() map[string]string {
+	theme := make(map[string]string, len(DefaultTheme))
+	for name := range DefaultTheme {
+		theme[name] = c.String("theme." + name)
+	}
+	return theme
+}
+
+// KeyBindings returns the effective key bindings by action.
+func (c *Config) KeyBindings() map[string][]string {
+	bindings := make(map[string][]string, len(DefaultKeys))
+	for name := range DefaultKeys {
+		bindings[name] = c.Strings("keys." + name)
+	}
+	return bindings
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestLoadPrecedence tests that flags beat env, env beats the project file, the project
+// file beats the user file and the user file beats defaults.
+func TestLoadPrecedence(t *testing.T) {
+	root := t.TempDir()
+	userFile := filepath.Join(root, "user.toml")
+	ioutil.WriteFile(userFile, []byte(`
+target = "/user/target"
+link_mode = "copy"
+desired_file = "/user/models.toml"
+workers = 16
+extra_targets = ["/user/extra"]
+
+[theme]
+linked = "#00FF00"
+`), 0644)
+	project := filepath.Join(root, "project", "sub")
+	writeProject := func(content string) {
+		if err := ioutil.WriteFile(filepath.Join(root, "project", ProjectFileName), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(project, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeProject(`
+target = "/project/target"
+link_mode = "hardlink"
+
+[keys]
+link = ["enter", "l"]
+`)
+	t.Setenv("HF_LMS_SYNC_LINK_MODE", "symlink")
+	t.Setenv("HF_LMS_SYNC_EXTRA_TARGETS", "/env/a, /env/b")
+	t.Setenv("HF_LMS_SYNC_WORKERS", "8")
+
+	c, err := Load(Options{UserFile: userFile, Dir: project, Flags: map[string]Flag{"target": {Name: "--target", Value: "/flag/target"}}})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+
+	cases := []struct {
+		key   string
+		value interface{}
+		layer string
+	}{
+		{"target", "/flag/target", LayerFlag},
+		{"link_mode", "symlink", LayerEnv},
+		{"extra_targets", []string{"/env/a", "/env/b"}, LayerEnv},
+		{"keys.link", []string{"enter", "l"}, LayerProject},
+		{"desired_file", "/user/models.toml", LayerUser},
+		{"theme.linked", "#00FF00", LayerUser},
+		{"theme.stale", DefaultTheme["stale"], LayerDefault},
+		{"verbose", false, LayerDefault},
+		{"workers", 8, LayerEnv},
+	}
+	for _, tc := range cases {
+		if got := c.values[tc.key]; !reflect.DeepEqual(got, tc.value) {
+			t.Errorf("%s: expected %v, got %v", tc.key, tc.value, got)
+		}
+		if got := c.Source(tc.key).Layer; got != tc.layer {
+			t.Errorf("%s: expected source %s, got %s", tc.key, tc.layer, got)
+		}
+	}
+	if len(c.Files) != 2 {
+		t.Errorf("expected user and project files to be read, got %v", c.Files)
+	}
+
+	writeProject(`colour = "red"`)
+	if _, err := Load(Options{UserFile: userFile, Dir: project}); err == nil {
+		t.Errorf("expected error for an unknown key")
+	}
+	writeProject(`verbose = "loud"`)
+	if _, err := Load(Options{UserFile: userFile, Dir: project}); err == nil {
+		t.Errorf("expected error for a mistyped value")
+	}
+	writeProject(`workers = "many"`)
+	if _, err := Load(Options{UserFile: userFile, Dir: project}); err == nil {
+		t.Errorf("expected error for a non-numeric value")
+	}
+}
+
+// TestDefaultKeysDistinct tests that no key is bound to two actions by default, so that
+// e.g. cancelling an operation never also quits.
+func TestDefaultKeysDistinct(t *testing.T) {
+	actions := map[string]string{}
+	for action, keys := range DefaultKeys {
+		for _, key := range keys {
+			if other, ok := actions[key]; ok {
+				t.Errorf("%q is bound to both %s and %s", key, other, action)
+			}
+			actions[key] = action
+		}
+	}
+}
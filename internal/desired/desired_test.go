@@ -0,0 +1,202 @@
+package desired
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+	"github.com/jmfirth/hf-lms-sync/internal/journal"
+)
+
+// setup creates a Hugging Face cache with two GGUF repositories and returns the target
+// directory to link into.
+func setup(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	t.Setenv("HOME", root)
+	t.Setenv("XDG_CACHE_HOME", root)
+	hfCache, err := fsutils.GetHfCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"models--org--alpha", "models--org--beta"} {
+		cachetest.MakeRepo(t, hfCache, name, "rev1", map[string]string{"model.gguf": name})
+	}
+	targetDir := filepath.Join(root, "lmstudio")
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return targetDir
+}
+
+// scan returns the models and stale links of a target directory.
+func scan(t *testing.T, targetDir string) ([]fsutils.ModelInfo, []fsutils.ModelInfo) {
+	t.Helper()
+	models, err := fsutils.LoadModels(targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := fsutils.FindStaleLinks(targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return models, stale
+}
+
+// kinds maps repositories to their planned action.
+func kinds(plan Plan) map[string]ActionKind {
+	out := map[string]ActionKind{}
+	for _, a := range plan.Actions {
+		out[a.Repo] = a.Kind
+	}
+	return out
+}
+
+// TestLoadFormats tests that TOML and YAML files parse to the same state.
+func TestLoadFormats(t *testing.T) {
+	dir := t.TempDir()
+	tomlPath := filepath.Join(dir, "models.toml")
+	yamlPath := filepath.Join(dir, "models.yaml")
+	ioutil.WriteFile(tomlPath, []byte("[[models]]\nrepo = \"org/alpha\"\nfiles = [\"*Q4*\"]\n"), 0644)
+	ioutil.WriteFile(yamlPath, []byte("models:\n  - repo: org/alpha\n    files: [\"*Q4*\"]\n"), 0644)
+
+	for _, path := range []string{tomlPath, yamlPath} {
+		state, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load(%s) returned error: %v", path, err)
+		}
+		if len(state.Models) != 1 || state.Models[0].Repo != "org/alpha" || len(state.Models[0].Files) != 1 {
+			t.Errorf("unexpected state from %s: %+v", path, state)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.toml")
+	ioutil.WriteFile(bad, []byte("[[models]]\nrepo = \"alpha\"\n"), 0644)
+	if _, err := Load(bad); err == nil {
+		t.Errorf("expected error for a repo without an organization")
+	}
+}
+
+// TestDiffAndApply tests that applying a plan converges the target directory.
+func TestDiffAndApply(t *testing.T) {
+	targetDir := setup(t)
+	models, stale := scan(t, targetDir)
+	for _, m := range models {
+		if m.RepoID() == "org/beta" {
+			if err := fsutils.LinkModel(m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// A link LM Studio downloaded itself has no marker and must be left alone.
+	if err := os.MkdirAll(filepath.Join(targetDir, "lmstudio-community", "own"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	state := &State{Models: []Entry{{Repo: "org/alpha"}, {Repo: "org/missing"}}}
+	models, stale = scan(t, targetDir)
+	plan := Diff(state, models, stale, Options{})
+	got := kinds(plan)
+	want := map[string]ActionKind{"org/alpha": ActionLink, "org/missing": ActionUnavailable, "org/beta": ActionUnlink}
+	for repo, kind := range want {
+		if got[repo] != kind {
+			t.Errorf("expected %s to %s, got %s", repo, kind, got[repo])
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("unexpected actions: %v", got)
+	}
+	if kinds(Diff(state, models, stale, Options{KeepExtras: true}))["org/beta"] != "" {
+		t.Errorf("expected KeepExtras to leave org/beta alone")
+	}
+
+	results, err := Apply(context.Background(), plan, journal.Open(""), "apply", targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s %s failed: %v", r.Action.Kind, r.Action.Repo, r.Err)
+		}
+		if r.Step.Repo != r.Action.Repo {
+			t.Errorf("expected the step of %s to be reported with it, got %+v", r.Action.Repo, r.Step)
+		}
+	}
+	models, stale = scan(t, targetDir)
+	plan = Diff(state, models, stale, Options{})
+	if got := kinds(plan); got["org/alpha"] != ActionOK || got["org/beta"] != "" {
+		t.Errorf("expected target to be converged, got %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "lmstudio-community", "own")); err != nil {
+		t.Errorf("expected unmanaged directory to be kept")
+	}
+
+	// Changing the file selection requires a relink.
+	state.Models[0].Files = []string{"*.gguf"}
+	if kinds(Diff(state, models, stale, Options{}))["org/alpha"] != ActionRelink {
+		t.Errorf("expected a changed file selection to relink")
+	}
+
+	// Exclude rules block the relink but leave the existing link alone.
+	for i := range models {
+		if models[i].RepoID() == "org/alpha" {
+			models[i].IsExcluded, models[i].ExcludedBy = true, "org:org"
+		}
+	}
+	if kinds(Diff(state, models, stale, Options{}))["org/alpha"] != ActionUnavailable {
+		t.Errorf("expected an excluded model not to be relinked")
+	}
+}
+
+// TestExportImport tests that an exported link set reproduces the same links elsewhere.
+func TestExportImport(t *testing.T) {
+	targetDir := setup(t)
+	models, _ := scan(t, targetDir)
+	for _, m := range models {
+		if m.RepoID() == "org/alpha" {
+			if err := fsutils.LinkModelWith(context.Background(), m, fsutils.LinkOptions{Mode: fsutils.LinkCopy}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	models, _ = scan(t, targetDir)
+	exported := Export(models, targetDir)
+	if len(exported.Models) != 1 || exported.Models[0].Revision != "rev1" || exported.Models[0].Mode != fsutils.LinkCopy {
+		t.Fatalf("unexpected export: %+v", exported)
+	}
+	path := filepath.Join(t.TempDir(), "links.yaml")
+	if err := Save(path, exported); err != nil {
+		t.Fatalf("Save returned error: %v", err)
+	}
+	imported, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	imported.Models = append(imported.Models, Entry{Repo: "org/beta", Revision: "deadbeef"})
+
+	otherDir := filepath.Join(t.TempDir(), "other")
+	os.MkdirAll(otherDir, 0755)
+	models, stale := scan(t, otherDir)
+	plan := Diff(imported, models, stale, Options{KeepExtras: true})
+	got := kinds(plan)
+	if got["org/alpha"] != ActionLink || got["org/beta"] != ActionUnavailable {
+		t.Fatalf("unexpected import plan: %v", got)
+	}
+	results, err := Apply(context.Background(), plan, journal.Open(""), "import", otherDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Fatalf("%s failed: %v", r.Action.Repo, r.Err)
+		}
+	}
+	marker, err := fsutils.ReadMarker(filepath.Join(otherDir, "org", "alpha"))
+	if err != nil || marker.Revision != "rev1" || marker.Mode != fsutils.LinkCopy {
+		t.Errorf("unexpected imported link %+v (err %v)", marker, err)
+	}
+}
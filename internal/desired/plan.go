@@ -0,0 +1,240 @@
+package desired
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+	"github.com/jmfirth/hf-lms-sync/internal/journal"
+	"github.com/jmfirth/hf-lms-sync/internal/rules"
+)
+
+// ActionKind is what Apply does to converge one repository.
+type ActionKind string
+
+const (
+	ActionOK          ActionKind = "ok"
+	ActionLink        ActionKind = "link"
+	ActionRelink      ActionKind = "relink"
+	ActionUnlink      ActionKind = "unlink"
+	ActionUnavailable ActionKind = "unavailable"
+)
+
+// Action is the drift of one repository from the desired state.
+type Action struct {
+	Kind  ActionKind
+	Repo  string
+	Model fsutils.ModelInfo
+	Entry Entry
+	// Revision is the resolved commit hash that will be linked.
+	Revision string
+	Reason   string
+}
+
+// Plan lists the actions needed to converge a target directory. Entries of the desired
+// state come first in file order, followed by extra links sorted by repository.
+type Plan struct {
+	Actions []Action
+}
+
+// Options tunes how a plan is computed.
+type Options struct {
+	// KeepExtras leaves managed links that are not in the desired state in place.
+	KeepExtras bool
+	// DefaultMode is the link mode of entries that do not name one.
+	DefaultMode fsutils.LinkMode
+}
+
+// Count returns the number of actions of a kind.
+func (p Plan) Count(kind ActionKind) int {
+	n := 0
+	for _, a := range p.Actions {
+		if a.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// InSync reports whether applying the plan would change nothing.
+func (p Plan) InSync() bool {
+	return p.Count(ActionLink)+p.Count(ActionRelink)+p.Count(ActionUnlink)+p.Count(ActionUnavailable) == 0
+}
+
+// Summary describes the plan in one line, e.g. "2 to link, 1 to unlink, 4 in sync".
+func (p Plan) Summary() string {
+	var parts []string
+	for _, c := range []struct {
+		kind  ActionKind
+		label string
+	}{
+		{ActionLink, "to link"},
+		{ActionRelink, "to relink"},
+		{ActionUnlink, "to unlink"},
+		{ActionUnavailable, "unavailable"},
+		{ActionOK, "in sync"},
+	} {
+		if n := p.Count(c.kind); n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, c.label))
+		}
+	}
+	if len(parts) == 0 {
+		return "nothing desired or linked"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Diff compares the desired state with the models found by fsutils.LoadModels and the
+// stale links found by fsutils.FindStaleLinks. Only links carrying the tool's metadata
+// file are considered managed; anything else in the target directory is left alone.
+func Diff(state *State, models, stale []fsutils.ModelInfo, opts Options) Plan {
+	var plan Plan
+	byRepo := make(map[string]fsutils.ModelInfo, len(models))
+	for _, m := range models {
+		if m.IsLinkable() {
+			byRepo[strings.ToLower(m.RepoID())] = m
+		}
+	}
+	wanted := map[string]bool{}
+	for _, entry := range state.Models {
+		wanted[strings.ToLower(entry.Repo)] = true
+		m, ok := byRepo[strings.ToLower(entry.Repo)]
+		if !ok {
+			// Datasets and spaces share the namespace; say so rather than "not found".
+			reason := "not in the Hugging Face cache"
+			for _, other := range models {
+				if strings.EqualFold(other.RepoID(), entry.Repo) {
+					reason = "cannot be linked: " + other.NotLinkableReason()
+				}
+			}
+			plan.Actions = append(plan.Actions, Action{Kind: ActionUnavailable, Repo: entry.Repo, Entry: entry, Reason: reason})
+			continue
+		}
+		if entry.Mode == "" {
+			entry.Mode = opts.DefaultMode
+		}
+		plan.Actions = append(plan.Actions, diffEntry(entry, m))
+	}
+
+	var extras []Action
+	if !opts.KeepExtras {
+		for _, m := range append(append([]fsutils.ModelInfo(nil), models...), stale...) {
+			if !m.IsLinkable() || wanted[strings.ToLower(m.RepoID())] || !managed(m) {
+				continue
+			}
+			reason := "not in the desired state"
+			if m.IsStale {
+				reason = "stale link, " + strings.ToLower(m.StaleReason)
+			}
+			extras = append(extras, Action{Kind: ActionUnlink, Repo: m.RepoID(), Model: m, Reason: reason})
+		}
+	}
+	sort.Slice(extras, func(i, j int) bool { return extras[i].Repo < extras[j].Repo })
+	plan.Actions = append(plan.Actions, extras...)
+	return plan
+}
+
+// diffEntry decides what to do for a desired repository that is in the cache.
+func diffEntry(entry Entry, m fsutils.ModelInfo) Action {
+	action := Action{Repo: m.RepoID(), Model: m, Entry: entry}
+	unavailable := func(reason string) Action {
+		action.Kind, action.Reason = ActionUnavailable, reason
+		return action
+	}
+	if m.IsIncomplete {
+		return unavailable("download incomplete: " + strings.ToLower(m.IncompleteReason))
+	}
+	if m.Format == fsutils.FormatUnsupported {
+		return unavailable("no GGUF or MLX files")
+	}
+	revision, _, err := fsutils.ResolveRevision(m.SourcePath, entry.Revision)
+	if err != nil {
+		return unavailable(err.Error())
+	}
+	action.Revision = revision
+
+	marker, err := fsutils.ReadMarker(m.TargetPath)
+	switch {
+	case err != nil:
+		action.Kind, action.Reason = ActionLink, "not linked"
+	case !m.IsLinked:
+		action.Kind, action.Reason = ActionRelink, "links are broken"
+	case marker.Revision != revision:
+		action.Kind, action.Reason = ActionRelink, fmt.Sprintf("linked revision %s, want %s", fsutils.ShortRevision(marker.Revision), fsutils.ShortRevision(revision))
+	case !sameFiles(marker.Files, entry.Files):
+		action.Kind, action.Reason = ActionRelink, "file selection changed"
+	case marker.Mode.String() != entry.Mode.String():
+		action.Kind, action.Reason = ActionRelink, fmt.Sprintf("linked as %s, want %s", marker.Mode, entry.Mode)
+	default:
+		action.Kind = ActionOK
+	}
+	// Exclude rules keep models out of the target but do not unlink what is already there.
+	if m.IsExcluded && action.Kind != ActionOK {
+		return unavailable(rules.Describe(m.ExcludedBy))
+	}
+	return action
+}
+
+// managed reports whether a model's target directory was linked by this tool.
+func managed(m fsutils.ModelInfo) bool {
+	return m.TargetPath != "" && fsutils.HasMarker(m.TargetPath)
+}
+
+// sameFiles compares two file pattern lists ignoring order.
+func sameFiles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Result is the outcome of applying one action.
+type Result struct {
+	Action Action
+	// Step is the journal step that carried out the action, which can be run again to
+	// retry it.
+	Step journal.Step
+	Err  error
+}
+
+// Apply converges the target directory by executing the plan as one journaled operation
+// of the given kind. Unlinks run before links; ok and unavailable actions are skipped.
+// Every executed action is reported; the error is set if the operation could not be
+// journaled, in which case nothing ran. Actions skipped because ctx was cancelled report
+// ctx's error.
+func Apply(ctx context.Context, plan Plan, j *journal.Journal, kind, targetDir string) ([]Result, error) {
+	var actions []Action
+	var steps []journal.Step
+	for _, a := range plan.Actions {
+		if a.Kind == ActionUnlink {
+			actions = append(actions, a)
+			steps = append(steps, journal.UnlinkStep(a.Model))
+		}
+	}
+	for _, a := range plan.Actions {
+		if a.Kind == ActionLink || a.Kind == ActionRelink {
+			actions = append(actions, a)
+			steps = append(steps, journal.LinkStep(a.Model, fsutils.LinkOptions{Revision: a.Revision, Files: a.Entry.Files, Mode: a.Entry.Mode}))
+		}
+	}
+	errs, err := j.Run(ctx, kind, targetDir, steps)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Result, len(actions))
+	for i, a := range actions {
+		results[i] = Result{Action: a, Step: steps[i], Err: errs[i]}
+	}
+	return results, nil
+}
@@ -0,0 +1,152 @@
+// Package desired reads a declarative list of the models that should be linked and
+// converges an LM Studio models directory to it.
+package desired
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFileNames are looked up, in order, when no desired-state file is given.
+var DefaultFileNames = []string{"models.toml", "models.yaml", "models.yml"}
+
+// ErrNoStateFile is returned by FindFile when no desired-state file exists.
+var ErrNoStateFile = errors.New("no desired-state file found")
+
+// Entry is one repository that should be linked.
+type Entry struct {
+	// Repo is the Hugging Face repository id, e.g. "bartowski/Llama-3.2-3B-Instruct-GGUF".
+	Repo string `toml:"repo" yaml:"repo" json:"repo"`
+	// Revision is a ref name or commit hash; empty links the snapshot refs/main points at.
+	Revision string `toml:"revision,omitempty" yaml:"revision,omitempty" json:"revision,omitempty"`
+	// Files are glob patterns selecting the files to link; empty links every file.
+	Files []string `toml:"files,omitempty" yaml:"files,omitempty" json:"files,omitempty"`
+	// Mode is symlink (the default), hardlink or copy.
+	Mode fsutils.LinkMode `toml:"mode,omitempty" yaml:"mode,omitempty" json:"mode,omitempty"`
+}
+
+// State is the content of a desired-state file.
+type State struct {
+	// Target records the LM Studio directory an exported link set came from. It is
+	// informational; the set is always applied to the local target directory.
+	Target string  `toml:"target,omitempty" yaml:"target,omitempty" json:"target,omitempty"`
+	Models []Entry `toml:"models" yaml:"models" json:"models"`
+}
+
+// Load reads a desired-state file. The format is chosen by extension: .yaml or .yml for
+// YAML, anything else for TOML.
+func Load(path string) (*State, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if isYAML(path) {
+		err = yaml.Unmarshal(data, &state)
+	} else {
+		err = toml.Unmarshal(data, &state)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if err := state.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &state, nil
+}
+
+// Save writes a desired-state file in the format chosen by its extension.
+func Save(path string, state *State) error {
+	var buf bytes.Buffer
+	if isYAML(path) {
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(state); err != nil {
+			return err
+		}
+	} else if err := toml.NewEncoder(&buf).Encode(state); err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// isYAML reports whether a path has a YAML extension.
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// Validate checks that every entry names an org/name repository exactly once and uses a
+// known link mode.
+func (s *State) Validate() error {
+	seen := map[string]bool{}
+	for i, entry := range s.Models {
+		org, name, ok := strings.Cut(entry.Repo, "/")
+		if !ok || org == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("model %d: repo %q is not of the form org/name", i+1, entry.Repo)
+		}
+		key := strings.ToLower(entry.Repo)
+		if seen[key] {
+			return fmt.Errorf("model %d: repo %s is listed more than once", i+1, entry.Repo)
+		}
+		seen[key] = true
+		if _, err := fsutils.ParseLinkMode(string(entry.Mode)); err != nil {
+			return fmt.Errorf("model %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// FindFile returns the first default desired-state file in the current directory or the
+// user configuration directory (e.g. ~/.config/hf-lms-sync).
+func FindFile() (string, error) {
+	var dirs []string
+	if cwd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, cwd)
+	}
+	if configDir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(configDir, "hf-lms-sync"))
+	}
+	for _, dir := range dirs {
+		for _, name := range DefaultFileNames {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w (looked for %s in %s)", ErrNoStateFile, strings.Join(DefaultFileNames, ", "), strings.Join(dirs, ", "))
+}
+
+// Export captures the current link set of a target directory as a state whose entries
+// pin the linked revision, file selection and link mode. Stale links are skipped; links
+// made by versions that did not record a revision are exported unpinned.
+func Export(models []fsutils.ModelInfo, targetDir string) *State {
+	state := &State{Target: targetDir, Models: []Entry{}}
+	for _, m := range models {
+		if !m.IsLinkable() || m.IsStale || !managed(m) {
+			continue
+		}
+		marker, _ := fsutils.ReadMarker(m.TargetPath)
+		entry := Entry{Repo: m.RepoID(), Revision: marker.Revision, Files: marker.Files}
+		if marker.Mode != fsutils.LinkSymlink {
+			entry.Mode = marker.Mode
+		}
+		state.Models = append(state.Models, entry)
+	}
+	return state
+}
@@ -0,0 +1,65 @@
+package fsutils
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DirSize returns the total size of the regular files below path. Symlinks are not
+// followed, so it is the space freed by removing the directory.
+func DirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// targetPathIn returns where a model is linked inside a target directory.
+func targetPathIn(m ModelInfo, targetDir string) string {
+	return filepath.Join(targetDir, m.OrganizationName, m.ModelName)
+}
+
+// LinkedTargets returns the target directories among targetDirs that contain a link
+// (a directory with a metadata file) for the model.
+func LinkedTargets(m ModelInfo, targetDirs []string) []string {
+	var linked []string
+	for _, targetDir := range targetDirs {
+		if _, err := os.Stat(filepath.Join(targetPathIn(m, targetDir), metadataFile)); err == nil {
+			linked = append(linked, targetDir)
+		}
+	}
+	return linked
+}
+
+// DeleteModel unlinks a model from every target directory and then removes its repository
+// from the Hugging Face cache. The cache is left untouched if any unlink fails.
+func DeleteModel(m ModelInfo, targetDirs []string) error {
+	if m.IsStale {
+		return fmt.Errorf("%s has no source in the Hugging Face cache", m.RepoID())
+	}
+	if info, err := os.Stat(m.SourcePath); err != nil || !info.IsDir() {
+		return fmt.Errorf("source path %s does not exist or is not a directory", m.SourcePath)
+	}
+	if m.IsLinkable() {
+		for _, targetDir := range LinkedTargets(m, targetDirs) {
+			linked := m
+			linked.TargetPath = targetPathIn(m, targetDir)
+			if err := UnlinkModel(linked); err != nil {
+				return fmt.Errorf("failed to unlink %s from %s: %v", m.RepoID(), targetDir, err)
+			}
+		}
+	}
+	if err := os.RemoveAll(m.SourcePath); err != nil {
+		return fmt.Errorf("failed to remove %s from the Hugging Face cache: %v", m.SourcePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package fsutils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
+)
+
+// TestDeleteModel tests that a model is unlinked from every target and removed from the cache.
+func TestDeleteModel(t *testing.T) {
+	hfCache := t.TempDir()
+	targets := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "rev", map[string]string{"a.gguf": "aaaa", "b.gguf": "bb"})
+	m := ModelInfo{
+		CacheDirName:     "models--org--model",
+		RepoType:         RepoTypeModel,
+		OrganizationName: "org",
+		ModelName:        "model",
+		SourcePath:       repo,
+	}
+	for _, targetDir := range targets[:2] {
+		linked := m
+		linked.TargetPath = filepath.Join(targetDir, "org", "model")
+		if err := LinkModel(linked); err != nil {
+			t.Fatalf("LinkModel returned error: %v", err)
+		}
+	}
+
+	if linked := LinkedTargets(m, targets); len(linked) != 2 {
+		t.Errorf("expected model to be linked in 2 targets, got %v", linked)
+	}
+	// Six bytes of blobs plus the three-byte refs/main file.
+	if size := DirSize(repo); size != 9 {
+		t.Errorf("expected repository size 9, got %d", size)
+	}
+
+	if err := DeleteModel(m, targets); err != nil {
+		t.Fatalf("DeleteModel returned error: %v", err)
+	}
+	if _, err := os.Stat(repo); !os.IsNotExist(err) {
+		t.Errorf("expected repository to be removed from the cache")
+	}
+	for _, targetDir := range targets {
+		if _, err := os.Stat(filepath.Join(targetDir, "org", "model")); !os.IsNotExist(err) {
+			t.Errorf("expected link in %s to be removed", targetDir)
+		}
+	}
+}
+
+// TestDeleteModelStale tests that stale entries, which have no source, cannot be deleted.
+func TestDeleteModelStale(t *testing.T) {
+	m := ModelInfo{OrganizationName: "org", ModelName: "model", IsStale: true}
+	if err := DeleteModel(m, nil); err == nil {
+		t.Errorf("expected error deleting a stale model, got nil")
+	}
+}
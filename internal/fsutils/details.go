@@ -0,0 +1,164 @@
+package fsutils
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileHealth tells how a snapshot file is represented in the target directory.
+type FileHealth string
+
+const (
+	// HealthOK means the target holds the file.
+	HealthOK FileHealth = "ok"
+	// HealthMissing means the file is selected but absent from the target.
+	HealthMissing FileHealth = "missing"
+	// HealthMismatch means the target holds a different file, e.g. from another revision.
+	HealthMismatch FileHealth = "mismatch"
+	// HealthSkipped means the link's file patterns leave the file out.
+	HealthSkipped FileHealth = "not selected"
+	// HealthNoBlob means the file's blob is not in the cache, e.g. while downloading.
+	HealthNoBlob FileHealth = "no blob"
+)
+
+// FileDetail describes a file of a snapshot.
+type FileDetail struct {
+	// Path is relative to the snapshot, with forward slashes.
+	Path string
+	Size int64
+	// Health is empty for models that are not linked.
+	Health FileHealth
+}
+
+// ModelDetails is what a model's cache repository and link hold.
+type ModelDetails struct {
+	// Revision is the snapshot shown: the linked one if it is still in the cache,
+	// otherwise the one that would be linked.
+	Revision string
+	// Refs names the refs pointing at Revision, e.g. main.
+	Refs  []string
+	Files []FileDetail
+	// Marker is the link's metadata; nil if the model is not linked.
+	Marker *LinkMarker
+	// GGUFFile is the snapshot-relative path of the GGUF file whose metadata is in GGUF.
+	GGUFFile string
+	GGUF     *GGUFMetadata
+	// GGUFErr tells why GGUFFile could not be read.
+	GGUFErr error
+}
+
+// LoadDetails reads the details of a model. The error tells why the snapshot could not be
+// resolved; the details read until then are returned with it.
+func LoadDetails(m ModelInfo) (ModelDetails, error) {
+	var d ModelDetails
+	if m.IsLinked && m.TargetPath != "" {
+		if marker, err := ReadMarker(m.TargetPath); err == nil {
+			d.Marker = &marker
+		}
+	}
+	if m.IsStale {
+		return d, nil
+	}
+	revision, snapPath := "", ""
+	err := ErrRevisionNotFound
+	if d.Marker != nil && d.Marker.Revision != "" {
+		revision, snapPath, err = ResolveRevision(m.SourcePath, d.Marker.Revision)
+	}
+	if err != nil {
+		if revision, snapPath, err = ResolveSnapshot(m.SourcePath); err != nil {
+			return d, err
+		}
+	}
+	d.Revision = revision
+	d.Refs = refsTo(m.SourcePath, revision)
+
+	var ggufFiles []string
+	filepath.WalkDir(snapPath, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(snapPath, p)
+		if err != nil {
+			return nil
+		}
+		file := FileDetail{Path: filepath.ToSlash(rel)}
+		info, statErr := os.Stat(p)
+		if statErr == nil {
+			file.Size = info.Size()
+		}
+		if d.Marker != nil {
+			file.Health = fileHealth(m.TargetPath, rel, info, statErr, *d.Marker)
+		} else if statErr != nil {
+			file.Health = HealthNoBlob
+		}
+		d.Files = append(d.Files, file)
+		if statErr == nil && strings.HasSuffix(strings.ToLower(entry.Name()), ".gguf") {
+			ggufFiles = append(ggufFiles, file.Path)
+		}
+		return nil
+	})
+
+	// Vision projectors sort before the model in many repositories but describe less of it
+	sort.SliceStable(ggufFiles, func(i, j int) bool {
+		return !isProjector(ggufFiles[i]) && isProjector(ggufFiles[j])
+	})
+	if len(ggufFiles) > 0 {
+		d.GGUFFile = ggufFiles[0]
+		if g, err := ReadGGUF(filepath.Join(snapPath, filepath.FromSlash(d.GGUFFile))); err != nil {
+			d.GGUFErr = err
+		} else {
+			d.GGUF = &g
+		}
+	}
+	return d, nil
+}
+
+// fileHealth compares a snapshot file, described by the result of os.Stat, with its
+// counterpart in a linked directory.
+func fileHealth(targetPath, rel string, info os.FileInfo, statErr error, marker LinkMarker) FileHealth {
+	if !matchFiles(rel, marker.Files) {
+		return HealthSkipped
+	}
+	if statErr != nil {
+		return HealthNoBlob
+	}
+	target, err := os.Stat(filepath.Join(targetPath, rel))
+	switch {
+	case err != nil:
+		return HealthMissing
+	case os.SameFile(info, target):
+		return HealthOK
+	case marker.Mode == LinkCopy && target.Size() == info.Size():
+		return HealthOK
+	}
+	return HealthMismatch
+}
+
+// isProjector reports whether a GGUF file is a multimodal projector rather than a model.
+func isProjector(file string) bool {
+	return strings.HasPrefix(strings.ToLower(filepath.Base(file)), "mmproj")
+}
+
+// refsTo returns the names of the refs of a cache repository that point at revision.
+func refsTo(sourcePath, revision string) []string {
+	var refs []string
+	root := filepath.Join(sourcePath, refsDir)
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil || strings.TrimSpace(string(data)) != revision {
+			return nil
+		}
+		if rel, err := filepath.Rel(root, p); err == nil {
+			refs = append(refs, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return refs
+}
@@ -0,0 +1,68 @@
+package fsutils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
+)
+
+// TestLoadDetails tests the revision, refs, file health and GGUF metadata of a model before
+// and after it is linked, and after a linked file goes missing.
+func TestLoadDetails(t *testing.T) {
+	hfCache := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "rev1", map[string]string{
+		"model-Q4_K_M.gguf": string(testGGUF()),
+		"mmproj-f16.gguf":   "projector",
+		"README.md":         "readme",
+	})
+	m := ModelInfo{
+		CacheDirName:     "models--org--model",
+		OrganizationName: "org",
+		ModelName:        "model",
+		SourcePath:       repo,
+		TargetPath:       filepath.Join(t.TempDir(), "org", "model"),
+	}
+	health := func(d ModelDetails) map[string]FileHealth {
+		files := map[string]FileHealth{}
+		for _, f := range d.Files {
+			files[f.Path] = f.Health
+		}
+		return files
+	}
+
+	d, err := LoadDetails(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Revision != "rev1" || !reflect.DeepEqual(d.Refs, []string{"main"}) || d.Marker != nil {
+		t.Errorf("unexpected details %+v", d)
+	}
+	if want := map[string]FileHealth{"model-Q4_K_M.gguf": "", "mmproj-f16.gguf": "", "README.md": ""}; !reflect.DeepEqual(health(d), want) {
+		t.Errorf("got health %v, want %v", health(d), want)
+	}
+	if d.GGUFFile != "model-Q4_K_M.gguf" || d.GGUF == nil || d.GGUF.Values["general.name"] != "Llama 3 8B Instruct" {
+		t.Errorf("expected the model's GGUF metadata, got %q %+v %v", d.GGUFFile, d.GGUF, d.GGUFErr)
+	}
+
+	if err := LinkModelWith(context.Background(), m, LinkOptions{Files: []string{"*.gguf"}}); err != nil {
+		t.Fatal(err)
+	}
+	m.IsLinked = true
+	if err := os.Remove(filepath.Join(m.TargetPath, "mmproj-f16.gguf")); err != nil {
+		t.Fatal(err)
+	}
+	if d, err = LoadDetails(m); err != nil {
+		t.Fatal(err)
+	}
+	if d.Marker == nil || d.Marker.Revision != "rev1" || d.Marker.LinkedAt.IsZero() {
+		t.Errorf("expected the marker, got %+v", d.Marker)
+	}
+	want := map[string]FileHealth{"model-Q4_K_M.gguf": HealthOK, "mmproj-f16.gguf": HealthMissing, "README.md": HealthSkipped}
+	if !reflect.DeepEqual(health(d), want) {
+		t.Errorf("got health %v, want %v", health(d), want)
+	}
+}
@@ -0,0 +1,22 @@
+package fsutils
+
+import (
+	"os"
+	"testing"
+)
+
+// setHfCache points GetHfCacheDir at a fresh temporary directory and returns it.
+func setHfCache(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	t.Setenv("HOME", root)
+	t.Setenv("XDG_CACHE_HOME", root)
+	hfCache, err := GetHfCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(hfCache, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return hfCache
+}
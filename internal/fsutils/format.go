@@ -0,0 +1,305 @@
+package fsutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ModelFormat identifies the on-disk format of a model repository.
+type ModelFormat string
+
+const (
+	FormatGGUF        ModelFormat = "gguf"
+	FormatMLX         ModelFormat = "mlx"
+	FormatUnsupported ModelFormat = "unsupported"
+
+	refsDir    = "refs"
+	defaultRef = "main"
+)
+
+// String returns a short, human-readable label for the format.
+func (f ModelFormat) String() string {
+	switch f {
+	case FormatGGUF:
+		return "GGUF"
+	case FormatMLX:
+		return "MLX"
+	case "":
+		return "-"
+	default:
+		return "Unsupported"
+	}
+}
+
+// DetectFormat classifies a snapshot directory by its contents (see FormatOfFiles).
+func DetectFormat(snapshotPath string) ModelFormat {
+	var files []string
+	filepath.WalkDir(snapshotPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(snapshotPath, path)
+		if err != nil {
+			return nil
+		}
+		files = append(files, filepath.ToSlash(rel))
+		if strings.HasSuffix(strings.ToLower(d.Name()), ".gguf") {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return FormatOfFiles(files)
+}
+
+// FormatOfFiles classifies a list of snapshot-relative file paths. Any .gguf file makes
+// it a GGUF repository; a top-level config.json together with .safetensors weights
+// makes it an MLX repository. Everything else is unsupported.
+func FormatOfFiles(files []string) ModelFormat {
+	hasConfig := false
+	hasSafetensors := false
+	for _, file := range files {
+		name := strings.ToLower(path.Base(file))
+		switch {
+		case strings.HasSuffix(name, ".gguf"):
+			return FormatGGUF
+		case strings.HasSuffix(name, ".safetensors"):
+			hasSafetensors = true
+		case name == "config.json" && !strings.Contains(file, "/"):
+			hasConfig = true
+		}
+	}
+	if hasConfig && hasSafetensors {
+		return FormatMLX
+	}
+	return FormatUnsupported
+}
+
+// DetectModelFormat classifies a Hugging Face cache repository by inspecting the snapshot
+// that would be linked.
+func DetectModelFormat(sourcePath string) ModelFormat {
+	_, snapPath, err := ResolveSnapshot(sourcePath)
+	if err != nil {
+		return FormatUnsupported
+	}
+	return DetectFormat(snapPath)
+}
+
+// quantPattern matches a GGUF quantization type such as Q4_K_M, IQ3_XXS or BF16 between
+// separators of a file or directory name.
+var quantPattern = regexp.MustCompile(`(?i)(?:^|[-._])(i?q[1-8](?:_[a-z0-9]+)*|bf16|f16|f32)(?:[-.]|$)`)
+
+// DetectQuants returns the quantization types named by the GGUF files of a snapshot
+// directory, upper-cased and sorted, e.g. [Q4_K_M Q8_0].
+func DetectQuants(snapshotPath string) []string {
+	seen := map[string]bool{}
+	var quants []string
+	filepath.WalkDir(snapshotPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(strings.ToLower(d.Name()), ".gguf") {
+			return nil
+		}
+		rel, err := filepath.Rel(snapshotPath, p)
+		if err != nil {
+			return nil
+		}
+		// Split models keep the quantization in their directory name
+		for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+			if match := quantPattern.FindStringSubmatch(part); match != nil {
+				quant := strings.ToUpper(match[1])
+				if !seen[quant] {
+					seen[quant] = true
+					quants = append(quants, quant)
+				}
+				break
+			}
+		}
+		return nil
+	})
+	sort.Strings(quants)
+	return quants
+}
+
+// DetectModelQuants returns the quantization types of the snapshot that would be linked.
+func DetectModelQuants(sourcePath string) []string {
+	_, snapPath, err := ResolveSnapshot(sourcePath)
+	if err != nil {
+		return nil
+	}
+	return DetectQuants(snapPath)
+}
+
+// ResolveSnapshot returns the revision and path of the snapshot to link for a cache
+// repository. The snapshot referenced by refs/main is preferred; otherwise the most
+// recently modified snapshot is used.
+func ResolveSnapshot(sourcePath string) (string, string, error) {
+	snapshotsPath := filepath.Join(sourcePath, snapshotsDir)
+
+	if data, err := ioutil.ReadFile(filepath.Join(sourcePath, refsDir, defaultRef)); err == nil {
+		revision := strings.TrimSpace(string(data))
+		snapPath := filepath.Join(snapshotsPath, revision)
+		if info, err := os.Stat(snapPath); err == nil && info.IsDir() {
+			return revision, snapPath, nil
+		}
+	}
+
+	entries, err := ioutil.ReadDir(snapshotsPath)
+	if err != nil {
+		return "", "", fmt.Errorf("snapshots directory %s does not exist", snapshotsPath)
+	}
+	var newest os.FileInfo
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if newest == nil || entry.ModTime().After(newest.ModTime()) {
+			newest = entry
+		}
+	}
+	if newest == nil {
+		return "", "", fmt.Errorf("no snapshots found in %s", snapshotsPath)
+	}
+	return newest.Name(), filepath.Join(snapshotsPath, newest.Name()), nil
+}
+
+// ErrRevisionNotFound is returned when a requested revision is not in the cache.
+var ErrRevisionNotFound = errors.New("revision not downloaded")
+
+// ResolveRevision returns the revision and snapshot path for a ref name or commit hash.
+// Commit hashes may be abbreviated as long as they are unambiguous. An empty revision
+// resolves like ResolveSnapshot.
+func ResolveRevision(sourcePath, revision string) (string, string, error) {
+	if revision == "" {
+		return ResolveSnapshot(sourcePath)
+	}
+	snapshotsPath := filepath.Join(sourcePath, snapshotsDir)
+	if data, err := ioutil.ReadFile(filepath.Join(sourcePath, refsDir, revision)); err == nil {
+		revision = strings.TrimSpace(string(data))
+	}
+	if info, err := os.Stat(filepath.Join(snapshotsPath, revision)); err == nil && info.IsDir() {
+		return revision, filepath.Join(snapshotsPath, revision), nil
+	}
+	entries, _ := ioutil.ReadDir(snapshotsPath)
+	var match string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), revision) {
+			if match != "" {
+				return "", "", fmt.Errorf("revision %s is ambiguous", revision)
+			}
+			match = entry.Name()
+		}
+	}
+	if match == "" {
+		return "", "", fmt.Errorf("%w: %s", ErrRevisionNotFound, revision)
+	}
+	return match, filepath.Join(snapshotsPath, match), nil
+}
+
+// matchFiles reports whether a snapshot-relative path is selected by the patterns. An empty
+// pattern list selects everything. Patterns without a slash also match base names; a
+// leading slash anchors a pattern at the snapshot root.
+func matchFiles(rel string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "/") {
+			if ok, _ := path.Match(pattern[1:], rel); ok {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ExactFiles turns snapshot-relative file paths into patterns that select exactly those
+// files. A nil list stays nil, selecting every file.
+func ExactFiles(files []string) []string {
+	if files == nil {
+		return nil
+	}
+	patterns := make([]string, len(files))
+	for i, file := range files {
+		var b strings.Builder
+		b.WriteString("/")
+		for _, r := range filepath.ToSlash(file) {
+			if strings.ContainsRune(`*?[\`, r) {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(r)
+		}
+		patterns[i] = b.String()
+	}
+	return patterns
+}
+
+// anyFileMatches reports whether any file of a snapshot is selected by the patterns.
+func anyFileMatches(snapPath string, patterns []string) bool {
+	found := false
+	filepath.WalkDir(snapPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if rel, err := filepath.Rel(snapPath, p); err == nil && matchFiles(rel, patterns) {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+// linkTree mirrors the directory structure of a snapshot into the target directory,
+// creating real directories and placing each file individually. MLX models are loaded
+// from a directory, so nested folders (e.g. tokenizer assets) must keep their layout, and
+// split GGUF models often live in a folder per quantization. Only files selected by the
+// file patterns are linked.
+func linkTree(ctx context.Context, snapPath, targetPath string, opts LinkOptions) error {
+	return filepath.WalkDir(snapPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(snapPath, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		dst := filepath.Join(targetPath, rel)
+		if d.IsDir() {
+			if len(opts.Files) > 0 {
+				return nil
+			}
+			return os.MkdirAll(dst, 0755)
+		}
+		if !matchFiles(rel, opts.Files) {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		realSource, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return fmt.Errorf("failed to resolve symlink for %s: %v", path, err)
+		}
+		return placeFile(ctx, realSource, dst, filepath.ToSlash(rel), opts)
+	})
+}
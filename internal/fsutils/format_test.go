@@ -0,0 +1,235 @@
+package fsutils
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
+)
+
+// TestDetectFormat classifies GGUF, MLX and unsupported fixture repositories.
+func TestDetectFormat(t *testing.T) {
+	hfCache := t.TempDir()
+	cases := []struct {
+		name     string
+		files    map[string]string
+		expected ModelFormat
+	}{
+		{"gguf", map[string]string{"model-Q4_K_M.gguf": "gguf", "README.md": "readme"}, FormatGGUF},
+		{"nested-gguf", map[string]string{"Q8_0/model-00001-of-00002.gguf": "gguf"}, FormatGGUF},
+		{"mlx", map[string]string{"config.json": "{}", "model.safetensors": "weights", "tokenizer.json": "{}"}, FormatMLX},
+		{"safetensors-only", map[string]string{"model.safetensors": "weights"}, FormatUnsupported},
+		{"nested-config", map[string]string{"sub/config.json": "{}", "model.safetensors": "weights"}, FormatUnsupported},
+		{"pytorch", map[string]string{"config.json": "{}", "pytorch_model.bin": "weights"}, FormatUnsupported},
+	}
+	for _, tc := range cases {
+		repo := cachetest.MakeRepo(t, hfCache, "models--org--"+tc.name, "abc123", tc.files)
+		if got := DetectModelFormat(repo); got != tc.expected {
+			t.Errorf("%s: expected format %q, got %q", tc.name, tc.expected, got)
+		}
+	}
+}
+
+// TestDetectQuants tests that quantization types are read from GGUF file and directory names.
+func TestDetectQuants(t *testing.T) {
+	hfCache := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--quants", "abc123", map[string]string{
+		"model-Q4_K_M.gguf":              "a",
+		"model.IQ3_XXS.gguf":             "b",
+		"model-q4_k_m-imatrix.gguf":      "c",
+		"BF16/model-00001-of-00002.gguf": "d",
+		"model-Q8_0.safetensors":         "e",
+		"mmproj-model.gguf":              "f",
+	})
+	got := DetectModelQuants(repo)
+	if want := []string{"BF16", "IQ3_XXS", "Q4_K_M"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected quants %v, got %v", want, got)
+	}
+}
+
+// TestResolveSnapshotPrefersMainRef tests that the snapshot referenced by refs/main wins over newer snapshots.
+func TestResolveSnapshotPrefersMainRef(t *testing.T) {
+	hfCache := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "old", map[string]string{"a.gguf": "a"})
+	if err := os.MkdirAll(filepath.Join(repo, "snapshots", "new"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	revision, _, err := ResolveSnapshot(repo)
+	if err != nil {
+		t.Fatalf("ResolveSnapshot returned error: %v", err)
+	}
+	if revision != "old" {
+		t.Errorf("expected revision 'old', got %q", revision)
+	}
+
+	// Without a ref, the only remaining snapshot is chosen.
+	if err := os.RemoveAll(filepath.Join(repo, "refs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(repo, "snapshots", "new")); err != nil {
+		t.Fatal(err)
+	}
+	revision, _, err = ResolveSnapshot(repo)
+	if err != nil {
+		t.Fatalf("ResolveSnapshot returned error: %v", err)
+	}
+	if revision != "old" {
+		t.Errorf("expected fallback revision 'old', got %q", revision)
+	}
+}
+
+// TestLinkModelMLX tests that MLX repositories are linked with their directory structure
+// preserved and that the marker records the revision and format.
+func TestLinkModelMLX(t *testing.T) {
+	hfCache := t.TempDir()
+	targetDir := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--mlx-community--model-4bit", "rev1", map[string]string{
+		"config.json":              "{}",
+		"model.safetensors":        "weights",
+		"tokenizer/tokenizer.json": "{}",
+	})
+	m := ModelInfo{
+		CacheDirName:     "models--mlx-community--model-4bit",
+		OrganizationName: "mlx-community",
+		ModelName:        "model-4bit",
+		SourcePath:       repo,
+		TargetPath:       filepath.Join(targetDir, "mlx-community", "model-4bit"),
+	}
+	if err := LinkModel(m); err != nil {
+		t.Fatalf("LinkModel returned error: %v", err)
+	}
+
+	nested := filepath.Join(m.TargetPath, "tokenizer")
+	info, err := os.Lstat(nested)
+	if err != nil {
+		t.Fatalf("nested directory not created: %v", err)
+	}
+	if !info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("expected tokenizer to be a real directory")
+	}
+	info, err = os.Lstat(filepath.Join(nested, "tokenizer.json"))
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected tokenizer/tokenizer.json to be a symlink")
+	}
+
+	marker, err := ReadMarker(m.TargetPath)
+	if err != nil {
+		t.Fatalf("ReadMarker returned error: %v", err)
+	}
+	if marker.Revision != "rev1" || marker.Format != FormatMLX {
+		t.Errorf("unexpected marker: %+v", marker)
+	}
+}
+
+// TestLinkModelWithOptions tests linking a pinned revision with a file selection.
+func TestLinkModelWithOptions(t *testing.T) {
+	hfCache := t.TempDir()
+	targetDir := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "aaaa1111", map[string]string{"model-Q4.gguf": "q4", "model-Q8.gguf": "q8", "README.md": "readme"})
+	cachetest.MakeRepo(t, hfCache, "models--org--model", "bbbb2222", map[string]string{"model-Q4.gguf": "q4-new"})
+	m := ModelInfo{
+		CacheDirName:     "models--org--model",
+		OrganizationName: "org",
+		ModelName:        "model",
+		SourcePath:       repo,
+		TargetPath:       filepath.Join(targetDir, "org", "model"),
+	}
+
+	if err := LinkModelWith(context.Background(), m, LinkOptions{Revision: "aaaa", Files: []string{"*Q4*"}}); err != nil {
+		t.Fatalf("LinkModelWith returned error: %v", err)
+	}
+	entries, _ := os.ReadDir(m.TargetPath)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 2 || names[0] != metadataFile || names[1] != "model-Q4.gguf" {
+		t.Errorf("expected only model-Q4.gguf to be linked, got %v", names)
+	}
+	marker, err := ReadMarker(m.TargetPath)
+	if err != nil || marker.Revision != "aaaa1111" || len(marker.Files) != 1 {
+		t.Errorf("unexpected marker %+v (err %v)", marker, err)
+	}
+
+	if err := LinkModelWith(context.Background(), m, LinkOptions{Revision: "cccc"}); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("expected ErrRevisionNotFound, got %v", err)
+	}
+	if err := LinkModelWith(context.Background(), m, LinkOptions{Files: []string{"*.safetensors"}}); err == nil {
+		t.Errorf("expected error when no file matches")
+	}
+}
+
+// TestExactFiles tests that exact patterns select only the files they name, at the
+// snapshot root and with glob characters in their names.
+func TestExactFiles(t *testing.T) {
+	patterns := ExactFiles([]string{"model.gguf", "Q8_0/part[1].gguf"})
+	cases := map[string]bool{
+		"model.gguf":        true,
+		"sub/model.gguf":    false,
+		"Q8_0/part[1].gguf": true,
+		"Q8_0/part1.gguf":   false,
+		"Q8_0/model.gguf":   false,
+		"model.gguf.sig":    false,
+		"README.md":         false,
+	}
+	for rel, want := range cases {
+		if got := matchFiles(rel, patterns); got != want {
+			t.Errorf("matchFiles(%q, %v) = %v, want %v", rel, patterns, got, want)
+		}
+	}
+	if ExactFiles(nil) != nil {
+		t.Errorf("expected nil for nil")
+	}
+}
+
+// TestLinkModelNestedGGUF tests that GGUF files in subdirectories are linked one by one,
+// so that verification checks them, and that only the resolved snapshot is linked.
+func TestLinkModelNestedGGUF(t *testing.T) {
+	hfCache := t.TempDir()
+	cachetest.MakeRepo(t, hfCache, "models--org--model", "old", map[string]string{"old-only.gguf": "stale"})
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "new", map[string]string{
+		"Q8_0/model-00001-of-00002.gguf": "part1",
+		"Q8_0/model-00002-of-00002.gguf": "part2",
+	})
+	m := ModelInfo{
+		CacheDirName:     "models--org--model",
+		OrganizationName: "org",
+		ModelName:        "model",
+		SourcePath:       repo,
+		TargetPath:       filepath.Join(t.TempDir(), "org", "model"),
+	}
+	if err := LinkModel(m); err != nil {
+		t.Fatalf("LinkModel returned error: %v", err)
+	}
+
+	if info, err := os.Lstat(filepath.Join(m.TargetPath, "Q8_0")); err != nil || !info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("expected Q8_0 to be a real directory (err %v)", err)
+	}
+	part := filepath.Join(m.TargetPath, "Q8_0", "model-00001-of-00002.gguf")
+	if info, err := os.Lstat(part); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected %s to be a symlink (err %v)", part, err)
+	}
+	if _, err := os.Lstat(filepath.Join(m.TargetPath, "old-only.gguf")); !os.IsNotExist(err) {
+		t.Errorf("expected files of other snapshots to be left out, got %v", err)
+	}
+
+	// A corrupted blob of a nested file is caught like any other.
+	real, err := filepath.EvalSymlinks(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(real, []byte("PART1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.IsLinked = true
+	results := VerifyModels(context.Background(), []ModelInfo{m}, LoadVerifyCache(""), 1, nil)
+	if results[0].Checked != 2 || len(results[0].Issues) != 1 || results[0].Issues[0].File != part {
+		t.Errorf("expected the nested file to be checked and flagged, got %+v", results[0])
+	}
+}
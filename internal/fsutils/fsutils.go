@@ -2,13 +2,14 @@
 package fsutils
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
+	"runtime"
 	"time"
 )
 
@@ -17,8 +18,20 @@ const (
 	snapshotsDir = "snapshots"
 )
 
+// hfCacheOverride replaces the OS default Hugging Face cache directory when set.
+var hfCacheOverride string
+
+// SetHfCacheDir makes GetHfCacheDir return path instead of the OS default. An empty path
+// restores the default.
+func SetHfCacheDir(path string) {
+	hfCacheOverride = path
+}
+
 // GetHfCacheDir returns the path to the Hugging Face cache directory based on the OS.
 func GetHfCacheDir() (string, error) {
+	if hfCacheOverride != "" {
+		return hfCacheOverride, nil
+	}
 	switch runtime.GOOS {
 	case "windows":
 		localAppData := os.Getenv("LOCALAPPDATA")
@@ -49,6 +62,48 @@ func GetHfCacheDir() (string, error) {
 	}
 }
 
+// hfCacheExtras are caches read after the main one, in precedence order.
+var hfCacheExtras []string
+
+// SetExtraHfCacheDirs sets caches that are read after the main Hugging Face cache, such as
+// one on another drive (see GetHfCacheDirs).
+func SetExtraHfCacheDirs(paths []string) {
+	hfCacheExtras = append([]string(nil), paths...)
+}
+
+// GetHfCacheDirs returns the main Hugging Face cache followed by the extra caches that
+// exist, in precedence order. A repository in more than one of them is listed, linked and
+// checked from the first; its copies in later caches are ignored, since LM Studio has room
+// for one link per repository. Extra caches that do not exist, e.g. on a drive that is not
+// mounted, and repeats are left out.
+func GetHfCacheDirs() ([]string, error) {
+	hfCache, err := GetHfCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	caches := []string{hfCache}
+	seen := map[string]bool{filepath.Clean(hfCache): true}
+	for _, dir := range hfCacheExtras {
+		if seen[filepath.Clean(dir)] || !isDir(dir) {
+			continue
+		}
+		seen[filepath.Clean(dir)] = true
+		caches = append(caches, dir)
+	}
+	return caches, nil
+}
+
+// repoCache returns the first of caches that holds the repository with the cache directory
+// name, or the main cache when none does.
+func repoCache(caches []string, name string) string {
+	for _, cache := range caches {
+		if isDir(filepath.Join(cache, name)) {
+			return cache
+		}
+	}
+	return caches[0]
+}
+
 // GetLmStudioModelsDir returns the path to the LM Studio models directory based on the OS.
 func GetLmStudioModelsDir() (string, error) {
 	switch runtime.GOOS {
@@ -84,6 +139,7 @@ func GetLmStudioModelsDir() (string, error) {
 // ModelInfo represents a model and its file paths.
 type ModelInfo struct {
 	CacheDirName     string
+	RepoType         RepoType
 	OrganizationName string
 	ModelName        string
 	SourcePath       string
@@ -91,6 +147,49 @@ type ModelInfo struct {
 	IsLinked         bool
 	IsStale          bool
 	StaleReason      string
+	Format           ModelFormat
+	// Quants lists the quantization types of the GGUF files, e.g. Q4_K_M.
+	Quants           []string
+	IsIncomplete     bool
+	IncompleteReason string
+	IsOutdated       bool
+	OutdatedReason   string
+	Size             int64
+	LinkedSize       int64
+	IsCorrupt        bool
+	CorruptReason    string
+	IsExcluded       bool
+	ExcludedBy       string
+	// RuleFiles lists the snapshot files that rules leave to be linked when they exclude
+	// some of them; nil when every file may be linked.
+	RuleFiles []string
+	// LinkedAt is when the link was created, from its marker; zero if not linked.
+	LinkedAt time.Time
+	// ModifiedAt is when a revision or blob was last added to or removed from the cache
+	// repository; zero for stale links.
+	ModifiedAt time.Time
+}
+
+// Status returns a short machine-readable state for the model: "stale", "incomplete",
+// "corrupt", "linked" or "unlinked". Dataset and space repositories report their repo type
+// instead, and models without an organization "unlinkable".
+func (m ModelInfo) Status() string {
+	switch {
+	case !m.IsModelRepo():
+		return string(m.RepoType)
+	case !m.IsLinkable():
+		return "unlinkable"
+	case m.IsStale:
+		return "stale"
+	case m.IsIncomplete:
+		return "incomplete"
+	case m.IsCorrupt:
+		return "corrupt"
+	case m.IsLinked:
+		return "linked"
+	default:
+		return "unlinked"
+	}
 }
 
 // verifySymlinks checks if all symlinks in a directory are valid
@@ -111,12 +210,16 @@ func verifySymlinks(dir string) bool {
 	return true
 }
 
-// LoadModels scans the Hugging Face cache directory for model directories and returns a slice of ModelInfo.
+// LoadModels scans the Hugging Face cache directories for repositories and returns a slice of ModelInfo.
+// Dataset and space repositories are included with their RepoType set so they can be shown,
+// but they are never linked. A repository in several caches is loaded from the first (see
+// GetHfCacheDirs).
 func LoadModels(targetDir string) ([]ModelInfo, error) {
-	hfCache, err := GetHfCacheDir()
+	caches, err := GetHfCacheDirs()
 	if err != nil {
 		return nil, err
 	}
+	hfCache := caches[0]
 
 	if info, err := os.Stat(hfCache); err != nil || !info.IsDir() {
 		return nil, fmt.Errorf("HuggingFace cache directory does not exist or is not a directory: %s", hfCache)
@@ -125,40 +228,105 @@ func LoadModels(targetDir string) ([]ModelInfo, error) {
 		return nil, fmt.Errorf("Target directory does not exist or is not a directory: %s", targetDir)
 	}
 
-	entries, err := ioutil.ReadDir(hfCache)
-	if err != nil {
-		return nil, err
-	}
-
 	var models []ModelInfo
-	for _, entry := range entries {
-		if !entry.IsDir() || !strings.Contains(entry.Name(), "--") {
-			continue
-		}
-		parts := strings.Split(entry.Name(), "--")
-		if len(parts) < 2 {
-			continue
+	loaded := map[string]bool{}
+	for _, cache := range caches {
+		entries, err := ioutil.ReadDir(cache)
+		if err != nil {
+			return nil, err
 		}
-		organization := parts[len(parts)-2]
-		modelName := parts[len(parts)-1]
-		sourcePath := filepath.Join(hfCache, entry.Name())
-		targetPath := filepath.Join(targetDir, organization, modelName)
-		isLinked := false
-		if _, err := os.Stat(filepath.Join(targetPath, metadataFile)); err == nil {
-			// Only mark as linked if both metadata file exists and symlinks are valid
-			isLinked = verifySymlinks(targetPath)
+		for _, entry := range entries {
+			if !entry.IsDir() || loaded[entry.Name()] {
+				continue
+			}
+			if m, ok := loadModel(cache, targetDir, entry.Name()); ok {
+				loaded[entry.Name()] = true
+				models = append(models, m)
+			}
 		}
-		models = append(models, ModelInfo{
-			CacheDirName:     entry.Name(),
+	}
+
+	return models, nil
+}
+
+// loadModel describes the repository in the cache directory name of hfCache and its link
+// in targetDir. ok is false for names that are not repositories that can be listed.
+func loadModel(hfCache, targetDir, name string) (ModelInfo, bool) {
+	repoType, organization, modelName, ok := ParseCacheDirName(name)
+	if !ok {
+		return ModelInfo{}, false
+	}
+	sourcePath := filepath.Join(hfCache, name)
+	if repoType != RepoTypeModel {
+		// Datasets and spaces share the org/name namespace with models, so they get
+		// no target path rather than one that could collide with a linked model.
+		return ModelInfo{
+			CacheDirName:     name,
+			RepoType:         repoType,
 			OrganizationName: organization,
 			ModelName:        modelName,
 			SourcePath:       sourcePath,
-			TargetPath:       targetPath,
-			IsLinked:         isLinked,
-		})
+			Format:           FormatUnsupported,
+			ModifiedAt:       repoModTime(sourcePath),
+		}, true
 	}
+	if organization == "" {
+		// LM Studio expects a publisher/model layout, so repositories without an
+		// organization are listed but have nowhere to be linked.
+		return ModelInfo{
+			CacheDirName:     name,
+			RepoType:         repoType,
+			ModelName:        modelName,
+			SourcePath:       sourcePath,
+			Format:           DetectModelFormat(sourcePath),
+			Quants:           DetectModelQuants(sourcePath),
+			ModifiedAt:       repoModTime(sourcePath),
+		}, true
+	}
+	targetPath := filepath.Join(targetDir, organization, modelName)
+	isLinked := false
+	if _, err := os.Stat(filepath.Join(targetPath, metadataFile)); err == nil {
+		// Only mark as linked if both metadata file exists and symlinks are valid
+		isLinked = verifySymlinks(targetPath)
+	}
+	isIncomplete, incompleteReason := CheckIncomplete(sourcePath)
+	isOutdated, outdatedReason := false, ""
+	var linkedAt time.Time
+	if isLinked {
+		isOutdated, outdatedReason = CheckOutdated(sourcePath, targetPath)
+		if marker, err := ReadMarker(targetPath); err == nil {
+			linkedAt = marker.LinkedAt
+		}
+	}
+	return ModelInfo{
+		CacheDirName:     name,
+		RepoType:         repoType,
+		OrganizationName: organization,
+		ModelName:        modelName,
+		SourcePath:       sourcePath,
+		TargetPath:       targetPath,
+		IsLinked:         isLinked,
+		Format:           DetectModelFormat(sourcePath),
+		Quants:           DetectModelQuants(sourcePath),
+		IsIncomplete:     isIncomplete,
+		IncompleteReason: incompleteReason,
+		IsOutdated:       isOutdated,
+		OutdatedReason:   outdatedReason,
+		LinkedAt:         linkedAt,
+		ModifiedAt:       repoModTime(sourcePath),
+	}, true
+}
 
-	return models, nil
+// repoModTime returns when a revision or blob was last added to or removed from a cache
+// repository.
+func repoModTime(sourcePath string) time.Time {
+	latest := time.Time{}
+	for _, dir := range repoDirs(filepath.Dir(sourcePath), filepath.Base(sourcePath)) {
+		if t := modTime(dir); t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
 }
 
 // FindStaleLinks recursively walks the target directory and identifies linked directories whose source no longer exists.
@@ -168,31 +336,21 @@ func FindStaleLinks(targetDir string) ([]ModelInfo, error) {
 		if err != nil {
 			return err
 		}
+		// Links being built or replaced are not stale
+		if d.IsDir() && isScratchDir(d.Name()) {
+			return filepath.SkipDir
+		}
 		// Look for directories that contain the metadata file.
 		if d.IsDir() {
-			metadataPath := filepath.Join(path, metadataFile)
-			if _, err := os.Stat(metadataPath); err == nil {
-				parentDir := filepath.Dir(path)
-				organization := filepath.Base(parentDir)
-				modelName := filepath.Base(path)
-				cacheDirName := "models--" + organization + "--" + modelName
-				hfCache, err := GetHfCacheDir()
-				if err != nil {
-					return err
-				}
-				sourcePath := filepath.Join(hfCache, cacheDirName, snapshotsDir)
-				if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
-					stale = append(stale, ModelInfo{
-						CacheDirName:     cacheDirName,
-						OrganizationName: organization,
-						ModelName:        modelName,
-						SourcePath:       sourcePath,
-						TargetPath:       path,
-						IsLinked:         true,
-						IsStale:          true,
-						StaleReason:      "Source directory not found",
-					})
-				}
+			if _, err := os.Stat(filepath.Join(path, metadataFile)); err != nil {
+				return nil
+			}
+			caches, err := GetHfCacheDirs()
+			if err != nil {
+				return err
+			}
+			if m, ok := staleLink(caches, path); ok {
+				stale = append(stale, m)
 			}
 		}
 		return nil
@@ -200,8 +358,74 @@ func FindStaleLinks(targetDir string) ([]ModelInfo, error) {
 	return stale, err
 }
 
-// LinkModel creates symlinks from the snapshot files in the source to the target directory and writes a metadata file.
+// staleLink reports whether path is a linked directory whose source is in none of caches.
+func staleLink(caches []string, path string) (ModelInfo, bool) {
+	if _, err := os.Stat(filepath.Join(path, metadataFile)); err != nil {
+		return ModelInfo{}, false
+	}
+	organization := filepath.Base(filepath.Dir(path))
+	modelName := filepath.Base(path)
+	cacheDirName := CacheDirName(RepoTypeModel, organization, modelName)
+	for _, cache := range caches {
+		if _, err := os.Stat(filepath.Join(cache, cacheDirName, snapshotsDir)); !os.IsNotExist(err) {
+			return ModelInfo{}, false
+		}
+	}
+	sourcePath := filepath.Join(caches[0], cacheDirName, snapshotsDir)
+	var linkedAt time.Time
+	if marker, err := ReadMarker(path); err == nil {
+		linkedAt = marker.LinkedAt
+	}
+	return ModelInfo{
+		CacheDirName:     cacheDirName,
+		RepoType:         RepoTypeModel,
+		OrganizationName: organization,
+		ModelName:        modelName,
+		SourcePath:       sourcePath,
+		TargetPath:       path,
+		IsLinked:         true,
+		IsStale:          true,
+		StaleReason:      "Source directory not found",
+		LinkedAt:         linkedAt,
+	}, true
+}
+
+// LinkModel links the files of the resolved snapshot (see ResolveSnapshot) into the target
+// directory and writes a metadata file. Only that snapshot is linked, not the files of
+// older revisions. The snapshot's directory structure is kept, with every file linked
+// individually so that verification can check nested files too.
+// Repositories that are still downloading are rejected with ErrIncomplete before the
+// target directory is touched. A pinned revision other than the resolved snapshot is only
+// rejected if its own files are missing.
 func LinkModel(m ModelInfo) error {
+	return LinkModelWith(context.Background(), m, LinkOptions{})
+}
+
+// LinkOptions selects what LinkModelWith links.
+type LinkOptions struct {
+	// Revision is a ref name or commit hash to link instead of the default snapshot.
+	Revision string
+	// Files limits the link to snapshot files matching these glob patterns. Patterns
+	// without a slash also match file names in subdirectories; a leading slash anchors a
+	// pattern at the snapshot root (see ExactFiles).
+	Files []string
+	// Mode selects symlinks (the default), hard links or copies.
+	Mode LinkMode
+	// OnProgress, if set, is called as each file is placed and while copies progress.
+	OnProgress func(LinkProgress)
+}
+
+// LinkModelWith is LinkModel with an explicit revision, file selection and link mode. The
+// choices are recorded in the metadata file. Cancelling ctx stops the link before it is
+// moved into place, leaving the target as it was.
+func LinkModelWith(ctx context.Context, m ModelInfo, opts LinkOptions) error {
+	if !m.IsModelRepo() {
+		return fmt.Errorf("%w: %s is a %s", ErrNotModelRepo, m.RepoID(), m.RepoType)
+	}
+	// Models without an organization are loaded without a target path
+	if m.TargetPath == "" {
+		return fmt.Errorf("%w: %s has nowhere to be linked", ErrNoOrganization, m.RepoID())
+	}
 	if info, err := os.Stat(m.SourcePath); err != nil || !info.IsDir() {
 		return fmt.Errorf("source path %s does not exist or is not a directory", m.SourcePath)
 	}
@@ -209,48 +433,46 @@ func LinkModel(m ModelInfo) error {
 	if info, err := os.Stat(snapshotsPath); err != nil || !info.IsDir() {
 		return fmt.Errorf("snapshots directory %s does not exist", snapshotsPath)
 	}
-	snapshotDirs, err := ioutil.ReadDir(snapshotsPath)
+	mode, err := ParseLinkMode(string(opts.Mode))
 	if err != nil {
 		return err
 	}
-	
-	// Clean up existing target directory if it exists
-	if _, err := os.Stat(m.TargetPath); err == nil {
-		if err := os.RemoveAll(m.TargetPath); err != nil {
-			return fmt.Errorf("failed to clean up existing target directory: %v", err)
-		}
-	}
-	
-	if err := os.MkdirAll(m.TargetPath, 0755); err != nil {
+	revision, snapPath, err := ResolveRevision(m.SourcePath, opts.Revision)
+	if err != nil {
 		return err
 	}
-	
-	for _, snapDir := range snapshotDirs {
-		if !snapDir.IsDir() {
-			continue
-		}
-		snapPath := filepath.Join(snapshotsPath, snapDir.Name())
-		files, err := ioutil.ReadDir(snapPath)
-		if err != nil {
+	format := DetectFormat(snapPath)
+	if len(opts.Files) > 0 && !anyFileMatches(snapPath, opts.Files) {
+		return fmt.Errorf("no files in revision %s match %s", revision, strings.Join(opts.Files, ", "))
+	}
+	// A download in progress only concerns the default snapshot it is updating; another
+	// revision is checked for its own missing files
+	var incomplete bool
+	var reason string
+	if _, current, err := ResolveSnapshot(m.SourcePath); err == nil && current == snapPath {
+		incomplete, reason = CheckIncomplete(m.SourcePath)
+	} else {
+		incomplete, reason = checkSnapshotIncomplete(snapPath)
+	}
+	if incomplete {
+		return fmt.Errorf("%w: %s", ErrIncomplete, reason)
+	}
+
+	// Build the links next to the target and swap them in, replacing an existing link only
+	// once the new one is complete
+	opts.Mode = mode
+	return buildStaged(m.TargetPath, func(staging string) error {
+		if err := linkTree(ctx, snapPath, staging, opts); err != nil {
 			return err
 		}
-		for _, file := range files {
-			src := filepath.Join(snapPath, file.Name())
-			dst := filepath.Join(m.TargetPath, file.Name())
-			
-			// Always try to resolve the real source file
-			realSource, err := filepath.EvalSymlinks(src)
-			if err != nil {
-				return fmt.Errorf("failed to resolve symlink for %s: %v", src, err)
-			}
-			
-			if err := os.Symlink(realSource, dst); err != nil {
-				return fmt.Errorf("failed to create symlink from %s to %s: %v", realSource, dst, err)
-			}
-		}
-	}
-	metadataContent := []byte(time.Now().Format(time.RFC3339))
-	return ioutil.WriteFile(filepath.Join(m.TargetPath, metadataFile), metadataContent, 0644)
+		return writeMarker(staging, LinkMarker{
+			LinkedAt: time.Now(),
+			Revision: revision,
+			Format:   format,
+			Files:    opts.Files,
+			Mode:     mode,
+		})
+	})
 }
 
 // UnlinkModel removes the target directory if it contains the metadata file.
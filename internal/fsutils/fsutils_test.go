@@ -7,6 +7,8 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
 )
 
 // TestGetHfCacheDir_XDG tests that when XDG_CACHE_HOME is set (on Linux),
@@ -324,3 +326,107 @@ func TestUnlinkModelNoMetadata(t *testing.T) {
 		t.Errorf("expected no error from UnlinkModel when metadata is missing, got %v", err)
 	}
 }
+
+// TestLoadModelsOutdated tests that a link to an older revision than refs/main is marked
+// outdated, and that relinking clears the mark. Linked models carry their link time.
+func TestLoadModelsOutdated(t *testing.T) {
+	hfCache := setHfCache(t)
+	targetDir := t.TempDir()
+	cachetest.MakeRepo(t, hfCache, "models--org--model", "aaaa1111", map[string]string{"m.gguf": "v1"})
+	models, err := LoadModels(targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := LinkModel(models[0]); err != nil {
+		t.Fatal(err)
+	}
+	if models, _ = LoadModels(targetDir); models[0].IsOutdated {
+		t.Fatalf("expected a fresh link not to be outdated: %s", models[0].OutdatedReason)
+	}
+	if models[0].LinkedAt.IsZero() || models[0].ModifiedAt.IsZero() {
+		t.Errorf("expected the link and modification times to be set, got %v and %v", models[0].LinkedAt, models[0].ModifiedAt)
+	}
+
+	// A new revision moves refs/main.
+	cachetest.MakeRepo(t, hfCache, "models--org--model", "bbbb2222", map[string]string{"m.gguf": "v2"})
+	models, _ = LoadModels(targetDir)
+	if !models[0].IsOutdated || !strings.Contains(models[0].OutdatedReason, "aaaa1111") || !strings.Contains(models[0].OutdatedReason, "bbbb2222") {
+		t.Fatalf("expected the link to be outdated, got %v %q", models[0].IsOutdated, models[0].OutdatedReason)
+	}
+
+	if err := UnlinkModel(models[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := LinkModel(models[0]); err != nil {
+		t.Fatal(err)
+	}
+	if models, _ = LoadModels(targetDir); models[0].IsOutdated {
+		t.Errorf("expected the relinked model not to be outdated: %s", models[0].OutdatedReason)
+	}
+}
+
+// TestExtraCaches tests that repositories are read from every cache, that the first cache
+// holding a repository wins, and that links into an extra cache are not stale.
+func TestExtraCaches(t *testing.T) {
+	hfCache := setHfCache(t)
+	extra := t.TempDir()
+	missing := filepath.Join(t.TempDir(), "unmounted")
+	SetExtraHfCacheDirs([]string{extra, hfCache, missing, extra})
+	t.Cleanup(func() { SetExtraHfCacheDirs(nil) })
+	targetDir := t.TempDir()
+
+	caches, err := GetHfCacheDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caches) != 2 || caches[0] != hfCache || caches[1] != extra {
+		t.Fatalf("expected the main cache and the existing extra cache once each, got %v", caches)
+	}
+
+	cachetest.MakeRepo(t, hfCache, "models--org--shared", "aaaa", map[string]string{"a.gguf": "main"})
+	cachetest.MakeRepo(t, extra, "models--org--shared", "bbbb", map[string]string{"a.gguf": "extra"})
+	cachetest.MakeRepo(t, extra, "models--org--only", "cccc", map[string]string{"a.gguf": "only"})
+
+	ix := NewIndex(targetDir)
+	if err := ix.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	models, _ := ix.Models()
+	sources := map[string]string{}
+	for _, m := range models {
+		sources[m.RepoID()] = filepath.Dir(m.SourcePath)
+	}
+	if len(models) != 2 || sources["org/shared"] != hfCache || sources["org/only"] != extra {
+		t.Fatalf("expected org/shared from the main cache and org/only from the extra one, got %v", sources)
+	}
+
+	for _, m := range models {
+		if m.RepoID() == "org/only" {
+			if err := LinkModel(m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if stale, err := FindStaleLinks(targetDir); err != nil || len(stale) != 0 {
+		t.Errorf("expected a link into the extra cache not to be stale, got %v (err %v)", stale, err)
+	}
+
+	// Once the main copy is gone the extra cache's copy takes its place
+	if err := os.RemoveAll(filepath.Join(hfCache, "models--org--shared")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Refresh(filepath.Join(targetDir, "org", "shared")); err != nil {
+		t.Fatal(err)
+	}
+	models, _ = ix.Models()
+	for _, m := range models {
+		if m.RepoID() == "org/shared" && filepath.Dir(m.SourcePath) != extra {
+			t.Errorf("expected org/shared from the extra cache after the main copy was deleted, got %s", m.SourcePath)
+		}
+	}
+
+	SetExtraHfCacheDirs(nil)
+	if stale, err := FindStaleLinks(targetDir); err != nil || len(stale) != 1 {
+		t.Errorf("expected the link to be stale without the extra cache, got %v (err %v)", stale, err)
+	}
+}
@@ -0,0 +1,240 @@
+package fsutils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// GGUF metadata value types, as stored in the file.
+const (
+	ggufUint8 uint32 = iota
+	ggufInt8
+	ggufUint16
+	ggufInt16
+	ggufUint32
+	ggufInt32
+	ggufFloat32
+	ggufBool
+	ggufString
+	ggufArray
+	ggufUint64
+	ggufInt64
+	ggufFloat64
+)
+
+// ggufMaxString bounds the strings read from a GGUF header, so that a corrupt length does
+// not allocate gigabytes.
+const ggufMaxString = 16 << 20
+
+// ErrNotGGUF is returned by ReadGGUF for files without the GGUF magic.
+var ErrNotGGUF = errors.New("not a GGUF file")
+
+// GGUFMetadata is the header of a GGUF file.
+type GGUFMetadata struct {
+	Version     uint32
+	TensorCount uint64
+	// Values maps metadata keys to their values: integers as int64 or uint64, floats as
+	// float64, and bools and strings as such. Arrays are only counted, as an ArrayLen.
+	Values map[string]interface{}
+}
+
+// ArrayLen stands in for a metadata array, like the tokenizer vocabulary, by its length.
+type ArrayLen uint64
+
+// ReadGGUF reads the metadata of a GGUF file, versions 2 and 3.
+func ReadGGUF(path string) (GGUFMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return GGUFMetadata{}, err
+	}
+	defer f.Close()
+	g, err := readGGUF(bufio.NewReader(f))
+	if err != nil && !errors.Is(err, ErrNotGGUF) {
+		err = fmt.Errorf("%s: %v", path, err)
+	}
+	return g, err
+}
+
+// readGGUF parses a GGUF header.
+func readGGUF(r io.Reader) (GGUFMetadata, error) {
+	var header struct {
+		Magic       [4]byte
+		Version     uint32
+		TensorCount uint64
+		KVCount     uint64
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return GGUFMetadata{}, ErrNotGGUF
+	}
+	if string(header.Magic[:]) != "GGUF" {
+		return GGUFMetadata{}, ErrNotGGUF
+	}
+	if header.Version < 2 || header.Version > 3 {
+		return GGUFMetadata{}, fmt.Errorf("unsupported GGUF version %d", header.Version)
+	}
+	g := GGUFMetadata{Version: header.Version, TensorCount: header.TensorCount, Values: map[string]interface{}{}}
+	for i := uint64(0); i < header.KVCount; i++ {
+		key, err := readGGUFString(r)
+		if err != nil {
+			return g, fmt.Errorf("metadata key %d: %v", i, err)
+		}
+		var typ uint32
+		if err := binary.Read(r, binary.LittleEndian, &typ); err != nil {
+			return g, fmt.Errorf("metadata %s: %v", key, err)
+		}
+		value, err := readGGUFValue(r, typ)
+		if err != nil {
+			return g, fmt.Errorf("metadata %s: %v", key, err)
+		}
+		g.Values[key] = value
+	}
+	return g, nil
+}
+
+// readGGUFString reads a length-prefixed string.
+func readGGUFString(r io.Reader) (string, error) {
+	var n uint64
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", err
+	}
+	if n > ggufMaxString {
+		return "", fmt.Errorf("string of %d bytes is too long", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// readGGUFValue reads a value of the given type. Arrays are read past and returned as
+// their length.
+func readGGUFValue(r io.Reader, typ uint32) (interface{}, error) {
+	read := func(v interface{}) error { return binary.Read(r, binary.LittleEndian, v) }
+	switch typ {
+	case ggufUint8:
+		var v uint8
+		err := read(&v)
+		return uint64(v), err
+	case ggufInt8:
+		var v int8
+		err := read(&v)
+		return int64(v), err
+	case ggufUint16:
+		var v uint16
+		err := read(&v)
+		return uint64(v), err
+	case ggufInt16:
+		var v int16
+		err := read(&v)
+		return int64(v), err
+	case ggufUint32:
+		var v uint32
+		err := read(&v)
+		return uint64(v), err
+	case ggufInt32:
+		var v int32
+		err := read(&v)
+		return int64(v), err
+	case ggufUint64:
+		var v uint64
+		err := read(&v)
+		return v, err
+	case ggufInt64:
+		var v int64
+		err := read(&v)
+		return v, err
+	case ggufFloat32:
+		var v uint32
+		err := read(&v)
+		return float64(math.Float32frombits(v)), err
+	case ggufFloat64:
+		var v float64
+		err := read(&v)
+		return v, err
+	case ggufBool:
+		var v uint8
+		err := read(&v)
+		return v != 0, err
+	case ggufString:
+		return readGGUFString(r)
+	case ggufArray:
+		var elem uint32
+		var n uint64
+		if err := read(&elem); err != nil {
+			return nil, err
+		}
+		if err := read(&n); err != nil {
+			return nil, err
+		}
+		if elem == ggufArray {
+			return nil, errors.New("nested arrays are not supported")
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := readGGUFValue(r, elem); err != nil {
+				return nil, err
+			}
+		}
+		return ArrayLen(n), nil
+	}
+	return nil, fmt.Errorf("unknown value type %d", typ)
+}
+
+// ggufFileTypes names the values of general.file_type, the quantization of most tensors.
+var ggufFileTypes = map[uint64]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 7: "Q8_0", 8: "Q5_0", 9: "Q5_1",
+	10: "Q2_K", 11: "Q3_K_S", 12: "Q3_K_M", 13: "Q3_K_L", 14: "Q4_K_S", 15: "Q4_K_M",
+	16: "Q5_K_S", 17: "Q5_K_M", 18: "Q6_K", 19: "IQ2_XXS", 20: "IQ2_XS", 21: "Q2_K_S",
+	22: "IQ3_XS", 23: "IQ3_XXS", 24: "IQ1_S", 25: "IQ4_NL", 26: "IQ3_S", 27: "IQ3_M",
+	28: "IQ2_S", 29: "IQ2_M", 30: "IQ4_XS", 31: "IQ1_M", 32: "BF16",
+}
+
+// GGUFField is a metadata value formatted for display.
+type GGUFField struct {
+	Key   string
+	Value string
+}
+
+// Summary returns the metadata most useful for telling models apart, in a fixed order:
+// name, architecture, size label, file type, context length, layer count, embedding size,
+// tokenizer and the number of tensors. Keys the file does not have are left out.
+func (g GGUFMetadata) Summary() []GGUFField {
+	arch, _ := g.Values["general.architecture"].(string)
+	keys := []string{"general.name", "general.architecture", "general.size_label", "general.file_type"}
+	if arch != "" {
+		keys = append(keys, arch+".context_length", arch+".block_count", arch+".embedding_length")
+	}
+	keys = append(keys, "tokenizer.ggml.model")
+	var fields []GGUFField
+	for _, key := range keys {
+		value, ok := g.Values[key]
+		if !ok {
+			continue
+		}
+		text := formatGGUFValue(value)
+		if n, ok := value.(uint64); ok && key == "general.file_type" {
+			if name, ok := ggufFileTypes[n]; ok {
+				text = name
+			}
+		}
+		fields = append(fields, GGUFField{Key: strings.TrimPrefix(key, arch+"."), Value: text})
+	}
+	return append(fields, GGUFField{Key: "tensors", Value: fmt.Sprint(g.TensorCount)})
+}
+
+// formatGGUFValue formats a metadata value for display.
+func formatGGUFValue(value interface{}) string {
+	switch v := value.(type) {
+	case ArrayLen:
+		return fmt.Sprintf("[%d items]", uint64(v))
+	case float64:
+		return fmt.Sprintf("%g", v)
+	}
+	return fmt.Sprint(value)
+}
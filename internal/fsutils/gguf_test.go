@@ -0,0 +1,98 @@
+package fsutils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// ggufWriter builds GGUF headers for tests.
+type ggufWriter struct {
+	bytes.Buffer
+}
+
+// put writes little-endian values.
+func (w *ggufWriter) put(values ...interface{}) {
+	for _, v := range values {
+		binary.Write(w, binary.LittleEndian, v)
+	}
+}
+
+// str writes a length-prefixed string.
+func (w *ggufWriter) str(s string) {
+	w.put(uint64(len(s)))
+	w.WriteString(s)
+}
+
+// kv writes a metadata key with its type; the value follows.
+func (w *ggufWriter) kv(key string, typ uint32) {
+	w.str(key)
+	w.put(typ)
+}
+
+// testGGUF returns a version 3 GGUF header with typical llama metadata.
+func testGGUF() []byte {
+	var w ggufWriter
+	w.WriteString("GGUF")
+	w.put(uint32(3), uint64(291), uint64(7))
+	w.kv("general.architecture", ggufString)
+	w.str("llama")
+	w.kv("general.name", ggufString)
+	w.str("Llama 3 8B Instruct")
+	w.kv("general.file_type", ggufUint32)
+	w.put(uint32(15))
+	w.kv("llama.context_length", ggufUint32)
+	w.put(uint32(8192))
+	w.kv("llama.rope.freq_base", ggufFloat32)
+	w.put(float32(500000))
+	w.kv("tokenizer.ggml.tokens", ggufArray)
+	w.put(ggufString, uint64(3))
+	w.str("<s>")
+	w.str("</s>")
+	w.str("hello")
+	w.kv("tokenizer.ggml.add_bos_token", ggufBool)
+	w.put(uint8(1))
+	return w.Bytes()
+}
+
+// TestReadGGUF tests that metadata of every kind is read and summarized.
+func TestReadGGUF(t *testing.T) {
+	g, err := readGGUF(bytes.NewReader(testGGUF()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Version != 3 || g.TensorCount != 291 {
+		t.Errorf("unexpected header %d/%d", g.Version, g.TensorCount)
+	}
+	if g.Values["tokenizer.ggml.tokens"] != ArrayLen(3) || g.Values["tokenizer.ggml.add_bos_token"] != true || g.Values["llama.rope.freq_base"] != float64(500000) {
+		t.Errorf("unexpected values %v", g.Values)
+	}
+	want := []GGUFField{
+		{"general.name", "Llama 3 8B Instruct"},
+		{"general.architecture", "llama"},
+		{"general.file_type", "Q4_K_M"},
+		{"context_length", "8192"},
+		{"tensors", "291"},
+	}
+	if got := g.Summary(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got summary %v, want %v", got, want)
+	}
+}
+
+// TestReadGGUFErrors tests files that are not GGUF, unsupported and truncated.
+func TestReadGGUFErrors(t *testing.T) {
+	if _, err := readGGUF(bytes.NewReader([]byte("weights"))); !errors.Is(err, ErrNotGGUF) {
+		t.Errorf("expected ErrNotGGUF, got %v", err)
+	}
+	v1 := testGGUF()
+	v1[4] = 1
+	if _, err := readGGUF(bytes.NewReader(v1)); err == nil || errors.Is(err, ErrNotGGUF) {
+		t.Errorf("expected an unsupported version error, got %v", err)
+	}
+	truncated := testGGUF()
+	if _, err := readGGUF(bytes.NewReader(truncated[:len(truncated)-20])); err == nil {
+		t.Errorf("expected an error for a truncated header")
+	}
+}
@@ -0,0 +1,58 @@
+package fsutils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	blobsDir         = "blobs"
+	incompleteSuffix = ".incomplete"
+)
+
+// ErrIncomplete is returned when linking a repository whose download has not finished.
+var ErrIncomplete = errors.New("download incomplete")
+
+// CheckIncomplete reports whether a cache repository is still being downloaded or is
+// missing data. huggingface_hub writes *.incomplete files into blobs/ while a download is
+// in progress, and snapshot symlinks can point at blobs that do not exist yet.
+func CheckIncomplete(sourcePath string) (bool, string) {
+	entries, err := ioutil.ReadDir(filepath.Join(sourcePath, blobsDir))
+	if err == nil {
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), incompleteSuffix) {
+				return true, "Download in progress"
+			}
+		}
+	}
+
+	_, snapPath, err := ResolveSnapshot(sourcePath)
+	if err != nil {
+		return false, ""
+	}
+	return checkSnapshotIncomplete(snapPath)
+}
+
+// checkSnapshotIncomplete reports whether files of one snapshot are symlinks to blobs that
+// do not exist, ignoring downloads of other revisions in progress in the same repository.
+func checkSnapshotIncomplete(snapPath string) (bool, string) {
+	missing := 0
+	filepath.WalkDir(snapPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if _, err := os.Stat(path); err != nil {
+			missing++
+		}
+		return nil
+	})
+	if missing > 0 {
+		return true, fmt.Sprintf("%d file(s) missing from blobs", missing)
+	}
+	return false, ""
+}
@@ -0,0 +1,107 @@
+package fsutils
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
+)
+
+// TestCheckIncomplete detects in-progress downloads and snapshot symlinks to missing blobs.
+func TestCheckIncomplete(t *testing.T) {
+	hfCache := t.TempDir()
+
+	complete := cachetest.MakeRepo(t, hfCache, "models--org--complete", "rev", map[string]string{"a.gguf": "a"})
+	if incomplete, reason := CheckIncomplete(complete); incomplete {
+		t.Errorf("expected complete repository, got incomplete: %s", reason)
+	}
+
+	downloading := cachetest.MakeRepo(t, hfCache, "models--org--downloading", "rev", map[string]string{"a.gguf": "a"})
+	partial := filepath.Join(downloading, "blobs", "deadbeef.incomplete")
+	if err := ioutil.WriteFile(partial, []byte("par"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if incomplete, _ := CheckIncomplete(downloading); !incomplete {
+		t.Errorf("expected repository with .incomplete blob to be incomplete")
+	}
+
+	dangling := cachetest.MakeRepo(t, hfCache, "models--org--dangling", "rev", map[string]string{"a.gguf": "a"})
+	if err := os.Symlink("../../blobs/missing", filepath.Join(dangling, "snapshots", "rev", "b.gguf")); err != nil {
+		t.Fatal(err)
+	}
+	if incomplete, _ := CheckIncomplete(dangling); !incomplete {
+		t.Errorf("expected repository with dangling snapshot symlink to be incomplete")
+	}
+}
+
+// TestLinkModelIncomplete tests that linking an incomplete repository fails with ErrIncomplete
+// and leaves an existing target untouched.
+func TestLinkModelIncomplete(t *testing.T) {
+	hfCache := setHfCache(t)
+	targetDir := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "rev", map[string]string{"a.gguf": "a"})
+	if err := ioutil.WriteFile(filepath.Join(repo, "blobs", "cafe.incomplete"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	models, err := LoadModels(targetDir)
+	if err != nil {
+		t.Fatalf("LoadModels returned error: %v", err)
+	}
+	if len(models) != 1 || !models[0].IsIncomplete {
+		t.Fatalf("expected one incomplete model, got %+v", models)
+	}
+
+	existing := filepath.Join(models[0].TargetPath, "keep.txt")
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(existing, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = LinkModel(models[0])
+	if !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("expected ErrIncomplete, got %v", err)
+	}
+	if _, err := os.Stat(existing); err != nil {
+		t.Errorf("expected target to be left untouched: %v", err)
+	}
+}
+
+// TestLinkPinnedRevisionIncomplete tests that a pinned revision is judged by its own files,
+// not by a download of the default revision in progress.
+func TestLinkPinnedRevisionIncomplete(t *testing.T) {
+	hfCache := t.TempDir()
+	targetDir := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "aaaa1111", map[string]string{"a.gguf": "a"})
+	cachetest.MakeRepo(t, hfCache, "models--org--model", "bbbb2222", map[string]string{"a.gguf": "b"})
+	if err := ioutil.WriteFile(filepath.Join(repo, "blobs", "cafe.incomplete"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := ModelInfo{
+		CacheDirName:     "models--org--model",
+		OrganizationName: "org",
+		ModelName:        "model",
+		SourcePath:       repo,
+		TargetPath:       filepath.Join(targetDir, "org", "model"),
+	}
+
+	if err := LinkModelWith(context.Background(), m, LinkOptions{}); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("expected the downloading default revision to be refused, got %v", err)
+	}
+	if err := LinkModelWith(context.Background(), m, LinkOptions{Revision: "aaaa1111"}); err != nil {
+		t.Errorf("expected the complete pinned revision to link, got %v", err)
+	}
+
+	if err := os.Symlink("../../blobs/missing", filepath.Join(repo, "snapshots", "aaaa1111", "b.gguf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := LinkModelWith(context.Background(), m, LinkOptions{Revision: "aaaa1111"}); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("expected a pinned revision with a missing blob to be refused with ErrIncomplete, got %v", err)
+	}
+}
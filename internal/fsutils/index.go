@@ -0,0 +1,271 @@
+package fsutils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Index keeps the models of the Hugging Face caches and the stale links of a target
+// directory in memory, keyed by repository, so that after an operation only the models it
+// touched are scanned again. The subdirectories of the caches and the target directory, and
+// the modification times of the directories below them that gain or lose entries when
+// something else changes a repository or a link, tell when a full scan is needed instead.
+// The top-level directories are compared by listing because lock files come and go there.
+type Index struct {
+	targetDir string
+
+	mu sync.Mutex
+	// caches are the caches of the last scan (see GetHfCacheDirs)
+	caches []string
+	// models is keyed by cache directory name, which holds the repo type and id
+	models map[string]ModelInfo
+	// stale is keyed by target path
+	stale    map[string]ModelInfo
+	mtimes   map[string]time.Time
+	listings map[string]string
+	// annotate is applied to the models returned by Models
+	annotate func([]ModelInfo)
+}
+
+// NewIndex returns an empty index of targetDir; call Scan to fill it.
+func NewIndex(targetDir string) *Index {
+	return &Index{targetDir: targetDir}
+}
+
+// TargetDir returns the target directory the index describes.
+func (ix *Index) TargetDir() string {
+	return ix.targetDir
+}
+
+// Scan rescans the cache and the target directory.
+func (ix *Index) Scan() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return ix.scanLocked()
+}
+
+func (ix *Index) scanLocked() error {
+	caches, err := GetHfCacheDirs()
+	if err != nil {
+		return err
+	}
+	// Times are taken before scanning so that changes made during the scan are noticed
+	mtimes := map[string]time.Time{}
+	listings := map[string]string{ix.targetDir: listDirs(ix.targetDir)}
+	var watched []string
+	for _, cache := range caches {
+		listings[cache] = listDirs(cache)
+		if entries, err := ioutil.ReadDir(cache); err == nil {
+			for _, entry := range entries {
+				watched = append(watched, repoDirs(cache, entry.Name())...)
+			}
+		}
+	}
+	if entries, err := ioutil.ReadDir(ix.targetDir); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				watched = append(watched, filepath.Join(ix.targetDir, entry.Name()))
+			}
+		}
+	}
+	for _, dir := range watched {
+		mtimes[dir] = modTime(dir)
+	}
+
+	models, err := LoadModels(ix.targetDir)
+	if err != nil {
+		return err
+	}
+	stale, err := FindStaleLinks(ix.targetDir)
+	if err != nil {
+		return err
+	}
+	ix.models = make(map[string]ModelInfo, len(models))
+	for _, m := range models {
+		ix.models[m.CacheDirName] = m
+	}
+	ix.stale = make(map[string]ModelInfo, len(stale))
+	for _, m := range stale {
+		ix.stale[m.TargetPath] = m
+	}
+	ix.caches = caches
+	ix.mtimes = mtimes
+	ix.listings = listings
+	return nil
+}
+
+// Changed reports whether a watched directory was modified since it was last scanned.
+func (ix *Index) Changed() bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return ix.changedLocked()
+}
+
+func (ix *Index) changedLocked() bool {
+	if ix.mtimes == nil {
+		return true
+	}
+	for dir, listing := range ix.listings {
+		if listDirs(dir) != listing {
+			return true
+		}
+	}
+	for dir, mtime := range ix.mtimes {
+		if !modTime(dir).Equal(mtime) {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh rescans the models linked at the given target paths, or everything when the
+// index is empty or a watched directory changed. Paths outside the target directory are
+// ignored.
+func (ix *Index) Refresh(paths ...string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	caches, err := GetHfCacheDirs()
+	if err != nil {
+		return err
+	}
+	var targets []string
+	for _, path := range paths {
+		if filepath.Dir(filepath.Dir(filepath.Clean(path))) == filepath.Clean(ix.targetDir) {
+			targets = append(targets, path)
+		}
+	}
+	// An extra cache that was mounted or unmounted changes which copy of a repository wins
+	if ix.mtimes == nil || !isDir(caches[0]) || !isDir(ix.targetDir) || strings.Join(caches, "\n") != strings.Join(ix.caches, "\n") {
+		return ix.scanLocked()
+	}
+	// Only the directories that operations on the targets change are expected to move:
+	// the organization directory of each target, the target directory when that was
+	// created or removed, and the cache directory when the repository was deleted
+	expected := map[string]bool{}
+	for _, target := range targets {
+		org := filepath.Dir(target)
+		expected[org] = true
+		if _, watched := ix.mtimes[org]; watched != isDir(org) {
+			expected[ix.targetDir] = true
+		}
+		name := targetCacheDirName(target)
+		for _, cache := range caches {
+			for _, dir := range repoDirs(cache, name) {
+				expected[dir] = true
+			}
+		}
+		if m, indexed := ix.models[name]; indexed && !isDir(m.SourcePath) {
+			expected[filepath.Dir(m.SourcePath)] = true
+		}
+	}
+	for dir, listing := range ix.listings {
+		if !expected[dir] && listDirs(dir) != listing {
+			return ix.scanLocked()
+		}
+	}
+	for dir, mtime := range ix.mtimes {
+		if !expected[dir] && !modTime(dir).Equal(mtime) {
+			return ix.scanLocked()
+		}
+	}
+
+	for dir := range expected {
+		if _, ok := ix.listings[dir]; ok {
+			ix.listings[dir] = listDirs(dir)
+		} else {
+			ix.mtimes[dir] = modTime(dir)
+		}
+	}
+	for _, target := range targets {
+		name := targetCacheDirName(target)
+		if m, ok := loadModel(repoCache(caches, name), ix.targetDir, name); ok && isDir(m.SourcePath) {
+			ix.models[name] = m
+		} else {
+			delete(ix.models, name)
+		}
+		if m, ok := staleLink(caches, target); ok {
+			ix.stale[target] = m
+		} else {
+			delete(ix.stale, target)
+		}
+	}
+	return nil
+}
+
+// Annotate sets a function that Models applies to the models it returns before returning
+// them, such as the evaluation of include and exclude rules. It runs on the goroutine
+// calling Models, which should not be one that must stay responsive.
+func (ix *Index) Annotate(fn func(models []ModelInfo)) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.annotate = fn
+}
+
+// Models returns the indexed models sorted by cache directory name, and the stale links
+// sorted by target path.
+func (ix *Index) Models() (models, stale []ModelInfo) {
+	models, stale, annotate := ix.list()
+	if annotate != nil {
+		annotate(models)
+	}
+	return models, stale
+}
+
+// list copies the indexed models and stale links in order.
+func (ix *Index) list() (models, stale []ModelInfo, annotate func([]ModelInfo)) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for _, m := range ix.models {
+		models = append(models, m)
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].CacheDirName < models[j].CacheDirName })
+	for _, m := range ix.stale {
+		stale = append(stale, m)
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].TargetPath < stale[j].TargetPath })
+	return models, stale, ix.annotate
+}
+
+// repoDirs returns the directories of a cached repository that change when it is
+// downloaded, updated or removed.
+func repoDirs(hfCache, name string) []string {
+	repo := filepath.Join(hfCache, name)
+	return []string{filepath.Join(repo, snapshotsDir), filepath.Join(repo, blobsDir)}
+}
+
+// targetCacheDirName returns the cache directory name of the model linked at a target path.
+func targetCacheDirName(target string) string {
+	return CacheDirName(RepoTypeModel, filepath.Base(filepath.Dir(target)), filepath.Base(target))
+}
+
+// modTime returns the modification time of path, zero if it does not exist.
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// listDirs returns the names of the subdirectories of path, one per line.
+func listDirs(path string) string {
+	entries, _ := ioutil.ReadDir(path)
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return strings.Join(names, "\n")
+}
+
+// isDir reports whether path is a directory.
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
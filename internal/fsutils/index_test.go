@@ -0,0 +1,109 @@
+package fsutils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
+)
+
+// TestIndexRefresh tests that a refresh rescans only the given targets, and that a change
+// made outside the index, like a new download, falls back to a full scan.
+func TestIndexRefresh(t *testing.T) {
+	hfCache := setHfCache(t)
+	targetDir := t.TempDir()
+	cachetest.MakeRepo(t, hfCache, "models--org--alpha", "aaaa1111", map[string]string{"a.gguf": "a"})
+	cachetest.MakeRepo(t, hfCache, "models--org--beta", "bbbb2222", map[string]string{"b.gguf": "b"})
+
+	ix := NewIndex(targetDir)
+	if err := ix.Scan(); err != nil {
+		t.Fatalf("Scan returned error: %v", err)
+	}
+	models, _ := ix.Models()
+	if len(models) != 2 || models[0].IsLinked || models[1].IsLinked {
+		t.Fatalf("unexpected models after scan: %+v", models)
+	}
+	for _, m := range models {
+		if err := LinkModel(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ix.Refresh(models[0].TargetPath, models[1].TargetPath); err != nil {
+		t.Fatalf("Refresh returned error: %v", err)
+	}
+	models, _ = ix.Models()
+	if !models[0].IsLinked || !models[1].IsLinked {
+		t.Fatalf("expected both models linked after refresh, got %+v", models)
+	}
+	if ix.Changed() {
+		t.Errorf("expected no change after refreshing the linked models")
+	}
+
+	// Beta's link is broken without touching a watched directory: a refresh of alpha
+	// keeps beta's entry, a full scan sees it.
+	if err := os.Remove(filepath.Join(models[1].TargetPath, metadataFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Refresh(models[0].TargetPath); err != nil {
+		t.Fatal(err)
+	}
+	if models, _ = ix.Models(); !models[1].IsLinked {
+		t.Errorf("expected a refresh of alpha not to rescan beta")
+	}
+	if err := ix.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if models, _ = ix.Models(); models[1].IsLinked {
+		t.Errorf("expected a full scan to see beta unlinked")
+	}
+
+	// Removing alpha from the cache turns its link stale.
+	if err := os.RemoveAll(models[0].SourcePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Refresh(models[0].TargetPath); err != nil {
+		t.Fatal(err)
+	}
+	models, stale := ix.Models()
+	if len(models) != 1 || len(stale) != 1 || stale[0].RepoID() != "org/alpha" {
+		t.Errorf("expected alpha to be stale, got models %+v and stale %+v", models, stale)
+	}
+
+	// A new download is not one of the refreshed targets, so it triggers a full scan.
+	cachetest.MakeRepo(t, hfCache, "models--org--gamma", "cccc3333", map[string]string{"c.gguf": "c"})
+	if !ix.Changed() {
+		t.Errorf("expected the new repository to be noticed")
+	}
+	if err := ix.Refresh(models[0].TargetPath); err != nil {
+		t.Fatal(err)
+	}
+	if models, _ = ix.Models(); len(models) != 2 {
+		t.Errorf("expected the new repository after refresh, got %+v", models)
+	}
+}
+
+// TestIndexAnnotate tests that the annotation is applied to the returned copies only.
+func TestIndexAnnotate(t *testing.T) {
+	hfCache := setHfCache(t)
+	cachetest.MakeRepo(t, hfCache, "models--org--alpha", "aaaa1111", map[string]string{"a.gguf": "a"})
+	ix := NewIndex(t.TempDir())
+	if err := ix.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	ix.Annotate(func(models []ModelInfo) {
+		calls++
+		for i := range models {
+			models[i].IsExcluded, models[i].ExcludedBy = true, "org:org"
+		}
+	})
+	models, _ := ix.Models()
+	if calls != 1 || len(models) != 1 || !models[0].IsExcluded || models[0].ExcludedBy != "org:org" {
+		t.Errorf("expected the annotated model, got %d calls and %+v", calls, models)
+	}
+	ix.Annotate(nil)
+	if models, _ = ix.Models(); models[0].IsExcluded {
+		t.Errorf("expected the index itself to be left unannotated")
+	}
+}
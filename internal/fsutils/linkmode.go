@@ -0,0 +1,146 @@
+package fsutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// LinkMode is how the files of a snapshot are placed in the target directory.
+type LinkMode string
+
+const (
+	// LinkSymlink points a symlink at each blob. It is the default.
+	LinkSymlink LinkMode = "symlink"
+	// LinkHardlink hard-links each blob; the target must be on the same filesystem.
+	LinkHardlink LinkMode = "hardlink"
+	// LinkCopy copies each blob, for targets that cannot follow links into the cache.
+	LinkCopy LinkMode = "copy"
+)
+
+// ParseLinkMode validates a link mode name. The empty string is the default mode.
+func ParseLinkMode(s string) (LinkMode, error) {
+	switch mode := LinkMode(s); mode {
+	case "":
+		return LinkSymlink, nil
+	case LinkSymlink, LinkHardlink, LinkCopy:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown link mode %q (want symlink, hardlink or copy)", s)
+}
+
+// String returns the mode name, "symlink" for the empty default.
+func (m LinkMode) String() string {
+	if m == "" {
+		return string(LinkSymlink)
+	}
+	return string(m)
+}
+
+// progressInterval is how often a copy reports its progress.
+const progressInterval = 100 * time.Millisecond
+
+// LinkProgress reports the file being placed while a model is linked. Copies report their
+// bytes as they go; links are placed at once and report only the file.
+type LinkProgress struct {
+	// File is the slash-separated path within the model.
+	File       string
+	BytesDone  int64
+	BytesTotal int64
+}
+
+// placeFile puts the blob at realSource into dst, the file rel of the model, using the mode
+// of opts. A cancelled ctx stops a copy between chunks.
+func placeFile(ctx context.Context, realSource, dst, rel string, opts LinkOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var report func(done, total int64)
+	if opts.OnProgress != nil {
+		report = func(done, total int64) {
+			opts.OnProgress(LinkProgress{File: rel, BytesDone: done, BytesTotal: total})
+		}
+	}
+	mode := opts.Mode
+	if mode != LinkCopy && report != nil {
+		report(0, 0)
+	}
+	switch mode {
+	case LinkHardlink:
+		if err := os.Link(realSource, dst); err != nil {
+			if errors.Is(err, syscall.EXDEV) {
+				return fmt.Errorf("cannot hard-link %s across filesystems, use copy mode instead", realSource)
+			}
+			return fmt.Errorf("failed to create hard link from %s to %s: %v", realSource, dst, err)
+		}
+	case LinkCopy:
+		if err := copyFile(ctx, realSource, dst, report); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to copy %s to %s: %v", realSource, dst, err)
+		}
+	default:
+		if err := os.Symlink(realSource, dst); err != nil {
+			return fmt.Errorf("failed to create symlink from %s to %s: %v", realSource, dst, err)
+		}
+	}
+	return nil
+}
+
+// copyFile copies a regular file, removing the partial copy on failure. report, if set, is
+// called with the bytes copied at the start, every progressInterval and at the end.
+func copyFile(ctx context.Context, src, dst string, report func(done, total int64)) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	var done int64
+	last := time.Now()
+	r := &progressReader{r: &contextReader{ctx: ctx, r: in}, onBytes: func(n int64) {
+		done += n
+		if report != nil && time.Since(last) >= progressInterval {
+			last = time.Now()
+			report(done, info.Size())
+		}
+	}}
+	if report != nil {
+		report(0, info.Size())
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if report != nil {
+		report(done, info.Size())
+	}
+	return out.Close()
+}
+
+// contextReader fails reads once its context is cancelled, so that long copies and hashes
+// stop between chunks.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
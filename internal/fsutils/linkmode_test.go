@@ -0,0 +1,85 @@
+package fsutils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
+)
+
+// TestLinkModes tests that hard links and copies produce regular files with the blob
+// content and record the mode in the marker.
+func TestLinkModes(t *testing.T) {
+	hfCache := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "rev", map[string]string{"model.gguf": "weights", "sub/extra.gguf": "more"})
+	for _, mode := range []LinkMode{LinkHardlink, LinkCopy} {
+		m := ModelInfo{
+			CacheDirName:     "models--org--model",
+			OrganizationName: "org",
+			ModelName:        "model",
+			SourcePath:       repo,
+			TargetPath:       filepath.Join(t.TempDir(), "org", "model"),
+		}
+		if err := LinkModelWith(context.Background(), m, LinkOptions{Mode: mode}); err != nil {
+			t.Fatalf("%s: LinkModelWith returned error: %v", mode, err)
+		}
+		for name, content := range map[string]string{"model.gguf": "weights", "sub/extra.gguf": "more"} {
+			path := filepath.Join(m.TargetPath, name)
+			info, err := os.Lstat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				t.Fatalf("%s: expected %s to be a regular file (err %v)", mode, name, err)
+			}
+			if data, _ := ioutil.ReadFile(path); string(data) != content {
+				t.Errorf("%s: unexpected content %q for %s", mode, data, name)
+			}
+		}
+		if marker, _ := ReadMarker(m.TargetPath); marker.Mode != mode {
+			t.Errorf("expected marker mode %s, got %s", mode, marker.Mode)
+		}
+		// Verification checks non-symlinked files against the linked revision.
+		m.IsLinked = true
+		results := VerifyModels(context.Background(), []ModelInfo{m}, LoadVerifyCache(""), 1, nil)
+		if results[0].Checked != 2 || len(results[0].Issues) != 0 {
+			t.Errorf("%s: expected 2 clean files, got %+v", mode, results[0])
+		}
+	}
+
+	if _, err := ParseLinkMode("junction"); err == nil {
+		t.Errorf("expected error for an unknown link mode")
+	}
+}
+
+// TestLinkProgress tests that every placed file is reported, with the bytes of copies.
+func TestLinkProgress(t *testing.T) {
+	hfCache := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "rev", map[string]string{"model.gguf": "weights", "sub/extra.gguf": "more"})
+	for _, mode := range []LinkMode{LinkSymlink, LinkCopy} {
+		m := ModelInfo{
+			CacheDirName:     "models--org--model",
+			OrganizationName: "org",
+			ModelName:        "model",
+			SourcePath:       repo,
+			TargetPath:       filepath.Join(t.TempDir(), "org", "model"),
+		}
+		last := map[string]LinkProgress{}
+		opts := LinkOptions{Mode: mode, OnProgress: func(p LinkProgress) { last[p.File] = p }}
+		if err := LinkModelWith(context.Background(), m, opts); err != nil {
+			t.Fatalf("%s: LinkModelWith returned error: %v", mode, err)
+		}
+		if mode == LinkSymlink {
+			if _, ok := last["model.gguf"]; !ok || len(last) != 2 {
+				t.Errorf("%s: expected both top-level entries to be reported, got %v", mode, last)
+			}
+			continue
+		}
+		if p := last["sub/extra.gguf"]; p.BytesDone != 4 || p.BytesTotal != 4 {
+			t.Errorf("%s: expected the nested file to report 4 of 4 bytes, got %+v", mode, p)
+		}
+		if p := last["model.gguf"]; p.BytesDone != 7 || p.BytesTotal != 7 {
+			t.Errorf("%s: expected model.gguf to report 7 of 7 bytes, got %+v", mode, p)
+		}
+	}
+}
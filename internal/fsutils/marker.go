@@ -0,0 +1,80 @@
+package fsutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LinkMarker is the content of the metadata file written into every linked directory.
+type LinkMarker struct {
+	LinkedAt time.Time   `json:"linked_at"`
+	Revision string      `json:"revision,omitempty"`
+	Format   ModelFormat `json:"format,omitempty"`
+	Files    []string    `json:"files,omitempty"`
+	Mode     LinkMode    `json:"mode,omitempty"`
+}
+
+// ReadMarker reads the metadata file of a linked directory. Markers written by older
+// versions only contain an RFC3339 timestamp and are returned with just LinkedAt set.
+func ReadMarker(targetPath string) (LinkMarker, error) {
+	var marker LinkMarker
+	data, err := ioutil.ReadFile(filepath.Join(targetPath, metadataFile))
+	if err != nil {
+		return marker, err
+	}
+	if err := json.Unmarshal(data, &marker); err != nil {
+		marker = LinkMarker{}
+		if t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data))); err == nil {
+			marker.LinkedAt = t
+		}
+	}
+	return marker, nil
+}
+
+// HasMarker reports whether a directory carries the metadata file, i.e. was linked by
+// this tool.
+func HasMarker(targetPath string) bool {
+	_, err := os.Stat(filepath.Join(targetPath, metadataFile))
+	return err == nil
+}
+
+// CheckOutdated reports whether a linked directory holds an older revision than the one
+// its cache repository resolves to now, e.g. after the model was updated upstream. Links
+// whose marker does not record a revision are never outdated.
+func CheckOutdated(sourcePath, targetPath string) (bool, string) {
+	marker, err := ReadMarker(targetPath)
+	if err != nil || marker.Revision == "" {
+		return false, ""
+	}
+	revision, _, err := ResolveSnapshot(sourcePath)
+	if err != nil || revision == marker.Revision {
+		return false, ""
+	}
+	return true, fmt.Sprintf("linked revision %s, latest %s", ShortRevision(marker.Revision), ShortRevision(revision))
+}
+
+// ShortRevision abbreviates a commit hash for display. An empty revision, e.g. from a
+// marker written before revisions were recorded, is shown as unknown.
+func ShortRevision(revision string) string {
+	if revision == "" {
+		return "unknown"
+	}
+	if len(revision) > 12 {
+		return revision[:12]
+	}
+	return revision
+}
+
+// writeMarker writes the metadata file into a linked directory.
+func writeMarker(targetPath string, marker LinkMarker) error {
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(targetPath, metadataFile), data, 0644)
+}
@@ -0,0 +1,322 @@
+package fsutils
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PruneKind describes what a prune item removes.
+type PruneKind string
+
+const (
+	PruneRevision   PruneKind = "revision"
+	PruneOrphanBlob PruneKind = "orphan-blob"
+	PruneIncomplete PruneKind = "incomplete"
+)
+
+// DefaultIncompleteMinAge is how old an *.incomplete blob must be before prune treats it as
+// an abandoned download rather than one that is still in progress.
+const DefaultIncompleteMinAge = 24 * time.Hour
+
+// PruneItem is a single removable snapshot or blob.
+type PruneItem struct {
+	Kind         PruneKind
+	CacheDirName string
+	Path         string
+	// Blobs lists the blobs that only this revision references; they are removed with it.
+	Blobs []string
+	Size  int64
+}
+
+// PrunePlan lists everything prune would delete and the space it would reclaim.
+type PrunePlan struct {
+	Items            []PruneItem
+	ReclaimableBytes int64
+}
+
+// PruneOptions tunes what prune considers removable.
+type PruneOptions struct {
+	IncompleteMinAge time.Duration
+}
+
+// linkUsage records what existing links in the target directories depend on.
+type linkUsage struct {
+	// revisions maps a cache directory name to the revisions recorded in link markers.
+	revisions map[string]map[string]bool
+	// unknown holds cache directories linked by markers that predate revision tracking.
+	unknown map[string]bool
+	// blobs holds every file that a link in a target directory points at.
+	blobs map[string]bool
+}
+
+// scanLinkUsage walks the target directories and collects the revisions and blobs that
+// current links depend on.
+func scanLinkUsage(targetDirs []string) (linkUsage, error) {
+	usage := linkUsage{
+		revisions: map[string]map[string]bool{},
+		unknown:   map[string]bool{},
+		blobs:     map[string]bool{},
+	}
+	for _, targetDir := range targetDirs {
+		if _, err := os.Stat(targetDir); os.IsNotExist(err) {
+			continue
+		}
+		err := filepath.WalkDir(targetDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type()&os.ModeSymlink != 0 {
+				if dest, err := os.Readlink(path); err == nil {
+					if !filepath.IsAbs(dest) {
+						dest = filepath.Join(filepath.Dir(path), dest)
+					}
+					usage.blobs[filepath.Clean(dest)] = true
+				}
+				return nil
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			marker, err := ReadMarker(path)
+			if err != nil {
+				return nil
+			}
+			cacheDirName := CacheDirName(RepoTypeModel, filepath.Base(filepath.Dir(path)), filepath.Base(path))
+			if marker.Revision == "" {
+				usage.unknown[cacheDirName] = true
+				return nil
+			}
+			if usage.revisions[cacheDirName] == nil {
+				usage.revisions[cacheDirName] = map[string]bool{}
+			}
+			usage.revisions[cacheDirName][marker.Revision] = true
+			return nil
+		})
+		if err != nil {
+			return usage, err
+		}
+	}
+	return usage, nil
+}
+
+// refRevisions returns every revision a ref (refs/main, refs/pr/1, ...) points at.
+func refRevisions(repoPath string) map[string]bool {
+	revisions := map[string]bool{}
+	filepath.WalkDir(filepath.Join(repoPath, refsDir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if data, err := ioutil.ReadFile(path); err == nil {
+			revisions[strings.TrimSpace(string(data))] = true
+		}
+		return nil
+	})
+	return revisions
+}
+
+// snapshotBlobs returns the blobs referenced by the symlinks of a snapshot directory.
+func snapshotBlobs(snapPath string) []string {
+	var blobs []string
+	filepath.WalkDir(snapPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&os.ModeSymlink == 0 {
+			return nil
+		}
+		if dest, err := os.Readlink(path); err == nil {
+			if !filepath.IsAbs(dest) {
+				dest = filepath.Join(filepath.Dir(path), dest)
+			}
+			blobs = append(blobs, filepath.Clean(dest))
+		}
+		return nil
+	})
+	return blobs
+}
+
+// PlanPrune finds snapshots that no ref points at and no link in targetDirs was created
+// from, blobs that no remaining snapshot or link references, and abandoned *.incomplete
+// downloads. Nothing is deleted. A cancelled ctx stops the scan with ctx's error.
+func PlanPrune(ctx context.Context, hfCache string, targetDirs []string, opts PruneOptions) (PrunePlan, error) {
+	return PlanPruneCaches(ctx, []string{hfCache}, targetDirs, opts)
+}
+
+// PlanPruneCaches is PlanPrune for several caches, such as those of GetHfCacheDirs, with
+// the items of each cache in turn.
+func PlanPruneCaches(ctx context.Context, caches []string, targetDirs []string, opts PruneOptions) (PrunePlan, error) {
+	var plan PrunePlan
+	if opts.IncompleteMinAge == 0 {
+		opts.IncompleteMinAge = DefaultIncompleteMinAge
+	}
+	usage, err := scanLinkUsage(targetDirs)
+	if err != nil {
+		return plan, err
+	}
+	for _, hfCache := range caches {
+		// Links point at fully resolved blob paths, so compare against the resolved cache path.
+		if real, err := filepath.EvalSymlinks(hfCache); err == nil {
+			hfCache = real
+		}
+		entries, err := ioutil.ReadDir(hfCache)
+		if err != nil {
+			return PrunePlan{}, err
+		}
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				return PrunePlan{}, err
+			}
+			if !entry.IsDir() {
+				continue
+			}
+			if _, _, _, ok := ParseCacheDirName(entry.Name()); !ok {
+				continue
+			}
+			plan.Items = append(plan.Items, planRepoPrune(filepath.Join(hfCache, entry.Name()), entry.Name(), usage, opts)...)
+		}
+	}
+	for _, item := range plan.Items {
+		plan.ReclaimableBytes += item.Size
+	}
+	return plan, nil
+}
+
+// planRepoPrune plans the prune of a single cache repository.
+func planRepoPrune(repoPath, cacheDirName string, usage linkUsage, opts PruneOptions) []PruneItem {
+	var items []PruneItem
+	keep := refRevisions(repoPath)
+	for revision := range usage.revisions[cacheDirName] {
+		keep[revision] = true
+	}
+
+	// Blobs referenced by kept snapshots or by any link must survive.
+	referenced := map[string]bool{}
+	for blob := range usage.blobs {
+		referenced[blob] = true
+	}
+	snapshots, _ := ioutil.ReadDir(filepath.Join(repoPath, snapshotsDir))
+	removable := map[string][]string{}
+	var removableOrder []string
+	allSnapshotBlobs := map[string]bool{}
+	for _, snap := range snapshots {
+		if !snap.IsDir() {
+			continue
+		}
+		blobs := snapshotBlobs(filepath.Join(repoPath, snapshotsDir, snap.Name()))
+		for _, blob := range blobs {
+			allSnapshotBlobs[blob] = true
+		}
+		// Links made before revisions were recorded could come from any snapshot.
+		if keep[snap.Name()] || usage.unknown[cacheDirName] {
+			for _, blob := range blobs {
+				referenced[blob] = true
+			}
+			continue
+		}
+		removable[snap.Name()] = blobs
+		removableOrder = append(removableOrder, snap.Name())
+	}
+	sort.Strings(removableOrder)
+
+	claimed := map[string]bool{}
+	for _, revision := range removableOrder {
+		item := PruneItem{
+			Kind:         PruneRevision,
+			CacheDirName: cacheDirName,
+			Path:         filepath.Join(repoPath, snapshotsDir, revision),
+		}
+		for _, blob := range removable[revision] {
+			if referenced[blob] || claimed[blob] {
+				continue
+			}
+			info, err := os.Stat(blob)
+			if err != nil {
+				continue
+			}
+			claimed[blob] = true
+			item.Blobs = append(item.Blobs, blob)
+			item.Size += info.Size()
+		}
+		items = append(items, item)
+	}
+
+	blobsPath := filepath.Join(repoPath, blobsDir)
+	blobs, _ := ioutil.ReadDir(blobsPath)
+	for _, blob := range blobs {
+		if !blob.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(blobsPath, blob.Name())
+		if strings.HasSuffix(blob.Name(), incompleteSuffix) {
+			if time.Since(blob.ModTime()) >= opts.IncompleteMinAge {
+				items = append(items, PruneItem{Kind: PruneIncomplete, CacheDirName: cacheDirName, Path: path, Size: blob.Size()})
+			}
+			continue
+		}
+		if referenced[path] || allSnapshotBlobs[path] {
+			continue
+		}
+		items = append(items, PruneItem{Kind: PruneOrphanBlob, CacheDirName: cacheDirName, Path: path, Size: blob.Size()})
+	}
+	return items
+}
+
+// ExecutePrune deletes the items of a plan. Link usage is re-read first so that anything
+// linked after the plan was made is skipped rather than deleted. It returns the number of
+// bytes reclaimed and the first error encountered. A cancelled ctx stops before the next
+// item, returning what was reclaimed so far and ctx's error.
+func ExecutePrune(ctx context.Context, plan PrunePlan, targetDirs []string) (int64, error) {
+	usage, err := scanLinkUsage(targetDirs)
+	if err != nil {
+		return 0, err
+	}
+	var reclaimed int64
+	var firstErr error
+	for _, item := range plan.Items {
+		if err := ctx.Err(); err != nil {
+			return reclaimed, err
+		}
+		switch item.Kind {
+		case PruneRevision:
+			if usage.revisions[item.CacheDirName][filepath.Base(item.Path)] || usage.unknown[item.CacheDirName] {
+				continue
+			}
+			if err := os.RemoveAll(item.Path); err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to remove snapshot %s: %v", item.Path, err)
+				}
+				continue
+			}
+			for _, blob := range item.Blobs {
+				if usage.blobs[blob] {
+					continue
+				}
+				if info, err := os.Stat(blob); err == nil {
+					if err := os.Remove(blob); err != nil {
+						if firstErr == nil {
+							firstErr = fmt.Errorf("failed to remove blob %s: %v", blob, err)
+						}
+						continue
+					}
+					reclaimed += info.Size()
+				}
+			}
+		default:
+			if usage.blobs[item.Path] {
+				continue
+			}
+			if err := os.Remove(item.Path); err != nil && !os.IsNotExist(err) {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to remove %s: %v", item.Path, err)
+				}
+				continue
+			}
+			reclaimed += item.Size
+		}
+	}
+	return reclaimed, firstErr
+}
@@ -0,0 +1,149 @@
+package fsutils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
+)
+
+// pruneFixture builds a repository with an unreferenced old revision, a current revision,
+// an orphan blob and an abandoned partial download.
+func pruneFixture(t *testing.T) (hfCache, repo string) {
+	t.Helper()
+	hfCache = t.TempDir()
+	if real, err := filepath.EvalSymlinks(hfCache); err == nil {
+		hfCache = real
+	}
+	cachetest.MakeRepo(t, hfCache, "models--org--model", "old", map[string]string{"shared.gguf": "shared", "old.gguf": "old-only"})
+	repo = cachetest.MakeRepo(t, hfCache, "models--org--model", "new", map[string]string{"shared.gguf": "shared", "new.gguf": "new"})
+	if err := ioutil.WriteFile(filepath.Join(repo, "blobs", "orphan"), []byte("orphan"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	partial := filepath.Join(repo, "blobs", "abc.incomplete")
+	if err := ioutil.WriteFile(partial, []byte("part"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(partial, old, old); err != nil {
+		t.Fatal(err)
+	}
+	return hfCache, repo
+}
+
+// TestPlanPrune tests that unreferenced revisions, their exclusive blobs, orphan blobs and
+// abandoned downloads are planned while shared and current data is kept.
+func TestPlanPrune(t *testing.T) {
+	hfCache, repo := pruneFixture(t)
+
+	plan, err := PlanPrune(context.Background(), hfCache, nil, PruneOptions{})
+	if err != nil {
+		t.Fatalf("PlanPrune returned error: %v", err)
+	}
+	kinds := map[PruneKind]int{}
+	for _, item := range plan.Items {
+		kinds[item.Kind]++
+		if item.Kind == PruneRevision {
+			if filepath.Base(item.Path) != "old" {
+				t.Errorf("expected only the old revision to be pruned, got %s", item.Path)
+			}
+			if len(item.Blobs) != 1 {
+				t.Errorf("expected one exclusive blob for old revision, got %v", item.Blobs)
+			}
+		}
+	}
+	if kinds[PruneRevision] != 1 || kinds[PruneOrphanBlob] != 1 || kinds[PruneIncomplete] != 1 {
+		t.Errorf("unexpected plan: %+v", plan.Items)
+	}
+	expected := int64(len("old-only") + len("orphan") + len("part"))
+	if plan.ReclaimableBytes != expected {
+		t.Errorf("expected %d reclaimable bytes, got %d", expected, plan.ReclaimableBytes)
+	}
+
+	reclaimed, err := ExecutePrune(context.Background(), plan, nil)
+	if err != nil {
+		t.Fatalf("ExecutePrune returned error: %v", err)
+	}
+	if reclaimed != expected {
+		t.Errorf("expected %d reclaimed bytes, got %d", expected, reclaimed)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "snapshots", "old")); !os.IsNotExist(err) {
+		t.Errorf("expected old snapshot to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(repo, "snapshots", "new", "shared.gguf")); err != nil {
+		t.Errorf("expected shared blob of current revision to survive: %v", err)
+	}
+}
+
+// TestPlanPruneKeepsLinkedRevision tests that a revision used by a link is never pruned.
+func TestPlanPruneKeepsLinkedRevision(t *testing.T) {
+	hfCache, repo := pruneFixture(t)
+	targetDir := t.TempDir()
+
+	// The abandoned download would block linking; this test is only about revisions.
+	if err := os.Remove(filepath.Join(repo, "blobs", "abc.incomplete")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Link the old revision by pointing refs/main at it, then move main forward again.
+	if err := ioutil.WriteFile(filepath.Join(repo, "refs", "main"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := ModelInfo{SourcePath: repo, TargetPath: filepath.Join(targetDir, "org", "model")}
+	if err := LinkModel(m); err != nil {
+		t.Fatalf("LinkModel returned error: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repo, "refs", "main"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := PlanPrune(context.Background(), hfCache, []string{targetDir}, PruneOptions{})
+	if err != nil {
+		t.Fatalf("PlanPrune returned error: %v", err)
+	}
+	for _, item := range plan.Items {
+		if item.Kind == PruneRevision {
+			t.Errorf("expected linked revision to be kept, got %s", item.Path)
+		}
+	}
+	if _, err := ExecutePrune(context.Background(), plan, []string{targetDir}); err != nil {
+		t.Fatalf("ExecutePrune returned error: %v", err)
+	}
+	if !verifySymlinks(m.TargetPath) {
+		t.Errorf("expected link to remain intact")
+	}
+	if _, err := os.Stat(filepath.Join(m.TargetPath, "old.gguf")); err != nil {
+		t.Errorf("expected linked file to resolve after prune: %v", err)
+	}
+}
+
+// TestExecutePruneSkipsNewlyLinked tests that blobs linked after planning are not deleted.
+func TestExecutePruneSkipsNewlyLinked(t *testing.T) {
+	hfCache, repo := pruneFixture(t)
+	targetDir := t.TempDir()
+
+	plan, err := PlanPrune(context.Background(), hfCache, []string{targetDir}, PruneOptions{})
+	if err != nil {
+		t.Fatalf("PlanPrune returned error: %v", err)
+	}
+
+	// Something links the orphan blob between planning and execution.
+	orphan := filepath.Join(repo, "blobs", "orphan")
+	if err := os.MkdirAll(filepath.Join(targetDir, "org", "other"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(orphan, filepath.Join(targetDir, "org", "other", "orphan.gguf")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ExecutePrune(context.Background(), plan, []string{targetDir}); err != nil {
+		t.Fatalf("ExecutePrune returned error: %v", err)
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Errorf("expected newly linked blob to survive: %v", err)
+	}
+}
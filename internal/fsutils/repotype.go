@@ -0,0 +1,95 @@
+package fsutils
+
+import (
+	"errors"
+	"strings"
+)
+
+// RepoType is the kind of Hugging Face repository a cache directory belongs to.
+type RepoType string
+
+const (
+	RepoTypeModel   RepoType = "model"
+	RepoTypeDataset RepoType = "dataset"
+	RepoTypeSpace   RepoType = "space"
+
+	repoSeparator = "--"
+)
+
+// ErrNotModelRepo is returned when linking a dataset or space repository.
+var ErrNotModelRepo = errors.New("not a model repository")
+
+// ErrNoOrganization is returned when linking a model repository without an organization.
+var ErrNoOrganization = errors.New("repository has no organization")
+
+// repoTypePrefixes maps the cache directory prefix written by huggingface_hub to its repo type.
+var repoTypePrefixes = map[string]RepoType{
+	"models":   RepoTypeModel,
+	"datasets": RepoTypeDataset,
+	"spaces":   RepoTypeSpace,
+}
+
+// ParseCacheDirName splits a cache directory name such as "models--org--name" into its
+// repo type, organization and repository name. huggingface_hub builds these names as
+// "<type>s--" + repo_id with "/" replaced by "--". Organization names on the Hub cannot
+// contain "--", so everything after the organization belongs to the repository name, which
+// keeps names like "models--org--my--model" intact. Repositories without an organization
+// (e.g. "models--gpt2") return an empty organization. ok is false for unknown prefixes.
+func ParseCacheDirName(dirName string) (repoType RepoType, organization, name string, ok bool) {
+	prefix, rest, found := strings.Cut(dirName, repoSeparator)
+	if !found || rest == "" {
+		return "", "", "", false
+	}
+	repoType, ok = repoTypePrefixes[prefix]
+	if !ok {
+		return "", "", "", false
+	}
+	organization, name, found = strings.Cut(rest, repoSeparator)
+	if !found {
+		return repoType, "", rest, true
+	}
+	if organization == "" || name == "" {
+		return "", "", "", false
+	}
+	return repoType, organization, name, true
+}
+
+// CacheDirName returns the cache directory name for a repository.
+func CacheDirName(repoType RepoType, organization, name string) string {
+	if organization == "" {
+		return string(repoType) + "s" + repoSeparator + name
+	}
+	return string(repoType) + "s" + repoSeparator + organization + repoSeparator + name
+}
+
+// RepoID returns the Hub repository id ("org/name") of a model.
+func (m ModelInfo) RepoID() string {
+	if m.OrganizationName == "" {
+		return m.ModelName
+	}
+	return m.OrganizationName + "/" + m.ModelName
+}
+
+// IsModelRepo reports whether the entry is a model repository. Entries created without a
+// repo type are treated as models.
+func (m ModelInfo) IsModelRepo() bool {
+	return m.RepoType == "" || m.RepoType == RepoTypeModel
+}
+
+// IsLinkable reports whether the entry can be linked: a model repository with an
+// organization, since LM Studio expects a publisher/model layout.
+func (m ModelInfo) IsLinkable() bool {
+	return m.IsModelRepo() && m.OrganizationName != ""
+}
+
+// NotLinkableReason explains why an entry cannot be linked, e.g. "datasets are not
+// models"; it is empty for linkable entries.
+func (m ModelInfo) NotLinkableReason() string {
+	switch {
+	case !m.IsModelRepo():
+		return string(m.RepoType) + "s are not models"
+	case m.OrganizationName == "":
+		return "no organization for LM Studio's publisher/model layout"
+	}
+	return ""
+}
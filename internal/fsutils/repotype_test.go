@@ -0,0 +1,99 @@
+package fsutils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
+)
+
+// TestParseCacheDirName covers repo type prefixes, org-less repositories and names containing "--".
+func TestParseCacheDirName(t *testing.T) {
+	cases := []struct {
+		dirName  string
+		repoType RepoType
+		org      string
+		name     string
+		ok       bool
+	}{
+		{"models--org--model", RepoTypeModel, "org", "model", true},
+		{"datasets--org--data", RepoTypeDataset, "org", "data", true},
+		{"spaces--org--demo", RepoTypeSpace, "org", "demo", true},
+		{"models--org--my--model", RepoTypeModel, "org", "my--model", true},
+		{"models--gpt2", RepoTypeModel, "", "gpt2", true},
+		{"version.txt", "", "", "", false},
+		{"models--", "", "", "", false},
+		{"widgets--org--thing", "", "", "", false},
+		{"models----model", "", "", "", false},
+	}
+	for _, tc := range cases {
+		repoType, org, name, ok := ParseCacheDirName(tc.dirName)
+		if ok != tc.ok || repoType != tc.repoType || org != tc.org || name != tc.name {
+			t.Errorf("ParseCacheDirName(%q) = (%q, %q, %q, %v), expected (%q, %q, %q, %v)",
+				tc.dirName, repoType, org, name, ok, tc.repoType, tc.org, tc.name, tc.ok)
+		}
+		if ok && CacheDirName(repoType, org, name) != tc.dirName {
+			t.Errorf("CacheDirName did not round-trip %q", tc.dirName)
+		}
+	}
+}
+
+// TestLoadModelsRepoTypes tests that datasets, spaces and models without an organization
+// are listed but never linkable.
+func TestLoadModelsRepoTypes(t *testing.T) {
+	hfCache := setHfCache(t)
+	targetDir := t.TempDir()
+	for _, name := range []string{"models--org--name", "datasets--org--name", "spaces--org--demo", "models--org--a--b", "models--gpt2"} {
+		cachetest.MakeRepo(t, hfCache, name, "rev", map[string]string{"a.gguf": "a"})
+	}
+
+	models, err := LoadModels(targetDir)
+	if err != nil {
+		t.Fatalf("LoadModels returned error: %v", err)
+	}
+	byDir := map[string]ModelInfo{}
+	for _, m := range models {
+		byDir[m.CacheDirName] = m
+	}
+	if len(byDir) != 5 {
+		t.Fatalf("expected 5 entries, got %d", len(byDir))
+	}
+	if m := byDir["models--org--a--b"]; m.OrganizationName != "org" || m.ModelName != "a--b" || m.RepoID() != "org/a--b" {
+		t.Errorf("unexpected parse of models--org--a--b: %+v", m)
+	}
+
+	dataset := byDir["datasets--org--name"]
+	if dataset.RepoType != RepoTypeDataset || dataset.IsModelRepo() {
+		t.Errorf("expected dataset repo type, got %q", dataset.RepoType)
+	}
+	if dataset.TargetPath != "" {
+		t.Errorf("expected dataset to have no target path, got %q", dataset.TargetPath)
+	}
+	if err := LinkModel(dataset); !errors.Is(err, ErrNotModelRepo) {
+		t.Errorf("expected ErrNotModelRepo linking a dataset, got %v", err)
+	}
+
+	gpt2, ok := byDir["models--gpt2"]
+	if !ok || gpt2.RepoID() != "gpt2" || gpt2.Format != FormatGGUF {
+		t.Errorf("expected models--gpt2 to be listed as a GGUF model, got %+v", gpt2)
+	}
+	if gpt2.IsLinkable() || gpt2.TargetPath != "" || gpt2.Status() != "unlinkable" || gpt2.NotLinkableReason() == "" {
+		t.Errorf("expected models--gpt2 to be unlinkable with a reason, got %+v", gpt2)
+	}
+	if err := LinkModel(gpt2); !errors.Is(err, ErrNoOrganization) {
+		t.Errorf("expected ErrNoOrganization linking models--gpt2, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "gpt2")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be linked for models--gpt2")
+	}
+
+	model := byDir["models--org--name"]
+	if err := LinkModel(model); err != nil {
+		t.Fatalf("LinkModel returned error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "org", "name", "a.gguf")); err != nil {
+		t.Errorf("expected model to be linked: %v", err)
+	}
+}
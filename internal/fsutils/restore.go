@@ -0,0 +1,102 @@
+package fsutils
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// TargetState records what a target directory held so that it can be put back after a
+// link or unlink. A nil *TargetState means the directory did not exist.
+type TargetState struct {
+	// Marker is the metadata file, nil for directories this tool did not create.
+	Marker *LinkMarker `json:"marker,omitempty"`
+	// Symlinks maps slash-separated paths relative to the target to their destinations.
+	Symlinks map[string]string `json:"symlinks,omitempty"`
+	// Dirs lists the subdirectories, needed to recreate empty ones.
+	Dirs []string `json:"dirs,omitempty"`
+	// OnlyLinks is true when the directory held nothing but symlinks, directories and the
+	// metadata file, so Symlinks and Dirs recreate it exactly.
+	OnlyLinks bool `json:"only_links"`
+}
+
+// CaptureTarget records the current contents of a target directory.
+func CaptureTarget(targetPath string) (*TargetState, error) {
+	if _, err := os.Lstat(targetPath); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	state := &TargetState{Symlinks: map[string]string{}, OnlyLinks: true}
+	if marker, err := ReadMarker(targetPath); err == nil {
+		state.Marker = &marker
+	}
+	err := filepath.WalkDir(targetPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(targetPath, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case d.Type()&os.ModeSymlink != 0:
+			dest, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			state.Symlinks[rel] = dest
+		case d.IsDir():
+			state.Dirs = append(state.Dirs, rel)
+		case rel != metadataFile:
+			state.OnlyLinks = false
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record %s: %v", targetPath, err)
+	}
+	return state, nil
+}
+
+// RestoreTarget puts a target directory back into a recorded state. Directories of
+// symlinks are recreated exactly, even if their sources have gone; hard-linked and copied
+// models are linked again from sourcePath with the options of their metadata file.
+func RestoreTarget(targetPath, sourcePath string, state *TargetState) error {
+	if state != nil && !state.OnlyLinks {
+		if state.Marker == nil {
+			return fmt.Errorf("cannot restore %s: it held files that were not created by hf-lms-sync", targetPath)
+		}
+		m := ModelInfo{RepoType: RepoTypeModel, ModelName: filepath.Base(targetPath), SourcePath: sourcePath, TargetPath: targetPath}
+		return LinkModelWith(context.Background(), m, LinkOptions{Revision: state.Marker.Revision, Files: state.Marker.Files, Mode: state.Marker.Mode})
+	}
+	if state == nil {
+		if err := os.RemoveAll(targetPath); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", targetPath, err)
+		}
+		return nil
+	}
+	return buildStaged(targetPath, func(staging string) error {
+		for _, dir := range state.Dirs {
+			if err := os.MkdirAll(filepath.Join(staging, filepath.FromSlash(dir)), 0755); err != nil {
+				return err
+			}
+		}
+		for rel, dest := range state.Symlinks {
+			path := filepath.Join(staging, filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(dest, path); err != nil {
+				return fmt.Errorf("failed to restore link %s: %v", filepath.Join(targetPath, rel), err)
+			}
+		}
+		if state.Marker != nil {
+			return writeMarker(staging, *state.Marker)
+		}
+		return nil
+	})
+}
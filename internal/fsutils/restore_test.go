@@ -0,0 +1,63 @@
+package fsutils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCaptureRestoreTarget tests that a directory of symlinks, including broken ones of a
+// stale link, is recreated exactly and that directories with real files are refused.
+func TestCaptureRestoreTarget(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "org", "model")
+	if err := os.MkdirAll(filepath.Join(target, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Symlink("/gone/model.gguf", filepath.Join(target, "model.gguf"))
+	os.Symlink("../../../gone/config.json", filepath.Join(target, "sub", "config.json"))
+	if err := writeMarker(target, LinkMarker{Revision: "abc", Format: FormatGGUF}); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := CaptureTarget(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.OnlyLinks || state.Marker == nil || len(state.Symlinks) != 2 {
+		t.Fatalf("unexpected captured state %+v", state)
+	}
+	if err := os.RemoveAll(target); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreTarget(target, "", state); err != nil {
+		t.Fatalf("RestoreTarget returned error: %v", err)
+	}
+	if dest, err := os.Readlink(filepath.Join(target, "sub", "config.json")); err != nil || dest != "../../../gone/config.json" {
+		t.Errorf("expected restored symlink, got %q (err %v)", dest, err)
+	}
+	if marker, err := ReadMarker(target); err != nil || marker.Revision != "abc" {
+		t.Errorf("expected restored marker, got %+v (err %v)", marker, err)
+	}
+
+	// Restoring "nothing" removes the directory.
+	if err := RestoreTarget(target, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := CaptureTarget(target); err != nil || state != nil {
+		t.Errorf("expected missing target to capture as nil, got %+v (err %v)", state, err)
+	}
+
+	// A directory of real files this tool did not create cannot be brought back.
+	own := filepath.Join(root, "own")
+	os.MkdirAll(own, 0755)
+	ioutil.WriteFile(filepath.Join(own, "model.gguf"), []byte("data"), 0644)
+	state, err = CaptureTarget(own)
+	if err != nil || state.OnlyLinks {
+		t.Fatalf("expected a directory with files, got %+v (err %v)", state, err)
+	}
+	if err := RestoreTarget(own, "", state); err == nil {
+		t.Errorf("expected error restoring unmanaged files")
+	}
+}
@@ -0,0 +1,212 @@
+package fsutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const sizeCacheFile = "sizes.json"
+
+// sizeEntry caches the blob size of one repository keyed by the blobs directory mtime.
+type sizeEntry struct {
+	BlobsModTime time.Time `json:"blobs_mtime"`
+	Size         int64     `json:"size"`
+}
+
+// SizeCache remembers repository sizes between runs. A repository is only re-measured when
+// the modification time of its blobs directory changes, which happens whenever
+// huggingface_hub adds, renames or removes a blob.
+type SizeCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]sizeEntry
+	dirty   bool
+}
+
+// SizeTotals aggregates disk usage across a set of models. Other counts the repositories
+// that cannot be linked: datasets, spaces and models without an organization.
+type SizeTotals struct {
+	LinkedCount     int   `json:"linked_count"`
+	LinkedBytes     int64 `json:"linked_bytes"`
+	UnlinkedCount   int   `json:"unlinked_count"`
+	UnlinkedBytes   int64 `json:"unlinked_bytes"`
+	StaleCount      int   `json:"stale_count"`
+	StaleBytes      int64 `json:"stale_bytes"`
+	OtherCount      int   `json:"other_count"`
+	OtherBytes      int64 `json:"other_bytes"`
+	LinkedFileBytes int64 `json:"linked_file_bytes"`
+	TotalBytes      int64 `json:"total_bytes"`
+}
+
+// DefaultCacheDir returns the directory where hf-lms-sync keeps its own cached state.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "hf-lms-sync"), nil
+}
+
+// LoadSizeCache reads the size cache from path. A missing or unreadable cache file yields
+// an empty cache; an empty path keeps the cache in memory only.
+func LoadSizeCache(path string) *SizeCache {
+	c := &SizeCache{path: path, entries: map[string]sizeEntry{}}
+	if path == "" {
+		return c
+	}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(data, &c.entries)
+	}
+	return c
+}
+
+// LoadDefaultSizeCache reads the size cache from the default cache directory.
+func LoadDefaultSizeCache() *SizeCache {
+	dir, err := DefaultCacheDir()
+	if err != nil {
+		return LoadSizeCache("")
+	}
+	return LoadSizeCache(filepath.Join(dir, sizeCacheFile))
+}
+
+// Save writes the cache back to disk if it changed.
+func (c *SizeCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path == "" || !c.dirty {
+		return nil
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(c.path, data, 0644); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// RepoSize returns the on-disk size of a cache repository's blobs. Blobs are content
+// addressed, so files shared between revisions are only counted once.
+func (c *SizeCache) RepoSize(sourcePath string) (int64, error) {
+	blobsPath := filepath.Join(sourcePath, blobsDir)
+	info, err := os.Stat(blobsPath)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[sourcePath]
+	c.mu.Unlock()
+	if ok && entry.BlobsModTime.Equal(info.ModTime()) {
+		return entry.Size, nil
+	}
+
+	blobs, err := ioutil.ReadDir(blobsPath)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, blob := range blobs {
+		if blob.Mode().IsRegular() {
+			size += blob.Size()
+		}
+	}
+
+	c.mu.Lock()
+	c.entries[sourcePath] = sizeEntry{BlobsModTime: info.ModTime(), Size: size}
+	c.dirty = true
+	c.mu.Unlock()
+	return size, nil
+}
+
+// LinkedSize returns the size of the files a linked directory exposes, resolving symlinks
+// and counting each underlying file once.
+func LinkedSize(targetPath string) int64 {
+	seen := map[string]bool{}
+	var size int64
+	filepath.WalkDir(targetPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == metadataFile {
+			return nil
+		}
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil || seen[real] {
+			return nil
+		}
+		seen[real] = true
+		if info, err := os.Stat(real); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// ComputeSizes fills in Size and LinkedSize for each model. Stale links have no source, so
+// their Size is whatever the target directory itself still holds.
+func ComputeSizes(models []ModelInfo, cache *SizeCache) {
+	for i := range models {
+		m := &models[i]
+		if m.IsStale {
+			m.Size = LinkedSize(m.TargetPath)
+			continue
+		}
+		if size, err := cache.RepoSize(m.SourcePath); err == nil {
+			m.Size = size
+		}
+		if m.IsLinked {
+			m.LinkedSize = LinkedSize(m.TargetPath)
+		}
+	}
+}
+
+// SummarizeSizes totals the sizes of linked, unlinked and stale models, and of the
+// repositories that cannot be linked. All but stale links count towards the cache total.
+func SummarizeSizes(models []ModelInfo) SizeTotals {
+	var t SizeTotals
+	for _, m := range models {
+		switch {
+		case m.IsStale:
+			t.StaleCount++
+			t.StaleBytes += m.Size
+			continue
+		case m.IsLinked:
+			t.LinkedCount++
+			t.LinkedBytes += m.Size
+			t.LinkedFileBytes += m.LinkedSize
+		case !m.IsLinkable():
+			t.OtherCount++
+			t.OtherBytes += m.Size
+		default:
+			t.UnlinkedCount++
+			t.UnlinkedBytes += m.Size
+		}
+		t.TotalBytes += m.Size
+	}
+	return t
+}
+
+// FormatSize renders a byte count using binary units, e.g. "4.2 GiB", the magnitudes du -h
+// prints.
+func FormatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,114 @@
+package fsutils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
+)
+
+// TestRepoSizeDeduplicatesAndCaches tests that blobs shared by revisions are counted once
+// and that cached sizes are reused until the blobs directory changes.
+func TestRepoSizeDeduplicatesAndCaches(t *testing.T) {
+	hfCache := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "rev1", map[string]string{"a.gguf": "aaaa", "b.gguf": "bb"})
+	// A second revision sharing a.gguf adds no new blob for it.
+	cachetest.MakeRepo(t, hfCache, "models--org--model", "rev2", map[string]string{"a.gguf": "aaaa", "c.gguf": "c"})
+
+	cachePath := filepath.Join(t.TempDir(), "sizes.json")
+	cache := LoadSizeCache(cachePath)
+	size, err := cache.RepoSize(repo)
+	if err != nil {
+		t.Fatalf("RepoSize returned error: %v", err)
+	}
+	if size != 7 {
+		t.Errorf("expected deduplicated size 7, got %d", size)
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save returned error: %v", err)
+	}
+
+	// A reloaded cache answers from disk without rescanning while the mtime is unchanged.
+	reloaded := LoadSizeCache(cachePath)
+	reloaded.entries[repo] = sizeEntry{BlobsModTime: reloaded.entries[repo].BlobsModTime, Size: 42}
+	if size, _ := reloaded.RepoSize(repo); size != 42 {
+		t.Errorf("expected cached size 42, got %d", size)
+	}
+
+	// Touching the blobs directory invalidates the entry.
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(repo, "blobs"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := reloaded.RepoSize(repo); size != 7 {
+		t.Errorf("expected recomputed size 7, got %d", size)
+	}
+}
+
+// TestComputeSizesAndSummarize tests per-model sizes, the linked subset and totals.
+func TestComputeSizesAndSummarize(t *testing.T) {
+	hfCache := setHfCache(t)
+	targetDir := t.TempDir()
+	cachetest.MakeRepo(t, hfCache, "models--org--linked", "old", map[string]string{"old.gguf": "0123456789"})
+	cachetest.MakeRepo(t, hfCache, "models--org--linked", "new", map[string]string{"new.gguf": "01234"})
+	cachetest.MakeRepo(t, hfCache, "models--org--unlinked", "rev", map[string]string{"x.gguf": "xyz"})
+	cachetest.MakeRepo(t, hfCache, "datasets--org--data", "rev", map[string]string{"data.parquet": "data"})
+	cachetest.MakeRepo(t, hfCache, "models--gpt2", "rev", map[string]string{"gpt2.gguf": "gpt"})
+
+	models, err := LoadModels(targetDir)
+	if err != nil {
+		t.Fatalf("LoadModels returned error: %v", err)
+	}
+	for _, m := range models {
+		if m.ModelName == "linked" {
+			if err := LinkModel(m); err != nil {
+				t.Fatalf("LinkModel returned error: %v", err)
+			}
+		}
+	}
+	models, err = LoadModels(targetDir)
+	if err != nil {
+		t.Fatalf("LoadModels returned error: %v", err)
+	}
+
+	ComputeSizes(models, LoadSizeCache(""))
+	for _, m := range models {
+		switch m.ModelName {
+		case "linked":
+			if m.Size != 15 || m.LinkedSize != 5 {
+				t.Errorf("expected linked model size 15 / linked 5, got %d / %d", m.Size, m.LinkedSize)
+			}
+		case "unlinked":
+			if m.Size != 3 || m.LinkedSize != 0 {
+				t.Errorf("expected unlinked model size 3 / linked 0, got %d / %d", m.Size, m.LinkedSize)
+			}
+		}
+	}
+
+	totals := SummarizeSizes(models)
+	// The dataset and the model without an organization cannot be linked, so they are
+	// not waiting to be linked either.
+	if totals.LinkedCount != 1 || totals.UnlinkedCount != 1 || totals.UnlinkedBytes != 3 || totals.OtherCount != 2 || totals.OtherBytes != 7 ||
+		totals.TotalBytes != 25 || totals.LinkedFileBytes != 5 {
+		t.Errorf("unexpected totals: %+v", totals)
+	}
+}
+
+// TestFormatSize checks binary unit formatting.
+func TestFormatSize(t *testing.T) {
+	cases := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 30:         "3.0 GiB",
+		3e9:             "2.8 GiB",
+	}
+	for bytes, expected := range cases {
+		if got := FormatSize(bytes); got != expected {
+			t.Errorf("FormatSize(%d) = %q, expected %q", bytes, got, expected)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package fsutils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Links are built in a hidden directory next to the target and renamed into place, so a
+// model is either fully linked or left as it was. A target being replaced is first moved
+// aside and removed once the new one is in place.
+const (
+	scratchPrefix  = ".hf-lms-sync-"
+	stagingPrefix  = scratchPrefix + "staging-"
+	replacedPrefix = scratchPrefix + "replaced-"
+)
+
+// isScratchDir reports whether a directory name is a staging or replaced directory.
+func isScratchDir(name string) bool {
+	return strings.HasPrefix(name, stagingPrefix) || strings.HasPrefix(name, replacedPrefix)
+}
+
+// stageDir creates a staging directory next to targetPath.
+func stageDir(targetPath string) (string, error) {
+	parent := filepath.Dir(targetPath)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	staging, err := ioutil.TempDir(parent, stagingPrefix+filepath.Base(targetPath)+"-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	// TempDir creates 0700 directories; links are read by LM Studio and other users.
+	os.Chmod(staging, 0755)
+	return staging, nil
+}
+
+// commitStaged renames a staging directory to targetPath, replacing what is there.
+func commitStaged(staging, targetPath string) error {
+	replaced := ""
+	if _, err := os.Lstat(targetPath); err == nil {
+		replaced = filepath.Join(filepath.Dir(targetPath), replacedPrefix+filepath.Base(targetPath)+"-"+strconv.FormatInt(time.Now().UnixNano(), 10))
+		if err := os.Rename(targetPath, replaced); err != nil {
+			return fmt.Errorf("failed to move existing target directory aside: %v", err)
+		}
+	}
+	if err := os.Rename(staging, targetPath); err != nil {
+		if replaced != "" {
+			os.Rename(replaced, targetPath)
+		}
+		return fmt.Errorf("failed to move staged links into place: %v", err)
+	}
+	if replaced != "" {
+		if err := os.RemoveAll(replaced); err != nil {
+			return fmt.Errorf("failed to remove replaced target directory: %v", err)
+		}
+	}
+	return nil
+}
+
+// buildStaged fills a staging directory with build and renames it to targetPath. The
+// staging directory is removed if anything fails, leaving targetPath untouched.
+func buildStaged(targetPath string, build func(staging string) error) error {
+	staging, err := stageDir(targetPath)
+	if err != nil {
+		return err
+	}
+	if err := build(staging); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+	if err := commitStaged(staging, targetPath); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+	return nil
+}
+
+// CleanupStaging removes staging directories left in a target directory by a crash and
+// puts back targets that were moved aside but never replaced. It returns the paths it
+// removed or restored. It must not run while another process links into targetDir.
+func CleanupStaging(targetDir string) ([]string, error) {
+	orgs, err := ioutil.ReadDir(targetDir)
+	if err != nil {
+		return nil, err
+	}
+	var cleaned []string
+	for _, org := range orgs {
+		if !org.IsDir() {
+			continue
+		}
+		orgPath := filepath.Join(targetDir, org.Name())
+		entries, err := ioutil.ReadDir(orgPath)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			path := filepath.Join(orgPath, name)
+			switch {
+			case strings.HasPrefix(name, stagingPrefix):
+				if err := os.RemoveAll(path); err != nil {
+					return cleaned, err
+				}
+				cleaned = append(cleaned, path)
+			case strings.HasPrefix(name, replacedPrefix):
+				// The original name is followed by a dash and a timestamp.
+				original := strings.TrimPrefix(name, replacedPrefix)
+				if i := strings.LastIndex(original, "-"); i > 0 {
+					original = original[:i]
+				}
+				originalPath := filepath.Join(orgPath, original)
+				if _, err := os.Lstat(originalPath); os.IsNotExist(err) {
+					if err := os.Rename(path, originalPath); err != nil {
+						return cleaned, err
+					}
+				} else if err := os.RemoveAll(path); err != nil {
+					return cleaned, err
+				}
+				cleaned = append(cleaned, path)
+			}
+		}
+	}
+	return cleaned, nil
+}
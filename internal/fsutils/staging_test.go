@@ -0,0 +1,158 @@
+package fsutils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
+)
+
+// scratchEntries returns the staging and replaced directories next to a target.
+func scratchEntries(t *testing.T, targetPath string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Dir(targetPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scratch []string
+	for _, e := range entries {
+		if isScratchDir(e.Name()) {
+			scratch = append(scratch, e.Name())
+		}
+	}
+	return scratch
+}
+
+// TestLinkFailureLeavesTargetUntouched tests that a link failing halfway neither breaks
+// the existing link nor leaves partial links behind.
+func TestLinkFailureLeavesTargetUntouched(t *testing.T) {
+	hfCache := t.TempDir()
+	targetDir := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "aaaa1111", map[string]string{"a.gguf": "a", "b.gguf": "b"})
+	m := ModelInfo{
+		CacheDirName:     "models--org--model",
+		OrganizationName: "org",
+		ModelName:        "model",
+		SourcePath:       repo,
+		TargetPath:       filepath.Join(targetDir, "org", "model"),
+	}
+	if err := LinkModel(m); err != nil {
+		t.Fatalf("LinkModel returned error: %v", err)
+	}
+	before, err := ReadMarker(m.TargetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A dangling symlink in the snapshot makes the relink fail after a.gguf was linked.
+	if err := os.Symlink("../../blobs/missing", filepath.Join(repo, "snapshots", "aaaa1111", "c.gguf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := LinkModelWith(context.Background(), m, LinkOptions{Mode: LinkCopy}); err == nil {
+		t.Fatalf("expected relink to fail")
+	}
+	after, err := ReadMarker(m.TargetPath)
+	if err != nil || !after.LinkedAt.Equal(before.LinkedAt) || after.Mode.String() != "symlink" {
+		t.Errorf("expected the previous link to be kept, got %+v (err %v)", after, err)
+	}
+	if !verifySymlinks(m.TargetPath) {
+		t.Errorf("expected the previous symlinks to be intact")
+	}
+	if scratch := scratchEntries(t, m.TargetPath); len(scratch) > 0 {
+		t.Errorf("expected staging to be cleaned up, found %v", scratch)
+	}
+
+	// A model that was never linked stays unlinked.
+	fresh := m
+	fresh.ModelName = "fresh"
+	fresh.TargetPath = filepath.Join(targetDir, "org", "fresh")
+	if err := LinkModel(fresh); err == nil {
+		t.Fatalf("expected link to fail")
+	}
+	if _, err := os.Stat(fresh.TargetPath); !os.IsNotExist(err) {
+		t.Errorf("expected no target directory after a failed link")
+	}
+}
+
+// TestCleanupStaging tests that leftovers of an interrupted link are removed and that a
+// target moved aside but never replaced is put back.
+func TestCleanupStaging(t *testing.T) {
+	targetDir := t.TempDir()
+	orgPath := filepath.Join(targetDir, "org")
+	for _, dir := range []string{
+		stagingPrefix + "alpha-123",
+		replacedPrefix + "beta-model-1700000000",
+		replacedPrefix + "gamma-1700000000",
+		"gamma",
+	} {
+		if err := os.MkdirAll(filepath.Join(orgPath, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeMarker(filepath.Join(orgPath, replacedPrefix+"beta-model-1700000000"), LinkMarker{Revision: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := FindStaleLinks(targetDir)
+	if err != nil || len(stale) != 0 {
+		t.Errorf("expected scratch directories not to be reported as stale, got %v (err %v)", stale, err)
+	}
+
+	cleaned, err := CleanupStaging(targetDir)
+	if err != nil {
+		t.Fatalf("CleanupStaging returned error: %v", err)
+	}
+	if len(cleaned) != 3 {
+		t.Errorf("expected 3 leftovers to be handled, got %v", cleaned)
+	}
+	entries, _ := os.ReadDir(orgPath)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, ",") != "beta-model,gamma" {
+		t.Errorf("unexpected entries after cleanup: %v", names)
+	}
+	if marker, err := ReadMarker(filepath.Join(orgPath, "beta-model")); err != nil || marker.Revision != "abc" {
+		t.Errorf("expected the moved-aside link to be restored, got %+v (err %v)", marker, err)
+	}
+}
+
+// TestLinkCancelled tests that a cancelled link reports the cancellation and leaves the
+// target as it was.
+func TestLinkCancelled(t *testing.T) {
+	hfCache := t.TempDir()
+	targetDir := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "aaaa1111", map[string]string{"a.gguf": "a", "b.gguf": "b"})
+	m := ModelInfo{
+		CacheDirName:     "models--org--model",
+		OrganizationName: "org",
+		ModelName:        "model",
+		SourcePath:       repo,
+		TargetPath:       filepath.Join(targetDir, "org", "model"),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := LinkModelWith(ctx, m, LinkOptions{Mode: LinkCopy}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Stat(m.TargetPath); !os.IsNotExist(err) {
+		t.Errorf("expected no target directory after a cancelled link")
+	}
+
+	if err := LinkModel(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := LinkModelWith(ctx, m, LinkOptions{Mode: LinkCopy}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if marker, err := ReadMarker(m.TargetPath); err != nil || marker.Mode.String() != "symlink" {
+		t.Errorf("expected the previous link to be kept, got %+v (err %v)", marker, err)
+	}
+	if scratch := scratchEntries(t, m.TargetPath); len(scratch) > 0 {
+		t.Errorf("expected staging to be cleaned up, found %v", scratch)
+	}
+}
@@ -0,0 +1,392 @@
+package fsutils
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const verifyCacheFile = "verify.json"
+
+// VerifyIssueKind classifies a verification failure.
+type VerifyIssueKind string
+
+const (
+	VerifyMissing    VerifyIssueKind = "missing"
+	VerifyMismatch   VerifyIssueKind = "mismatch"
+	VerifyUnreadable VerifyIssueKind = "unreadable"
+	// VerifyTruncated is a mismatching file that is empty, or a copy shorter than its blob.
+	// The cache records no expected size, so a blob cut short is only a mismatch.
+	VerifyTruncated VerifyIssueKind = "truncated"
+)
+
+// VerifyIssue is a linked file whose content does not match its blob name.
+type VerifyIssue struct {
+	File   string
+	Blob   string
+	Kind   VerifyIssueKind
+	Detail string
+}
+
+// VerifyResult is the outcome of verifying one model.
+type VerifyResult struct {
+	Model   ModelInfo
+	Checked int
+	Cached  int
+	Issues  []VerifyIssue
+}
+
+// VerifyProgress reports how far a verification run has got.
+type VerifyProgress struct {
+	FilesDone  int
+	FilesTotal int
+	BytesDone  int64
+	BytesTotal int64
+	File       string
+}
+
+// verifyEntry caches the verdict for a file keyed by its size and mtime.
+type verifyEntry struct {
+	Size    int64           `json:"size"`
+	ModTime time.Time       `json:"mtime"`
+	Kind    VerifyIssueKind `json:"kind,omitempty"`
+	Detail  string          `json:"detail,omitempty"`
+}
+
+// VerifyCache remembers verification verdicts so that unchanged files are not re-hashed.
+type VerifyCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]verifyEntry
+	dirty   bool
+}
+
+// LoadVerifyCache reads the verification cache from path. An empty path keeps the cache
+// in memory only.
+func LoadVerifyCache(path string) *VerifyCache {
+	c := &VerifyCache{path: path, entries: map[string]verifyEntry{}}
+	if path == "" {
+		return c
+	}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(data, &c.entries)
+	}
+	return c
+}
+
+// LoadDefaultVerifyCache reads the verification cache from the default cache directory.
+func LoadDefaultVerifyCache() *VerifyCache {
+	dir, err := DefaultCacheDir()
+	if err != nil {
+		return LoadVerifyCache("")
+	}
+	return LoadVerifyCache(filepath.Join(dir, verifyCacheFile))
+}
+
+// Save writes the cache back to disk if it changed.
+func (c *VerifyCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path == "" || !c.dirty {
+		return nil
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(c.path, data, 0644); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// lookup returns the cached verdict for a file if its size and mtime are unchanged.
+func (c *VerifyCache) lookup(path string, info os.FileInfo) (verifyEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[path]
+	if !ok || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		return verifyEntry{}, false
+	}
+	return entry, true
+}
+
+// store records the verdict for a file.
+func (c *VerifyCache) store(path string, entry verifyEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[path] = entry
+	c.dirty = true
+}
+
+// verifyTask is one linked file to check against the blob it came from.
+type verifyTask struct {
+	model int
+	file  string
+	// content is the file that is hashed; blob is the cache blob whose name is the expected hash.
+	content string
+	blob    string
+	size    int64
+	issue   *VerifyIssue
+}
+
+// expectedHash returns the hash algorithm and digest encoded in a blob name. LFS blobs are
+// named by their sha256, regular git files by their git blob sha1.
+func expectedHash(blob string) (func() hash.Hash, string, bool) {
+	name := filepath.Base(blob)
+	if _, err := hex.DecodeString(name); err != nil {
+		return nil, "", false
+	}
+	switch len(name) {
+	case sha256.Size * 2:
+		return sha256.New, name, true
+	case sha1.Size * 2:
+		return sha1.New, name, true
+	}
+	return nil, "", false
+}
+
+// linkedFileTasks lists the files of a linked model together with the blobs they came from.
+func linkedFileTasks(index int, m ModelInfo) []verifyTask {
+	var tasks []verifyTask
+	marker, _ := ReadMarker(m.TargetPath)
+	filepath.WalkDir(m.TargetPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == metadataFile {
+			return nil
+		}
+		task := verifyTask{model: index, file: path}
+		if d.Type()&os.ModeSymlink != 0 {
+			real, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				dest, _ := os.Readlink(path)
+				task.issue = &VerifyIssue{File: path, Blob: dest, Kind: VerifyMissing, Detail: "blob does not exist"}
+				tasks = append(tasks, task)
+				return nil
+			}
+			task.content, task.blob = real, real
+		} else {
+			// Copied or hard-linked files are checked against the blob of the same file in
+			// the linked revision.
+			rel, _ := filepath.Rel(m.TargetPath, path)
+			if marker.Revision == "" {
+				return nil
+			}
+			blob, err := filepath.EvalSymlinks(filepath.Join(m.SourcePath, snapshotsDir, marker.Revision, rel))
+			if err != nil {
+				return nil
+			}
+			task.content, task.blob = path, blob
+		}
+		if _, _, ok := expectedHash(task.blob); !ok {
+			return nil
+		}
+		if info, err := os.Stat(task.content); err == nil {
+			task.size = info.Size()
+		}
+		tasks = append(tasks, task)
+		return nil
+	})
+	return tasks
+}
+
+// hashFile hashes a file and compares it with the digest encoded in the blob name. The
+// entry is meaningless if ctx was cancelled while hashing.
+func hashFile(ctx context.Context, task verifyTask, onBytes func(int64)) verifyEntry {
+	newHash, want, _ := expectedHash(task.blob)
+	f, err := os.Open(task.content)
+	if err != nil {
+		return verifyEntry{Kind: VerifyMissing, Detail: err.Error()}
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return verifyEntry{Kind: VerifyMissing, Detail: err.Error()}
+	}
+	entry := verifyEntry{Size: info.Size(), ModTime: info.ModTime()}
+
+	h := newHash()
+	if len(want) == sha1.Size*2 {
+		// Git hashes a "blob <size>\0" header before the content.
+		fmt.Fprintf(h, "blob %d\x00", info.Size())
+	}
+	n, err := io.Copy(h, &progressReader{r: &contextReader{ctx: ctx, r: f}, onBytes: onBytes})
+	if err != nil {
+		entry.Kind, entry.Detail = VerifyUnreadable, fmt.Sprintf("read failed after %d of %d bytes: %v", n, info.Size(), err)
+		return entry
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		entry.Kind, entry.Detail = VerifyMismatch, "content hash "+got[:12]+" does not match blob name"
+		if info.Size() == 0 {
+			entry.Kind, entry.Detail = VerifyTruncated, "file is empty"
+		} else if blob, err := os.Stat(task.blob); err == nil && task.blob != task.content && info.Size() < blob.Size() {
+			entry.Kind, entry.Detail = VerifyTruncated, fmt.Sprintf("copy has %d of the blob's %d bytes", info.Size(), blob.Size())
+		}
+	}
+	return entry
+}
+
+// progressReader reports every chunk read to onBytes.
+type progressReader struct {
+	r       io.Reader
+	onBytes func(int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 && p.onBytes != nil {
+		p.onBytes(int64(n))
+	}
+	return n, err
+}
+
+// VerifyModels hashes the blobs behind every linked file of the given models in parallel and
+// compares them with their content-addressed names. Files whose size and mtime match a
+// cached verdict are not re-hashed. workers <= 0 uses one worker per CPU. progress, if set,
+// is called from worker goroutines as files are hashed. Once ctx is cancelled no further
+// files are hashed and the results only count the files checked so far.
+func VerifyModels(ctx context.Context, models []ModelInfo, cache *VerifyCache, workers int, progress func(VerifyProgress)) []VerifyResult {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	results := make([]VerifyResult, len(models))
+	var tasks []verifyTask
+	var state VerifyProgress
+	for i, m := range models {
+		results[i].Model = m
+		for _, task := range linkedFileTasks(i, m) {
+			tasks = append(tasks, task)
+			state.BytesTotal += task.size
+		}
+	}
+	state.FilesTotal = len(tasks)
+
+	var mu sync.Mutex
+	report := func(update func(*VerifyProgress)) {
+		mu.Lock()
+		update(&state)
+		snapshot := state
+		mu.Unlock()
+		if progress != nil {
+			progress(snapshot)
+		}
+	}
+	record := func(task verifyTask, entry verifyEntry, cached bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		r := &results[task.model]
+		r.Checked++
+		if cached {
+			r.Cached++
+		}
+		if entry.Kind != "" {
+			r.Issues = append(r.Issues, VerifyIssue{File: task.file, Blob: task.blob, Kind: entry.Kind, Detail: entry.Detail})
+		}
+	}
+
+	queue := make(chan verifyTask)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				if task.issue != nil {
+					record(task, verifyEntry{Kind: task.issue.Kind, Detail: task.issue.Detail}, false)
+					report(func(p *VerifyProgress) { p.FilesDone++; p.File = task.file })
+					continue
+				}
+				info, err := os.Stat(task.content)
+				if err == nil {
+					if entry, ok := cache.lookup(task.content, info); ok {
+						record(task, entry, true)
+						report(func(p *VerifyProgress) { p.FilesDone++; p.BytesDone += task.size; p.File = task.file })
+						continue
+					}
+				}
+				entry := hashFile(ctx, task, func(n int64) {
+					report(func(p *VerifyProgress) { p.BytesDone += n; p.File = task.file })
+				})
+				if ctx.Err() != nil {
+					continue
+				}
+				if entry.Kind != VerifyMissing {
+					cache.store(task.content, entry)
+				}
+				record(task, entry, false)
+				report(func(p *VerifyProgress) { p.FilesDone++ })
+			}
+		}()
+	}
+feed:
+	for _, task := range tasks {
+		select {
+		case queue <- task:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+// ApplyVerifyCache marks linked models as corrupt when the cache holds a failing verdict for
+// any of their files that still has the same size and mtime. It does not hash anything.
+func ApplyVerifyCache(models []ModelInfo, cache *VerifyCache) {
+	for i := range models {
+		m := &models[i]
+		if !m.IsLinked || m.IsStale {
+			continue
+		}
+		m.IsCorrupt, m.CorruptReason = false, ""
+		for _, task := range linkedFileTasks(i, *m) {
+			if task.issue != nil {
+				continue
+			}
+			info, err := os.Stat(task.content)
+			if err != nil {
+				continue
+			}
+			if entry, ok := cache.lookup(task.content, info); ok && entry.Kind != "" {
+				m.IsCorrupt = true
+				m.CorruptReason = describeIssue(filepath.Base(task.file), entry.Kind)
+				break
+			}
+		}
+	}
+}
+
+// MarkCorrupt sets the corrupt state of a model from a verification result.
+func MarkCorrupt(m *ModelInfo, result VerifyResult) {
+	m.IsCorrupt = len(result.Issues) > 0
+	m.CorruptReason = ""
+	if m.IsCorrupt {
+		issue := result.Issues[0]
+		m.CorruptReason = describeIssue(filepath.Base(issue.File), issue.Kind)
+		if len(result.Issues) > 1 {
+			m.CorruptReason += fmt.Sprintf(" (+%d more)", len(result.Issues)-1)
+		}
+	}
+}
+
+// describeIssue renders a short reason such as "model.gguf mismatch".
+func describeIssue(file string, kind VerifyIssueKind) string {
+	return strings.TrimSpace(file + " " + string(kind))
+}
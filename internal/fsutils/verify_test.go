@@ -0,0 +1,170 @@
+package fsutils
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
+)
+
+// linkFixture creates and links a repository, returning the linked ModelInfo.
+func linkFixture(t *testing.T, files map[string]string) ModelInfo {
+	t.Helper()
+	hfCache := t.TempDir()
+	targetDir := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "rev", files)
+	m := ModelInfo{
+		CacheDirName:     "models--org--model",
+		OrganizationName: "org",
+		ModelName:        "model",
+		SourcePath:       repo,
+		TargetPath:       filepath.Join(targetDir, "org", "model"),
+	}
+	if err := LinkModel(m); err != nil {
+		t.Fatalf("LinkModel returned error: %v", err)
+	}
+	m.IsLinked = true
+	return m
+}
+
+// TestVerifyModels tests that intact blobs pass, corrupted blobs are reported, and cached
+// verdicts are reused until a file changes.
+func TestVerifyModels(t *testing.T) {
+	m := linkFixture(t, map[string]string{"a.gguf": "aaaa", "b.gguf": "bbbb"})
+	cache := LoadVerifyCache(filepath.Join(t.TempDir(), "verify.json"))
+
+	var last VerifyProgress
+	results := VerifyModels(context.Background(), []ModelInfo{m}, cache, 2, func(p VerifyProgress) { last = p })
+	if len(results) != 1 || results[0].Checked != 2 || len(results[0].Issues) != 0 {
+		t.Fatalf("expected 2 clean files, got %+v", results)
+	}
+	if last.FilesDone != 2 || last.BytesDone != 8 || last.BytesTotal != 8 {
+		t.Errorf("unexpected final progress: %+v", last)
+	}
+
+	results = VerifyModels(context.Background(), []ModelInfo{m}, cache, 2, nil)
+	if results[0].Cached != 2 {
+		t.Errorf("expected both files to be answered from cache, got %d", results[0].Cached)
+	}
+
+	// Corrupt one blob in place.
+	blob, err := filepath.EvalSymlinks(filepath.Join(m.TargetPath, "a.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(blob, []byte("evil!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results = VerifyModels(context.Background(), []ModelInfo{m}, cache, 2, nil)
+	if len(results[0].Issues) != 1 || results[0].Issues[0].Kind != VerifyMismatch {
+		t.Fatalf("expected one mismatch, got %+v", results[0].Issues)
+	}
+
+	MarkCorrupt(&m, results[0])
+	if !m.IsCorrupt || m.Status() != "corrupt" {
+		t.Errorf("expected model to be marked corrupt, got %+v", m)
+	}
+
+	// A fresh scan picks the corrupt state up from the cache without hashing.
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save returned error: %v", err)
+	}
+	fresh := m
+	fresh.IsCorrupt = false
+	models := []ModelInfo{fresh}
+	ApplyVerifyCache(models, LoadVerifyCache(cache.path))
+	if !models[0].IsCorrupt {
+		t.Errorf("expected cached verdict to mark model corrupt")
+	}
+}
+
+// TestVerifyModelsMissingAndTruncated tests missing blobs and emptied files.
+func TestVerifyModelsMissingAndTruncated(t *testing.T) {
+	m := linkFixture(t, map[string]string{"a.gguf": "aaaa", "b.gguf": "bbbb"})
+
+	a, _ := filepath.EvalSymlinks(filepath.Join(m.TargetPath, "a.gguf"))
+	b, _ := filepath.EvalSymlinks(filepath.Join(m.TargetPath, "b.gguf"))
+	if err := os.Remove(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(b, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results := VerifyModels(context.Background(), []ModelInfo{m}, LoadVerifyCache(""), 1, nil)
+	kinds := map[VerifyIssueKind]bool{}
+	for _, issue := range results[0].Issues {
+		kinds[issue.Kind] = true
+	}
+	if !kinds[VerifyMissing] || !kinds[VerifyTruncated] {
+		t.Errorf("expected missing and truncated issues, got %+v", results[0].Issues)
+	}
+}
+
+// TestVerifyGitBlob tests that non-LFS blobs named by their git sha1 are verified.
+func TestVerifyGitBlob(t *testing.T) {
+	m := linkFixture(t, map[string]string{"a.gguf": "aaaa"})
+	content := []byte(`{"model_type": "llama"}`)
+	sum := sha1.Sum([]byte(fmt.Sprintf("blob %d\x00%s", len(content), content)))
+	blob := filepath.Join(m.SourcePath, "blobs", hex.EncodeToString(sum[:]))
+	if err := ioutil.WriteFile(blob, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(blob, filepath.Join(m.TargetPath, "config.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	results := VerifyModels(context.Background(), []ModelInfo{m}, LoadVerifyCache(""), 1, nil)
+	if results[0].Checked != 2 || len(results[0].Issues) != 0 {
+		t.Errorf("expected git and LFS blobs to verify cleanly, got %+v", results[0])
+	}
+}
+
+// TestVerifyTruncatedCopyAndUnreadable tests that a copy shorter than its blob is reported
+// as truncated, a symlinked blob cut short as a mismatch, and a read error as unreadable.
+func TestVerifyTruncatedCopyAndUnreadable(t *testing.T) {
+	hfCache := t.TempDir()
+	targetDir := t.TempDir()
+	repo := cachetest.MakeRepo(t, hfCache, "models--org--model", "rev", map[string]string{"a.gguf": "aaaa"})
+	m := ModelInfo{
+		CacheDirName:     "models--org--model",
+		OrganizationName: "org",
+		ModelName:        "model",
+		SourcePath:       repo,
+		TargetPath:       filepath.Join(targetDir, "org", "model"),
+		IsLinked:         true,
+	}
+	if err := LinkModelWith(context.Background(), m, LinkOptions{Mode: LinkCopy}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(m.TargetPath, "a.gguf"), []byte("aa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results := VerifyModels(context.Background(), []ModelInfo{m}, LoadVerifyCache(""), 1, nil)
+	if len(results[0].Issues) != 1 || results[0].Issues[0].Kind != VerifyTruncated {
+		t.Errorf("expected the short copy to be truncated, got %+v", results[0].Issues)
+	}
+
+	// Without a copy to compare with, a blob cut short cannot be told from corruption
+	linked := linkFixture(t, map[string]string{"b.gguf": "bbbb"})
+	blob, _ := filepath.EvalSymlinks(filepath.Join(linked.TargetPath, "b.gguf"))
+	if err := ioutil.WriteFile(blob, []byte("bb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results = VerifyModels(context.Background(), []ModelInfo{linked}, LoadVerifyCache(""), 1, nil)
+	if len(results[0].Issues) != 1 || results[0].Issues[0].Kind != VerifyMismatch {
+		t.Errorf("expected the short blob to be a mismatch, got %+v", results[0].Issues)
+	}
+
+	// A directory opens but cannot be read
+	entry := hashFile(context.Background(), verifyTask{content: t.TempDir(), blob: blob}, nil)
+	if entry.Kind != VerifyUnreadable {
+		t.Errorf("expected a read error to be reported as unreadable, got %+v", entry)
+	}
+}
@@ -0,0 +1,548 @@
+// Package journal records every operation that changes a target directory so that it can
+// be undone. An operation is written to disk before its first step runs and again after
+// every step, so an operation interrupted by a crash can later be resumed or rolled back.
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+)
+
+// dirName is the journal directory inside the hf-lms-sync cache directory.
+const dirName = "journal"
+
+// idFormat formats operation ids so that they sort chronologically.
+const idFormat = "20060102T150405.000000000"
+
+// DefaultKeep is how many operations are kept before the oldest are forgotten.
+const DefaultKeep = 200
+
+// ErrIrreversible is returned when undo reaches an operation that deleted data.
+var ErrIrreversible = errors.New("operation cannot be undone")
+
+// ErrNothingToUndo is returned when no operation is left to undo.
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// Step actions.
+const (
+	StepLink   = "link"
+	StepUnlink = "unlink"
+)
+
+// Status is the state of an operation.
+type Status string
+
+const (
+	// StatusRunning is an operation that has not finished; after a crash it is interrupted.
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusUndone  Status = "undone"
+)
+
+// Step is one link or unlink of a model.
+type Step struct {
+	Action   string           `json:"action"`
+	Repo     string           `json:"repo"`
+	Source   string           `json:"source,omitempty"`
+	Target   string           `json:"target"`
+	Revision string           `json:"revision,omitempty"`
+	Files    []string         `json:"files,omitempty"`
+	Mode     fsutils.LinkMode `json:"mode,omitempty"`
+	// Before is what the target held before the step, nil if it did not exist.
+	Before  *fsutils.TargetState `json:"before,omitempty"`
+	Started bool                 `json:"started,omitempty"`
+	Done    bool                 `json:"done,omitempty"`
+	Error   string               `json:"error,omitempty"`
+}
+
+// LinkStep returns a step that links a model with the given options.
+func LinkStep(m fsutils.ModelInfo, opts fsutils.LinkOptions) Step {
+	return Step{Action: StepLink, Repo: m.RepoID(), Source: m.SourcePath, Target: m.TargetPath, Revision: opts.Revision, Files: opts.Files, Mode: opts.Mode}
+}
+
+// UnlinkStep returns a step that unlinks a model.
+func UnlinkStep(m fsutils.ModelInfo) Step {
+	return Step{Action: StepUnlink, Repo: m.RepoID(), Source: m.SourcePath, Target: m.TargetPath}
+}
+
+// model rebuilds the model a step acts on.
+func (s Step) model() fsutils.ModelInfo {
+	org, name := "", s.Repo
+	if i := strings.Index(s.Repo, "/"); i >= 0 {
+		org, name = s.Repo[:i], s.Repo[i+1:]
+	}
+	return fsutils.ModelInfo{RepoType: fsutils.RepoTypeModel, OrganizationName: org, ModelName: name, SourcePath: s.Source, TargetPath: s.Target}
+}
+
+// run performs the step, reporting the files of a link to onProgress if set.
+func (s Step) run(ctx context.Context, onProgress func(fsutils.LinkProgress)) error {
+	if s.Action == StepLink {
+		return fsutils.LinkModelWith(ctx, s.model(), fsutils.LinkOptions{Revision: s.Revision, Files: s.Files, Mode: s.Mode, OnProgress: onProgress})
+	}
+	return fsutils.UnlinkModel(s.model())
+}
+
+// Op is a recorded operation.
+type Op struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	TargetDir string    `json:"target_dir,omitempty"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished,omitempty"`
+	Status    Status    `json:"status"`
+	// Irreversible operations, like deleting from the HF cache, are recorded for the
+	// history only.
+	Irreversible bool   `json:"irreversible,omitempty"`
+	Note         string `json:"note,omitempty"`
+	Steps        []Step `json:"steps,omitempty"`
+
+	mu      sync.Mutex
+	journal *Journal
+}
+
+// Journal stores operations as one JSON file each.
+type Journal struct {
+	dir  string
+	keep int
+}
+
+// Open returns the journal stored in dir. An empty dir keeps nothing, so operations
+// still run but cannot be undone.
+func Open(dir string) *Journal {
+	return &Journal{dir: dir, keep: DefaultKeep}
+}
+
+// OpenDefault returns the journal in the default cache directory.
+func OpenDefault() *Journal {
+	dir, err := fsutils.DefaultCacheDir()
+	if err != nil {
+		return Open("")
+	}
+	return Open(filepath.Join(dir, dirName))
+}
+
+// Dir returns the journal directory.
+func (j *Journal) Dir() string {
+	return j.dir
+}
+
+// newOp creates an operation with a sortable unique id. Coarse clocks can repeat a
+// timestamp, so taken ids are skipped.
+func (j *Journal) newOp(kind, targetDir string) *Op {
+	now := time.Now()
+	id := now.UTC().Format(idFormat)
+	for j.dir != "" {
+		if _, err := os.Stat(filepath.Join(j.dir, id+".json")); os.IsNotExist(err) {
+			break
+		}
+		now = now.Add(time.Nanosecond)
+		id = now.UTC().Format(idFormat)
+	}
+	return &Op{
+		ID:        id,
+		Kind:      kind,
+		TargetDir: targetDir,
+		Started:   now,
+		Status:    StatusRunning,
+		journal:   j,
+	}
+}
+
+// Begin records an operation and what each step's target holds before any step runs.
+func (j *Journal) Begin(kind, targetDir string, steps []Step) (*Op, error) {
+	op := j.newOp(kind, targetDir)
+	op.Steps = steps
+	for i := range op.Steps {
+		before, err := fsutils.CaptureTarget(op.Steps[i].Target)
+		if err != nil {
+			return nil, err
+		}
+		op.Steps[i].Before = before
+	}
+	if err := op.save(); err != nil {
+		return nil, err
+	}
+	j.trim()
+	return op, nil
+}
+
+// Record adds an irreversible operation to the history.
+func (j *Journal) Record(kind, targetDir, note string) error {
+	op := j.newOp(kind, targetDir)
+	op.Irreversible = true
+	op.Note = note
+	op.Status = StatusDone
+	op.Finished = op.Started
+	return op.save()
+}
+
+// RunOptions controls how Run performs the steps of an operation.
+type RunOptions struct {
+	// Workers is how many steps run at the same time; 1 or less runs them one by one.
+	Workers int
+	// OnStep, if set, is called as each step completes with its index and error. Calls
+	// arrive in completion order and never overlap.
+	OnStep func(i int, err error)
+	// OnProgress, if set, is called with the index of a step as it links each file. Calls
+	// never overlap with each other or with OnStep.
+	OnProgress func(i int, p fsutils.LinkProgress)
+}
+
+// Run records an operation, performs its steps in order and marks it done. The returned
+// slice holds the error of every step, nil for steps that succeeded; the error is only
+// set when the operation could not be recorded, in which case nothing ran. Once ctx is
+// cancelled the remaining steps are skipped with ctx's error and the operation is noted
+// as cancelled; the steps that ran can be undone as usual.
+func (j *Journal) Run(ctx context.Context, kind, targetDir string, steps []Step) ([]error, error) {
+	return j.RunWith(ctx, kind, targetDir, steps, RunOptions{})
+}
+
+// RunWith is Run with a pool of workers. Steps on the same target run in their original
+// order on one worker, so the outcome does not depend on scheduling.
+func (j *Journal) RunWith(ctx context.Context, kind, targetDir string, steps []Step, opts RunOptions) ([]error, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	op, err := j.Begin(kind, targetDir, steps)
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(op.Steps))
+	var mu sync.Mutex
+	op.runGroups(opts.Workers, func(i int) {
+		if err := ctx.Err(); err != nil {
+			mu.Lock()
+			errs[i] = err
+			mu.Unlock()
+			return
+		}
+		var onProgress func(fsutils.LinkProgress)
+		if opts.OnProgress != nil {
+			onProgress = func(p fsutils.LinkProgress) {
+				mu.Lock()
+				defer mu.Unlock()
+				opts.OnProgress(i, p)
+			}
+		}
+		err := op.run(ctx, i, onProgress)
+		mu.Lock()
+		defer mu.Unlock()
+		errs[i] = err
+		if opts.OnStep != nil {
+			opts.OnStep(i, err)
+		}
+	})
+	if ctx.Err() != nil {
+		op.mu.Lock()
+		op.Note = "cancelled"
+		op.mu.Unlock()
+	}
+	return errs, op.Finish()
+}
+
+// runGroups calls run for every step on up to workers goroutines. Steps are grouped by
+// target and each group is handed to a single goroutine in step order.
+func (op *Op) runGroups(workers int, run func(i int)) {
+	var groups [][]int
+	byTarget := map[string]int{}
+	for i, step := range op.Steps {
+		g, ok := byTarget[step.Target]
+		if !ok {
+			g = len(groups)
+			byTarget[step.Target] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	if workers > len(groups) {
+		workers = len(groups)
+	}
+	if workers <= 1 {
+		for i := range op.Steps {
+			run(i)
+		}
+		return
+	}
+
+	queue := make(chan []int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range queue {
+				for _, i := range group {
+					run(i)
+				}
+			}
+		}()
+	}
+	for _, group := range groups {
+		queue <- group
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// Run performs step i, recording that it started and how it ended.
+func (op *Op) Run(ctx context.Context, i int) error {
+	return op.run(ctx, i, nil)
+}
+
+// run is Run reporting the progress of a link to onProgress.
+func (op *Op) run(ctx context.Context, i int, onProgress func(fsutils.LinkProgress)) error {
+	op.mu.Lock()
+	op.Steps[i].Started = true
+	step := op.Steps[i]
+	err := op.saveLocked()
+	op.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	runErr := step.run(ctx, onProgress)
+
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.Steps[i].Done = runErr == nil
+	op.Steps[i].Error = ""
+	if runErr != nil {
+		op.Steps[i].Error = runErr.Error()
+	}
+	if err := op.saveLocked(); err != nil && runErr == nil {
+		return err
+	}
+	return runErr
+}
+
+// Finish marks the operation done.
+func (op *Op) Finish() error {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.Status = StatusDone
+	op.Finished = time.Now()
+	return op.saveLocked()
+}
+
+// Progress returns how many steps have completed.
+func (op *Op) Progress() (done, total int) {
+	for _, step := range op.Steps {
+		if step.Done {
+			done++
+		}
+	}
+	return done, len(op.Steps)
+}
+
+// Describe summarizes an operation in one line, e.g. "unlink all (12/40 steps)".
+func (op *Op) Describe() string {
+	if op.Irreversible {
+		return op.Kind + " (" + op.Note + ")"
+	}
+	done, total := op.Progress()
+	if total == 1 {
+		desc := op.Kind + " " + op.Steps[0].Repo
+		if op.Note != "" {
+			desc += " (" + op.Note + ")"
+		}
+		return desc
+	}
+	if op.Note != "" {
+		return fmt.Sprintf("%s (%d/%d steps, %s)", op.Kind, done, total, op.Note)
+	}
+	return fmt.Sprintf("%s (%d/%d steps)", op.Kind, done, total)
+}
+
+// Resume runs the steps an interrupted operation did not complete and marks it done. If
+// ctx is cancelled the operation stays interrupted so that it can be resumed later.
+func (op *Op) Resume(ctx context.Context) []error {
+	var errs []error
+	for i := range op.Steps {
+		if op.Steps[i].Done {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return append(errs, err)
+		}
+		if err := op.Run(ctx, i); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %v", op.Steps[i].Action, op.Steps[i].Repo, err))
+		}
+	}
+	if err := op.Finish(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Undo puts the target of every started step back in reverse order. For an interrupted
+// operation this is a rollback. Steps that cannot be restored are reported and the
+// operation stays undoable.
+func (op *Op) Undo() error {
+	if op.Irreversible {
+		return fmt.Errorf("%w: %s", ErrIrreversible, op.Describe())
+	}
+	var failed []string
+	for i := len(op.Steps) - 1; i >= 0; i-- {
+		step := op.Steps[i]
+		if !step.Started {
+			continue
+		}
+		if err := fsutils.RestoreTarget(step.Target, step.Source, step.Before); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", step.Repo, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to undo %s: %s", op.Describe(), strings.Join(failed, "; "))
+	}
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.Status = StatusUndone
+	return op.saveLocked()
+}
+
+// save writes the operation to the journal.
+func (op *Op) save() error {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.saveLocked()
+}
+
+// saveLocked writes the operation atomically, so a crash leaves either the old or the
+// new version on disk.
+func (op *Op) saveLocked() error {
+	if op.journal == nil || op.journal.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(op.journal.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create journal directory: %v", err)
+	}
+	data, err := json.MarshalIndent(op, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(op.journal.dir, op.ID+".json")
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write journal: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write journal: %v", err)
+	}
+	return nil
+}
+
+// List returns the recorded operations, newest first.
+func (j *Journal) List() ([]*Op, error) {
+	if j.dir == "" {
+		return nil, nil
+	}
+	entries, err := ioutil.ReadDir(j.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var ops []*Op
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(j.dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		op := &Op{journal: j}
+		if err := json.Unmarshal(data, op); err != nil {
+			continue
+		}
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(a, b int) bool { return ops[a].ID > ops[b].ID })
+	return ops, nil
+}
+
+// Interrupted returns the operations that were still running when their process ended.
+// Operations of a process that is still running look the same.
+func (j *Journal) Interrupted() ([]*Op, error) {
+	ops, err := j.List()
+	if err != nil {
+		return nil, err
+	}
+	var interrupted []*Op
+	for _, op := range ops {
+		if op.Status == StatusRunning {
+			interrupted = append(interrupted, op)
+		}
+	}
+	return interrupted, nil
+}
+
+// Undoable returns up to n operations that Undo would reverse, newest first. It stops
+// before the first irreversible operation.
+func (j *Journal) Undoable(n int) ([]*Op, error) {
+	ops, err := j.List()
+	if err != nil {
+		return nil, err
+	}
+	var undoable []*Op
+	for _, op := range ops {
+		if len(undoable) == n {
+			break
+		}
+		if op.Status == StatusUndone {
+			continue
+		}
+		if op.Irreversible {
+			if len(undoable) == 0 {
+				return nil, fmt.Errorf("%w: %s", ErrIrreversible, op.Describe())
+			}
+			break
+		}
+		undoable = append(undoable, op)
+	}
+	if len(undoable) == 0 {
+		return nil, ErrNothingToUndo
+	}
+	return undoable, nil
+}
+
+// Undo reverses the last n operations, newest first, and returns those it reversed.
+func (j *Journal) Undo(n int) ([]*Op, error) {
+	ops, err := j.Undoable(n)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		if err := op.Undo(); err != nil {
+			return ops[:i], err
+		}
+	}
+	return ops, nil
+}
+
+// trim forgets the oldest operations beyond the retention limit. Running operations are
+// kept however old they are: they may have been interrupted and still need to be resumed
+// or rolled back.
+func (j *Journal) trim() {
+	ops, err := j.List()
+	if err != nil || len(ops) <= j.keep {
+		return
+	}
+	for _, op := range ops[j.keep:] {
+		if op.Status != StatusRunning {
+			os.Remove(filepath.Join(j.dir, op.ID+".json"))
+		}
+	}
+}
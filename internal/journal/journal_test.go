@@ -0,0 +1,308 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/cachetest"
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+)
+
+// setup creates a Hugging Face cache with two GGUF repositories and returns them as
+// models targeting a fresh LM Studio directory.
+func setup(t *testing.T) (string, []fsutils.ModelInfo) {
+	t.Helper()
+	root := t.TempDir()
+	t.Setenv("HOME", root)
+	t.Setenv("XDG_CACHE_HOME", root)
+	hfCache, err := fsutils.GetHfCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"models--org--alpha", "models--org--beta"} {
+		cachetest.MakeRepo(t, hfCache, name, "rev1", map[string]string{"model.gguf": name})
+	}
+	targetDir := filepath.Join(root, "lmstudio")
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	models, err := fsutils.LoadModels(targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return targetDir, models
+}
+
+// linked reports whether a model's target carries the metadata file.
+func linked(m fsutils.ModelInfo) bool {
+	return fsutils.HasMarker(m.TargetPath)
+}
+
+// TestRunAndUndo tests that operations are recorded and undone newest first.
+func TestRunAndUndo(t *testing.T) {
+	targetDir, models := setup(t)
+	j := Open(t.TempDir())
+
+	var links []Step
+	for _, m := range models {
+		links = append(links, LinkStep(m, fsutils.LinkOptions{}))
+	}
+	errs, err := j.Run(context.Background(), "link all", targetDir, links)
+	if err != nil || errs[0] != nil || errs[1] != nil {
+		t.Fatalf("link all failed: %v %v", err, errs)
+	}
+	if _, err := j.Run(context.Background(), "unlink", targetDir, []Step{UnlinkStep(models[0])}); err != nil {
+		t.Fatal(err)
+	}
+	if linked(models[0]) || !linked(models[1]) {
+		t.Fatalf("unexpected state after unlink")
+	}
+	if err := j.Record("delete", targetDir, "org/gamma"); err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := j.List()
+	if err != nil || len(ops) != 3 || ops[0].Kind != "delete" || ops[2].Kind != "link all" {
+		t.Fatalf("unexpected history %v (err %v)", ops, err)
+	}
+	if _, err := j.Undo(1); !errors.Is(err, ErrIrreversible) {
+		t.Fatalf("expected undo to stop at the deletion, got %v", err)
+	}
+
+	// Without the irreversible entry undo walks back through the history.
+	os.Remove(filepath.Join(j.Dir(), ops[0].ID+".json"))
+	undone, err := j.Undo(1)
+	if err != nil || len(undone) != 1 || undone[0].Kind != "unlink" {
+		t.Fatalf("unexpected undo %v (err %v)", undone, err)
+	}
+	if !linked(models[0]) {
+		t.Errorf("expected undoing the unlink to restore the link")
+	}
+	if _, err := os.Readlink(filepath.Join(models[0].TargetPath, "model.gguf")); err != nil {
+		t.Errorf("expected restored symlink: %v", err)
+	}
+	if _, err := j.Undo(1); err != nil {
+		t.Fatal(err)
+	}
+	if linked(models[0]) || linked(models[1]) {
+		t.Errorf("expected undoing link all to remove both links")
+	}
+	if _, err := os.Stat(models[0].TargetPath); !os.IsNotExist(err) {
+		t.Errorf("expected target directory to be removed")
+	}
+	if _, err := j.Undo(1); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("expected nothing left to undo, got %v", err)
+	}
+}
+
+// TestInterrupted tests that an operation that never finished can be resumed or rolled
+// back from its journal file.
+func TestInterrupted(t *testing.T) {
+	targetDir, models := setup(t)
+	j := Open(t.TempDir())
+
+	begin := func() {
+		var steps []Step
+		for _, m := range models {
+			steps = append(steps, LinkStep(m, fsutils.LinkOptions{}))
+		}
+		op, err := j.Begin("link all", targetDir, steps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Simulate a crash after the first step.
+		if err := op.Run(context.Background(), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	begin()
+	interrupted, err := j.Interrupted()
+	if err != nil || len(interrupted) != 1 {
+		t.Fatalf("expected one interrupted operation, got %v (err %v)", interrupted, err)
+	}
+	if done, total := interrupted[0].Progress(); done != 1 || total != 2 {
+		t.Errorf("expected 1/2 steps done, got %d/%d", done, total)
+	}
+	if errs := interrupted[0].Resume(context.Background()); len(errs) > 0 {
+		t.Fatalf("resume failed: %v", errs)
+	}
+	if !linked(models[0]) || !linked(models[1]) {
+		t.Errorf("expected resume to link both models")
+	}
+	if interrupted, _ := j.Interrupted(); len(interrupted) != 0 {
+		t.Errorf("expected no interrupted operations after resume")
+	}
+	if _, err := j.Undo(1); err != nil {
+		t.Fatal(err)
+	}
+
+	begin()
+	if _, err := j.Undo(1); err != nil {
+		t.Fatal(err)
+	}
+	if linked(models[0]) || linked(models[1]) {
+		t.Errorf("expected rollback to remove the partial links")
+	}
+}
+
+// TestTrimKeepsRunning tests that trimming the journal never forgets an interrupted
+// operation, however old it is.
+func TestTrimKeepsRunning(t *testing.T) {
+	targetDir, models := setup(t)
+	j := Open(t.TempDir())
+	j.keep = 1
+
+	op, err := j.Begin("link all", targetDir, []Step{LinkStep(models[0], fsutils.LinkOptions{}), LinkStep(models[1], fsutils.LinkOptions{})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := op.Run(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := j.Record("delete", targetDir, "org/gamma"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := j.Begin("link", targetDir, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	interrupted, err := j.Interrupted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, o := range interrupted {
+		if o.ID == op.ID {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the interrupted operation to survive trimming")
+	}
+	ops, err := j.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range ops[1:] {
+		if o.Status != StatusRunning {
+			t.Errorf("expected finished operation %s beyond the limit to be trimmed", o.ID)
+		}
+	}
+}
+
+// TestUndoRelinkRestoresOptions tests that undoing a relink restores the previous link
+// mode of a copied model.
+func TestUndoRelinkRestoresOptions(t *testing.T) {
+	targetDir, models := setup(t)
+	j := Open(t.TempDir())
+	m := models[0]
+	if _, err := j.Run(context.Background(), "link", targetDir, []Step{LinkStep(m, fsutils.LinkOptions{Mode: fsutils.LinkCopy})}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Run(context.Background(), "link", targetDir, []Step{LinkStep(m, fsutils.LinkOptions{})}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Undo(1); err != nil {
+		t.Fatal(err)
+	}
+	marker, err := fsutils.ReadMarker(m.TargetPath)
+	if err != nil || marker.Mode != fsutils.LinkCopy {
+		t.Errorf("expected the copy to be restored, got %+v (err %v)", marker, err)
+	}
+	info, err := os.Lstat(filepath.Join(m.TargetPath, "model.gguf"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Errorf("expected a regular file after undo (err %v)", err)
+	}
+}
+
+// TestRunWithWorkers tests that a worker pool runs steps on the same target in order,
+// reports every step once and collects the errors of failed steps.
+func TestRunWithWorkers(t *testing.T) {
+	targetDir, models := setup(t)
+	j := Open(t.TempDir())
+	alpha, beta := models[0], models[1]
+	missing := alpha
+	missing.ModelName = "missing"
+	missing.SourcePath = filepath.Join(targetDir, "no-such-repo")
+	missing.TargetPath = filepath.Join(targetDir, "org", "missing")
+
+	steps := []Step{
+		LinkStep(alpha, fsutils.LinkOptions{}),
+		LinkStep(beta, fsutils.LinkOptions{}),
+		UnlinkStep(alpha),
+		LinkStep(missing, fsutils.LinkOptions{}),
+		LinkStep(alpha, fsutils.LinkOptions{Mode: fsutils.LinkCopy}),
+		UnlinkStep(beta),
+	}
+	for round := 0; round < 10; round++ {
+		var order []int
+		progressed := map[int]bool{}
+		errs, err := j.RunWith(context.Background(), "bulk", targetDir, steps, RunOptions{
+			Workers:    4,
+			OnStep:     func(i int, err error) { order = append(order, i) },
+			OnProgress: func(i int, p fsutils.LinkProgress) { progressed[i] = true },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(order) != len(steps) {
+			t.Fatalf("expected %d step reports, got %v", len(steps), order)
+		}
+		last := map[string]int{}
+		for _, i := range order {
+			if prev, ok := last[steps[i].Target]; ok && prev > i {
+				t.Fatalf("steps on %s ran out of order: %v", steps[i].Target, order)
+			}
+			last[steps[i].Target] = i
+		}
+		for i, stepErr := range errs {
+			if (stepErr != nil) != (i == 3) {
+				t.Errorf("step %d: unexpected error %v", i, stepErr)
+			}
+			if progressed[i] != (steps[i].Action == StepLink && i != 3) {
+				t.Errorf("step %d: unexpected progress report %v", i, progressed[i])
+			}
+		}
+		marker, err := fsutils.ReadMarker(alpha.TargetPath)
+		if err != nil || marker.Mode != fsutils.LinkCopy || linked(beta) {
+			t.Fatalf("unexpected end state: alpha %+v (err %v), beta linked %v", marker, err, linked(beta))
+		}
+	}
+}
+
+// TestRunCancelled tests that cancelling an operation skips the remaining steps, notes the
+// cancellation and leaves the steps that ran undoable.
+func TestRunCancelled(t *testing.T) {
+	targetDir, models := setup(t)
+	j := Open(t.TempDir())
+	var steps []Step
+	for _, m := range models {
+		steps = append(steps, LinkStep(m, fsutils.LinkOptions{}))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs, err := j.RunWith(ctx, "link all", targetDir, steps, RunOptions{Workers: 1, OnStep: func(i int, err error) {
+		cancel()
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs[0] != nil || !errors.Is(errs[1], context.Canceled) {
+		t.Fatalf("expected the second step to be skipped, got %v", errs)
+	}
+	if !linked(models[0]) || linked(models[1]) {
+		t.Fatalf("unexpected state after cancellation")
+	}
+	ops, err := j.List()
+	if err != nil || len(ops) != 1 || ops[0].Describe() != "link all (1/2 steps, cancelled)" {
+		t.Fatalf("unexpected history %v (err %v)", ops, err)
+	}
+	if _, err := j.Undo(1); err != nil || linked(models[0]) {
+		t.Errorf("expected the linked step to be undone, got %v", err)
+	}
+}
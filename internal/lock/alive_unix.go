@@ -0,0 +1,15 @@
+//go:build !windows
+
+package lock
+
+import "syscall"
+
+// processAlive reports whether a process with the given PID exists on this host.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	// EPERM means the process exists but belongs to another user.
+	return err == nil || err == syscall.EPERM
+}
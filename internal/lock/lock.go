@@ -0,0 +1,248 @@
+// Package lock keeps two hf-lms-sync processes, say the UI and a cron job, from changing
+// the same directories at once. A lock is a file holding the owner's PID and host; locks of
+// processes that died on this host are detected and taken over.
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileName is the lock file created in every locked directory.
+const FileName = ".hf-lms-sync.lock"
+
+// PollInterval is how often Wait retries a held lock.
+const PollInterval = 500 * time.Millisecond
+
+// partialAge is how old an unreadable lock file must be before it counts as stale; a
+// younger one is probably still being written.
+const partialAge = 10 * time.Second
+
+// ErrLocked is wrapped by errors for directories locked by another process.
+var ErrLocked = errors.New("locked by another hf-lms-sync process")
+
+// Info identifies the process holding a lock.
+type Info struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+	Command string    `json:"command,omitempty"`
+}
+
+// String describes the holder, e.g. "pid 1234 on laptop (hf-lms-sync link-all) since 14:02:11".
+func (i Info) String() string {
+	s := fmt.Sprintf("pid %d on %s", i.PID, i.Host)
+	if i.Command != "" {
+		s += " (" + i.Command + ")"
+	}
+	if !i.Started.IsZero() {
+		s += " since " + i.Started.Format("15:04:05")
+	}
+	return s
+}
+
+// HeldError reports a directory locked by another live process.
+type HeldError struct {
+	Path   string
+	Holder Info
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("%s is locked by %s; remove %s if that process is gone", filepath.Dir(e.Path), e.Holder, e.Path)
+}
+
+func (e *HeldError) Unwrap() error {
+	return ErrLocked
+}
+
+// Lock is a set of held lock files.
+type Lock struct {
+	paths []string
+}
+
+// self describes the current process.
+func self() Info {
+	host, _ := os.Hostname()
+	return Info{
+		PID:     os.Getpid(),
+		Host:    host,
+		Started: time.Now(),
+		Command: strings.Join(append([]string{filepath.Base(os.Args[0])}, os.Args[1:]...), " "),
+	}
+}
+
+// Acquire locks every directory or none. Directories are locked in sorted order so that
+// processes locking overlapping sets cannot deadlock while waiting.
+func Acquire(dirs ...string) (*Lock, error) {
+	l := &Lock{}
+	info := self()
+	for _, dir := range normalize(dirs) {
+		path := filepath.Join(dir, FileName)
+		if err := acquireFile(path, info); err != nil {
+			l.Release()
+			return nil, err
+		}
+		l.paths = append(l.paths, path)
+	}
+	return l, nil
+}
+
+// Wait is Acquire that retries while another process holds a lock, until ctx is done.
+// onWait, if set, is called with the first HeldError.
+func Wait(ctx context.Context, onWait func(*HeldError), dirs ...string) (*Lock, error) {
+	notified := false
+	for {
+		l, err := Acquire(dirs...)
+		var held *HeldError
+		if err == nil || !errors.As(err, &held) {
+			return l, err
+		}
+		if onWait != nil && !notified {
+			onWait(held)
+			notified = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// Status reports whether a live process holds the lock of any of the directories, as a
+// *HeldError for the first one, without creating or removing lock files. Stale locks
+// count as free. The answer is only a snapshot: use Acquire before changing anything.
+func Status(dirs ...string) error {
+	host, _ := os.Hostname()
+	for _, dir := range normalize(dirs) {
+		path := filepath.Join(dir, FileName)
+		if _, err := os.Lstat(path); err != nil {
+			continue
+		}
+		if holder, stale := inspect(path, host); !stale {
+			return &HeldError{Path: path, Holder: holder}
+		}
+	}
+	return nil
+}
+
+// Release removes the lock files.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	var firstErr error
+	for i := len(l.paths) - 1; i >= 0; i-- {
+		if err := os.Remove(l.paths[i]); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	l.paths = nil
+	return firstErr
+}
+
+// normalize cleans, deduplicates and sorts directories, dropping empty ones.
+func normalize(dirs []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			out = append(out, dir)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// acquireFile creates a lock file, taking over a stale one.
+func acquireFile(path string, info Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	// One retry after removing a stale lock; losing that race to another process means
+	// the lock is legitimately held.
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, werr := f.Write(data)
+			cerr := f.Close()
+			if werr != nil || cerr != nil {
+				os.Remove(path)
+				return fmt.Errorf("failed to write lock file %s: %v", path, firstError(werr, cerr))
+			}
+			return nil
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("failed to create lock file %s: %v", path, err)
+		}
+		holder, stale := inspect(path, info.Host)
+		if !stale {
+			return &HeldError{Path: path, Holder: holder}
+		}
+		if err := removeStale(path, holder); err != nil {
+			return err
+		}
+	}
+	holder, _ := inspect(path, info.Host)
+	return &HeldError{Path: path, Holder: holder}
+}
+
+// inspect reads a lock file and decides whether its holder is gone.
+func inspect(path, host string) (Info, bool) {
+	var holder Info
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &holder)
+	}
+	if err != nil {
+		fi, statErr := os.Stat(path)
+		return holder, statErr == nil && time.Since(fi.ModTime()) > partialAge
+	}
+	// Processes on other hosts sharing the directory cannot be checked.
+	return holder, holder.Host == host && !processAlive(holder.PID)
+}
+
+// removeStale deletes a stale lock file. It is first renamed to a unique name and checked
+// again, so a lock that another process took over in the meantime is put back.
+func removeStale(path string, holder Info) error {
+	aside := path + ".stale-" + strconv.Itoa(os.Getpid())
+	if err := os.Rename(path, aside); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to remove stale lock file %s: %v", path, err)
+	}
+	var moved Info
+	if data, err := ioutil.ReadFile(aside); err == nil && json.Unmarshal(data, &moved) == nil && moved != holder {
+		os.Rename(aside, path)
+		return nil
+	}
+	return os.Remove(aside)
+}
+
+// firstError returns the first non-nil error.
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeLock writes a lock file as another process would.
+func writeLock(t *testing.T, dir string, info Info) {
+	t.Helper()
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, FileName), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deadPID returns the PID of a process that has exited.
+func deadPID(t *testing.T) int {
+	t.Helper()
+	pid := 1 << 22
+	for processAlive(pid) {
+		pid++
+	}
+	return pid
+}
+
+// TestAcquireRelease tests that a held lock blocks a second acquire of any of its
+// directories and that a failed acquire holds nothing.
+func TestAcquireRelease(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	l, err := Acquire(a, b, a)
+	if err != nil {
+		t.Fatalf("Acquire returned error: %v", err)
+	}
+	if _, err := Acquire(b); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	var held *HeldError
+	_, err = Acquire(t.TempDir(), a)
+	if !errors.As(err, &held) || held.Holder.PID != os.Getpid() {
+		t.Fatalf("expected a HeldError naming this process, got %v", err)
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("Release returned error: %v", err)
+	}
+	for _, dir := range []string{a, b} {
+		if _, err := os.Stat(filepath.Join(dir, FileName)); !os.IsNotExist(err) {
+			t.Errorf("expected lock file in %s to be removed", dir)
+		}
+	}
+	l, err = Acquire(a, b)
+	if err != nil {
+		t.Fatalf("expected lock to be free after release, got %v", err)
+	}
+	l.Release()
+}
+
+// TestStaleLock tests that locks of dead processes and old unreadable lock files are taken
+// over while locks of live processes and other hosts are respected.
+func TestStaleLock(t *testing.T) {
+	host, _ := os.Hostname()
+
+	dead := t.TempDir()
+	writeLock(t, dead, Info{PID: deadPID(t), Host: host})
+	l, err := Acquire(dead)
+	if err != nil {
+		t.Fatalf("expected stale lock to be taken over, got %v", err)
+	}
+	l.Release()
+
+	remote := t.TempDir()
+	writeLock(t, remote, Info{PID: deadPID(t), Host: host + "-elsewhere"})
+	if _, err := Acquire(remote); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected lock of another host to be respected, got %v", err)
+	}
+
+	partial := t.TempDir()
+	path := filepath.Join(partial, FileName)
+	ioutil.WriteFile(path, []byte("{"), 0644)
+	if _, err := Acquire(partial); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected a fresh unreadable lock to be respected, got %v", err)
+	}
+	old := time.Now().Add(-time.Minute)
+	os.Chtimes(path, old, old)
+	l, err = Acquire(partial)
+	if err != nil {
+		t.Fatalf("expected an old unreadable lock to be taken over, got %v", err)
+	}
+	l.Release()
+}
+
+// TestWait tests that Wait reports the holder and returns once the lock is released.
+func TestWait(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Acquire(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := Wait(ctx, nil, dir); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected Wait to time out, got %v", err)
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		<-waited
+		l.Release()
+	}()
+	l2, err := Wait(context.Background(), func(held *HeldError) { close(waited) }, dir)
+	if err != nil {
+		t.Fatalf("Wait returned error: %v", err)
+	}
+	l2.Release()
+}
+
+// TestStatus tests that held locks are reported and stale ones ignored, and that checking
+// leaves the lock files as they were.
+func TestStatus(t *testing.T) {
+	host, _ := os.Hostname()
+	free, dead, live := t.TempDir(), t.TempDir(), t.TempDir()
+	writeLock(t, dead, Info{PID: deadPID(t), Host: host})
+	writeLock(t, live, Info{PID: os.Getpid(), Host: host})
+
+	if err := Status(free, dead); err != nil {
+		t.Errorf("expected free and stale locks to be reported free, got %v", err)
+	}
+	var held *HeldError
+	if err := Status(free, live); !errors.As(err, &held) || held.Holder.PID != os.Getpid() {
+		t.Errorf("expected a HeldError naming this process, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(free, FileName)); !os.IsNotExist(err) {
+		t.Errorf("expected no lock file to be created")
+	}
+	if _, err := os.Stat(filepath.Join(dead, FileName)); err != nil {
+		t.Errorf("expected the stale lock file to be left alone, got %v", err)
+	}
+}
@@ -0,0 +1,458 @@
+// Package rules decides which models bulk operations may act on. A rule is a list of
+// space-separated conditions that must all match:
+//
+//	org:<glob>      organization, e.g. org:TheBloke
+//	repo:<glob>     repository id, e.g. repo:*/*-fp16*
+//	regex:<regexp>  repository id, e.g. regex:(?i)-(fp16|f32)
+//	file:<glob>     files in the snapshot, e.g. file:*-fp16*.gguf
+//	size:<op><size> repository size, e.g. size:>20G or size:<=500M
+//	format:<name>   gguf, mlx or unsupported
+//
+// Globs are case-insensitive. A model is excluded if it matches any exclude rule, or if
+// include rules are given and it matches none of them.
+//
+// Rules with file conditions act on files rather than whole models: an exclude rule leaves
+// the matching files out of the link and an include rule selects them. Such a rule only
+// excludes a model when no model weights would be left to link.
+package rules
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+)
+
+// NoIncludeRule is the reason given for models that match no include rule.
+const NoIncludeRule = "no include rule"
+
+// condition tests one property of a model.
+type condition func(m fsutils.ModelInfo, f *facts) bool
+
+// Rule is a parsed rule.
+type Rule struct {
+	Text       string
+	conditions []condition
+	// files holds the patterns of the file conditions, which a file must all match.
+	files []string
+}
+
+// Set holds the include and exclude rules.
+type Set struct {
+	Include []Rule
+	Exclude []Rule
+}
+
+// facts caches expensive properties of a model while its rules are evaluated.
+type facts struct {
+	files []string
+	size  int64
+	read  bool
+}
+
+// snapshotFiles returns the snapshot-relative file paths of the model.
+func (f *facts) snapshotFiles(m fsutils.ModelInfo) []string {
+	if f.files == nil {
+		f.files = []string{}
+		if _, snapPath, err := fsutils.ResolveSnapshot(m.SourcePath); err == nil {
+			filepath.WalkDir(snapPath, func(p string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					if rel, err := filepath.Rel(snapPath, p); err == nil {
+						f.files = append(f.files, filepath.ToSlash(rel))
+					}
+				}
+				return nil
+			})
+		}
+	}
+	return f.files
+}
+
+// Facts holds the snapshot files of models, read ahead of time so that rules can be
+// matched without touching the disk, e.g. on every keystroke of a search. Sizes are taken
+// from the models as they are, and models the facts were not loaded for have no files.
+// Facts are not changed after loading and may be shared between goroutines.
+type Facts struct {
+	files map[string][]string
+}
+
+// LoadFacts reads the snapshot files of models.
+func LoadFacts(models []fsutils.ModelInfo) *Facts {
+	known := &Facts{files: make(map[string][]string, len(models))}
+	for _, m := range models {
+		if m.SourcePath != "" && !m.IsStale {
+			f := &facts{}
+			known.files[m.SourcePath] = f.snapshotFiles(m)
+		}
+	}
+	return known
+}
+
+// of returns the facts of a model without reading the disk.
+func (known *Facts) of(m fsutils.ModelInfo) *facts {
+	files := known.files[m.SourcePath]
+	if files == nil {
+		files = []string{}
+	}
+	return &facts{files: files, size: m.Size, read: true}
+}
+
+// repoSize returns the model's size, measuring its blobs if it has not been sized yet.
+func (f *facts) repoSize(m fsutils.ModelInfo) int64 {
+	if !f.read {
+		f.read = true
+		f.size = m.Size
+		if f.size == 0 && m.SourcePath != "" {
+			f.size = fsutils.DirSize(filepath.Join(m.SourcePath, "blobs"))
+		}
+	}
+	return f.size
+}
+
+// Parse parses a rule.
+func Parse(text string) (Rule, error) {
+	rule := Rule{Text: text}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return rule, fmt.Errorf("empty rule")
+	}
+	for _, field := range fields {
+		name, value, ok := strings.Cut(field, ":")
+		if !ok || value == "" {
+			return rule, fmt.Errorf("rule %q: condition %q is not of the form kind:value", text, field)
+		}
+		if name == "file" {
+			pattern, err := globPattern(value)
+			if err != nil {
+				return rule, fmt.Errorf("rule %q: %v", text, err)
+			}
+			rule.files = append(rule.files, pattern)
+			continue
+		}
+		cond, err := parseCondition(name, value)
+		if err != nil {
+			return rule, fmt.Errorf("rule %q: %v", text, err)
+		}
+		rule.conditions = append(rule.conditions, cond)
+	}
+	return rule, nil
+}
+
+// parseCondition builds the condition for one kind:value pair.
+func parseCondition(name, value string) (condition, error) {
+	switch name {
+	case "org":
+		pattern, err := globPattern(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(m fsutils.ModelInfo, _ *facts) bool {
+			return globMatch(pattern, m.OrganizationName)
+		}, nil
+	case "repo":
+		pattern, err := globPattern(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(m fsutils.ModelInfo, _ *facts) bool {
+			return globMatch(pattern, m.RepoID())
+		}, nil
+	case "regex":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %v", value, err)
+		}
+		return func(m fsutils.ModelInfo, _ *facts) bool {
+			return re.MatchString(m.RepoID())
+		}, nil
+	case "size":
+		compare, err := parseSize(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(m fsutils.ModelInfo, f *facts) bool {
+			return compare(f.repoSize(m))
+		}, nil
+	case "format":
+		format := fsutils.ModelFormat(strings.ToLower(value))
+		switch format {
+		case fsutils.FormatGGUF, fsutils.FormatMLX, fsutils.FormatUnsupported:
+		default:
+			return nil, fmt.Errorf("unknown format %q (want gguf, mlx or unsupported)", value)
+		}
+		return func(m fsutils.ModelInfo, _ *facts) bool {
+			return m.Format == format
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown condition %q (want org, repo, regex, file, size or format)", name)
+}
+
+// globPattern validates a glob and lowercases it for case-insensitive matching.
+func globPattern(pattern string) (string, error) {
+	pattern = strings.ToLower(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return "", fmt.Errorf("invalid glob %q", pattern)
+	}
+	return pattern, nil
+}
+
+// globMatch matches a lowercased glob against a name case-insensitively.
+func globMatch(pattern, name string) bool {
+	ok, _ := path.Match(pattern, strings.ToLower(name))
+	return ok
+}
+
+// sizeUnits are the suffixes accepted by size conditions. Single letters are binary units
+// like the KiB, MiB and GiB FormatSize prints; KB, MB, GB and TB are decimal.
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kib": 1 << 10,
+	"kb":  1e3,
+	"m":   1 << 20,
+	"mib": 1 << 20,
+	"mb":  1e6,
+	"g":   1 << 30,
+	"gib": 1 << 30,
+	"gb":  1e9,
+	"t":   1 << 40,
+	"tib": 1 << 40,
+	"tb":  1e12,
+}
+
+// parseSize parses a comparison such as ">20G" into a predicate.
+func parseSize(value string) (func(int64) bool, error) {
+	op := ""
+	for _, candidate := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return nil, fmt.Errorf("size %q must start with <, <=, >, >= or =", value)
+	}
+	number := strings.TrimPrefix(value, op)
+	split := strings.IndexFunc(number, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	unit := ""
+	if split >= 0 {
+		number, unit = number[:split], strings.ToLower(number[split:])
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	multiplier, ok := sizeUnits[unit]
+	if err != nil || !ok {
+		return nil, fmt.Errorf("invalid size %q", value)
+	}
+	limit := int64(n * float64(multiplier))
+	switch op {
+	case ">=":
+		return func(size int64) bool { return size >= limit }, nil
+	case "<=":
+		return func(size int64) bool { return size <= limit }, nil
+	case ">":
+		return func(size int64) bool { return size > limit }, nil
+	case "<":
+		return func(size int64) bool { return size < limit }, nil
+	default:
+		return func(size int64) bool { return size == limit }, nil
+	}
+}
+
+// NewSet parses include and exclude rules.
+func NewSet(include, exclude []string) (*Set, error) {
+	s := &Set{}
+	for _, text := range include {
+		rule, err := Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("include: %v", err)
+		}
+		s.Include = append(s.Include, rule)
+	}
+	for _, text := range exclude {
+		rule, err := Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("exclude: %v", err)
+		}
+		s.Exclude = append(s.Exclude, rule)
+	}
+	return s, nil
+}
+
+// Empty reports whether the set has no rules.
+func (s *Set) Empty() bool {
+	return s == nil || len(s.Include)+len(s.Exclude) == 0
+}
+
+// matches reports whether all conditions of a rule hold for a model, with each file
+// pattern matching some file of its snapshot.
+func (r Rule) matches(m fsutils.ModelInfo, f *facts) bool {
+	if !r.holds(m, f) {
+		return false
+	}
+	for _, pattern := range r.files {
+		found := false
+		for _, file := range f.snapshotFiles(m) {
+			if fileMatch(pattern, file) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// holds reports whether the conditions of a rule other than its file patterns hold.
+func (r Rule) holds(m fsutils.ModelInfo, f *facts) bool {
+	for _, cond := range r.conditions {
+		if !cond(m, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesFile reports whether a rule with file conditions selects a file of a model.
+func (r Rule) matchesFile(m fsutils.ModelInfo, f *facts, file string) bool {
+	for _, pattern := range r.files {
+		if !fileMatch(pattern, file) {
+			return false
+		}
+	}
+	return r.holds(m, f)
+}
+
+// fileMatch matches a file pattern against a snapshot-relative path; patterns without a
+// slash also match base names.
+func fileMatch(pattern, file string) bool {
+	return globMatch(pattern, file) || (!strings.Contains(pattern, "/") && globMatch(pattern, path.Base(file)))
+}
+
+// Matches reports whether all conditions of the rule hold for a model.
+func (r Rule) Matches(m fsutils.ModelInfo) bool {
+	return r.matches(m, &facts{})
+}
+
+// MatchesFacts is Matches with the facts read ahead by LoadFacts; nil facts are read from
+// the disk as Matches does.
+func (r Rule) MatchesFacts(m fsutils.ModelInfo, known *Facts) bool {
+	if known == nil {
+		return r.Matches(m)
+	}
+	return r.matches(m, known.of(m))
+}
+
+// Evaluate reports whether a model is excluded and, if so, the rule responsible. Only
+// linkable model repositories with a source are evaluated.
+func (s *Set) Evaluate(m fsutils.ModelInfo) (bool, string) {
+	excluded, by, _ := s.decide(m)
+	return excluded, by
+}
+
+// decide evaluates the rules for a model. Besides the verdict it returns the snapshot
+// files left to link when file rules leave some out, or nil if all may be linked.
+func (s *Set) decide(m fsutils.ModelInfo) (bool, string, []string) {
+	if s.Empty() || !m.IsLinkable() || m.IsStale {
+		return false, "", nil
+	}
+	f := &facts{}
+	for _, rule := range s.Exclude {
+		if len(rule.files) == 0 && rule.matches(m, f) {
+			return true, rule.Text, nil
+		}
+	}
+	if !s.filesRuled() {
+		if len(s.Include) == 0 {
+			return false, "", nil
+		}
+		for _, rule := range s.Include {
+			if rule.matches(m, f) {
+				return false, "", nil
+			}
+		}
+		return true, NoIncludeRule, nil
+	}
+
+	files := f.snapshotFiles(m)
+	selected := files
+	if len(s.Include) > 0 {
+		selected = nil
+		for _, file := range files {
+			for _, rule := range s.Include {
+				if (len(rule.files) == 0 && rule.matches(m, f)) || (len(rule.files) > 0 && rule.matchesFile(m, f, file)) {
+					selected = append(selected, file)
+					break
+				}
+			}
+		}
+		if !hasWeights(m, selected) {
+			return true, NoIncludeRule, nil
+		}
+	}
+	var kept []string
+	by := ""
+	for _, file := range selected {
+		dropped := false
+		for _, rule := range s.Exclude {
+			if len(rule.files) > 0 && rule.matchesFile(m, f, file) {
+				dropped = true
+				if by == "" {
+					by = rule.Text
+				}
+				break
+			}
+		}
+		if !dropped {
+			kept = append(kept, file)
+		}
+	}
+	if by != "" && !hasWeights(m, kept) {
+		return true, by, nil
+	}
+	if len(kept) == len(files) {
+		return false, "", nil
+	}
+	return false, "", kept
+}
+
+// filesRuled reports whether any rule has file conditions.
+func (s *Set) filesRuled() bool {
+	for _, rule := range append(append([]Rule(nil), s.Include...), s.Exclude...) {
+		if len(rule.files) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasWeights reports whether a selection of a model's files still holds a model of its
+// format. Selections of unsupported models only need to be non-empty.
+func hasWeights(m fsutils.ModelInfo, files []string) bool {
+	if m.Format == fsutils.FormatGGUF || m.Format == fsutils.FormatMLX {
+		return fsutils.FormatOfFiles(files) == m.Format
+	}
+	return len(files) > 0
+}
+
+// Describe explains an ExcludedBy value for display, e.g. `excluded by rule "org:foo"`.
+func Describe(by string) string {
+	if by == NoIncludeRule {
+		return "matches no include rule"
+	}
+	return fmt.Sprintf("excluded by rule %q", by)
+}
+
+// Apply sets the excluded state and the files rules leave to link of every model.
+func (s *Set) Apply(models []fsutils.ModelInfo) {
+	for i := range models {
+		models[i].IsExcluded, models[i].ExcludedBy, models[i].RuleFiles = s.decide(models[i])
+	}
+}
@@ -0,0 +1,207 @@
+package rules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+)
+
+// model creates a cache repository with the given snapshot files and returns it as a
+// model of the given size.
+func model(t *testing.T, org, name string, size int64, files ...string) fsutils.ModelInfo {
+	t.Helper()
+	source := filepath.Join(t.TempDir(), "models--"+org+"--"+name)
+	snap := filepath.Join(source, "snapshots", "rev1")
+	for _, file := range files {
+		path := filepath.Join(snap, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fsutils.ModelInfo{
+		RepoType:         fsutils.RepoTypeModel,
+		OrganizationName: org,
+		ModelName:        name,
+		SourcePath:       source,
+		Format:           fsutils.FormatGGUF,
+		Size:             size,
+	}
+}
+
+// TestEvaluate tests each condition kind and the precedence of exclude over include rules.
+func TestEvaluate(t *testing.T) {
+	small := model(t, "bartowski", "Llama-3-8B-GGUF", 5<<30, "Llama-3-8B-Q4_K_M.gguf")
+	fp16 := model(t, "bartowski", "Llama-3-8B-fp16-GGUF", 16<<30, "Llama-3-8B-f16.gguf")
+	big := model(t, "TheBloke", "Mixtral-GGUF", 30<<30, "sub/mixtral-fp16.gguf")
+
+	cases := []struct {
+		name             string
+		include, exclude []string
+		want             map[string]string
+	}{
+		{"no rules", nil, nil, map[string]string{}},
+		{"org glob", nil, []string{"org:thebloke"}, map[string]string{"TheBloke/Mixtral-GGUF": "org:thebloke"}},
+		{"repo glob", nil, []string{"repo:*/*-fp16*"}, map[string]string{"bartowski/Llama-3-8B-fp16-GGUF": "repo:*/*-fp16*"}},
+		{"regex", nil, []string{"regex:Mixtral"}, map[string]string{"TheBloke/Mixtral-GGUF": "regex:Mixtral"}},
+		{"file pattern matches base names", nil, []string{"file:*fp16*.gguf"}, map[string]string{"TheBloke/Mixtral-GGUF": "file:*fp16*.gguf"}},
+		{"size", nil, []string{"size:>=16G"}, map[string]string{
+			"bartowski/Llama-3-8B-fp16-GGUF": "size:>=16G",
+			"TheBloke/Mixtral-GGUF":          "size:>=16G",
+		}},
+		{"conditions are combined", nil, []string{"org:bartowski size:>10G"}, map[string]string{
+			"bartowski/Llama-3-8B-fp16-GGUF": "org:bartowski size:>10G",
+		}},
+		{"include", []string{"org:bartowski"}, nil, map[string]string{"TheBloke/Mixtral-GGUF": NoIncludeRule}},
+		{"exclude wins", []string{"org:bartowski"}, []string{"format:gguf size:<8G"}, map[string]string{
+			"bartowski/Llama-3-8B-GGUF": "format:gguf size:<8G",
+			"TheBloke/Mixtral-GGUF":     NoIncludeRule,
+		}},
+	}
+	for _, tc := range cases {
+		set, err := NewSet(tc.include, tc.exclude)
+		if err != nil {
+			t.Fatalf("%s: NewSet returned error: %v", tc.name, err)
+		}
+		models := []fsutils.ModelInfo{small, fp16, big}
+		set.Apply(models)
+		for _, m := range models {
+			want, excluded := tc.want[m.RepoID()]
+			if m.IsExcluded != excluded || m.ExcludedBy != want {
+				t.Errorf("%s: %s expected excluded=%v by %q, got %v by %q", tc.name, m.RepoID(), excluded, want, m.IsExcluded, m.ExcludedBy)
+			}
+		}
+	}
+}
+
+// TestParseErrors tests that malformed rules are rejected.
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{"", "fp16", "colour:red", "regex:(", "size:20G", "size:>20Q", "format:safetensors", "org:[a"} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("expected error for rule %q", text)
+		}
+	}
+}
+
+// TestUnsizedModel tests that size rules measure models whose size is not known yet.
+func TestUnsizedModel(t *testing.T) {
+	m := model(t, "org", "name", 0)
+	blobs := filepath.Join(m.SourcePath, "blobs")
+	if err := os.MkdirAll(blobs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(blobs, "abc"), make([]byte, 2048), 0644); err != nil {
+		t.Fatal(err)
+	}
+	set, err := NewSet(nil, []string{"size:>1K"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if excluded, _ := set.Evaluate(m); !excluded {
+		t.Errorf("expected a 2K model to be excluded by size:>1K")
+	}
+}
+
+// TestFileRules tests that file conditions pick files of a model holding several
+// quantizations and exclude it only when no weights are left.
+func TestFileRules(t *testing.T) {
+	m := model(t, "bartowski", "Llama-3-8B-GGUF", 20<<30, "Llama-3-8B-fp16.gguf", "Llama-3-8B-Q4_K_M.gguf", "README.md")
+	onlyFP16 := model(t, "bartowski", "Llama-3-70B-GGUF", 140<<30, "fp16/Llama-3-70B-fp16.gguf", "README.md")
+
+	cases := []struct {
+		name             string
+		include, exclude []string
+		files            []string
+		excludedBy       string
+	}{
+		{"exclude leaves the other files", nil, []string{"file:*fp16*"}, []string{"Llama-3-8B-Q4_K_M.gguf", "README.md"}, ""},
+		{"include selects files", []string{"file:*Q4_K_M*"}, nil, []string{"Llama-3-8B-Q4_K_M.gguf"}, ""},
+		{"conditions narrow file rules", nil, []string{"org:thebloke file:*fp16*"}, nil, ""},
+		{"no weights left", nil, []string{"file:*.gguf"}, nil, "file:*.gguf"},
+		{"include without weights", []string{"file:README.md"}, nil, nil, NoIncludeRule},
+		{"model rules still exclude", []string{"file:*Q4*"}, []string{"size:>10G"}, nil, "size:>10G"},
+	}
+	for _, tc := range cases {
+		set, err := NewSet(tc.include, tc.exclude)
+		if err != nil {
+			t.Fatalf("%s: NewSet returned error: %v", tc.name, err)
+		}
+		models := []fsutils.ModelInfo{m}
+		set.Apply(models)
+		if !reflect.DeepEqual(models[0].RuleFiles, tc.files) || models[0].ExcludedBy != tc.excludedBy || models[0].IsExcluded != (tc.excludedBy != "") {
+			t.Errorf("%s: got files %v excluded=%v by %q, want %v by %q", tc.name, models[0].RuleFiles, models[0].IsExcluded, models[0].ExcludedBy, tc.files, tc.excludedBy)
+		}
+	}
+
+	set, _ := NewSet(nil, []string{"file:*fp16*"})
+	if excluded, by := set.Evaluate(onlyFP16); !excluded || by != "file:*fp16*" {
+		t.Errorf("expected a model with only fp16 weights to be excluded, got %v by %q", excluded, by)
+	}
+}
+
+// TestMatchesFacts tests that rules matched against facts read ahead do not read the disk
+// again and take sizes from the models.
+func TestMatchesFacts(t *testing.T) {
+	m := model(t, "org", "name", 5<<30, "sub/model-Q4_K_M.gguf")
+	file, err := Parse("file:*Q4_K_M*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := Parse("size:>4G")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	facts := LoadFacts([]fsutils.ModelInfo{m})
+	if err := os.RemoveAll(m.SourcePath); err != nil {
+		t.Fatal(err)
+	}
+	if !file.MatchesFacts(m, facts) || !size.MatchesFacts(m, facts) {
+		t.Errorf("expected the loaded facts to match")
+	}
+	if file.Matches(m) {
+		t.Errorf("expected Matches to read the removed snapshot")
+	}
+	if file.MatchesFacts(m, &Facts{}) {
+		t.Errorf("expected no files for a model the facts were not loaded for")
+	}
+	m.Size = 0
+	if size.MatchesFacts(m, facts) {
+		t.Errorf("expected an unsized model not to be measured")
+	}
+}
+
+// TestSizeUnits tests that single-letter and IEC suffixes are binary and KB, MB, GB and TB
+// decimal.
+func TestSizeUnits(t *testing.T) {
+	cases := []struct {
+		value string
+		size  int64
+		want  bool
+	}{
+		{">=1G", 1 << 30, true},
+		{">=1GiB", 1<<30 - 1, false},
+		{">=1GB", 1e9, true},
+		{">=1GB", 1e9 - 1, false},
+		{"<1G", 1e9, true},
+		{"=2KiB", 2048, true},
+		{"=2kb", 2000, true},
+		{">1.5T", 1.5 * (1 << 40), false},
+		{">1.5TB", 1.5e12 + 1, true},
+	}
+	for _, tc := range cases {
+		matches, err := parseSize(tc.value)
+		if err != nil {
+			t.Fatalf("parseSize(%q) returned error: %v", tc.value, err)
+		}
+		if got := matches(tc.size); got != tc.want {
+			t.Errorf("size:%s against %d = %v, want %v", tc.value, tc.size, got, tc.want)
+		}
+	}
+}
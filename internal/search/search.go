@@ -0,0 +1,193 @@
+// Package search matches models against the queries typed into the search box. A query is
+// a list of space-separated terms that must all match. Plain words are matched fuzzily
+// against the repository id, so "lam8b" finds "meta-llama/Llama-3-8B". Terms of the form
+// kind:value filter on model properties:
+//
+//	status:<state>   linked, unlinked, stale, outdated or excluded
+//	quant:<prefix>   a quantization type of the GGUF files, e.g. quant:Q4 or quant:IQ3_XXS
+//
+// and every condition of the rules package: org, repo, regex, file, size and format, e.g.
+// org:bartowski or size:<10G.
+package search
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+	"github.com/jmfirth/hf-lms-sync/internal/rules"
+)
+
+// Statuses are the states a status term accepts.
+var Statuses = []string{"linked", "unlinked", "stale", "outdated", "excluded"}
+
+// Query is a parsed search query.
+type Query struct {
+	Text     string
+	words    []string
+	statuses []string
+	quants   []string
+	rules    []rules.Rule
+	facts    *rules.Facts
+}
+
+// Parse parses a query. Terms without a value, like a half-typed "org:", are ignored.
+func Parse(text string) (Query, error) {
+	q := Query{Text: text}
+	for _, field := range strings.Fields(text) {
+		kind, value, ok := strings.Cut(field, ":")
+		if !ok {
+			q.words = append(q.words, field)
+			continue
+		}
+		if value == "" {
+			continue
+		}
+		switch strings.ToLower(kind) {
+		case "status":
+			status := strings.ToLower(value)
+			if !validStatus(status) {
+				return q, fmt.Errorf("unknown status %q (want %s)", value, strings.Join(Statuses, ", "))
+			}
+			q.statuses = append(q.statuses, status)
+		case "quant":
+			q.quants = append(q.quants, strings.ToUpper(value))
+		default:
+			rule, err := rules.Parse(field)
+			if err != nil {
+				return q, err
+			}
+			q.rules = append(q.rules, rule)
+		}
+	}
+	return q, nil
+}
+
+// validStatus reports whether status is one of Statuses.
+func validStatus(status string) bool {
+	for _, s := range Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// WithFacts returns the query matching file and size terms against facts read ahead
+// (see rules.LoadFacts) instead of the disk.
+func (q Query) WithFacts(facts *rules.Facts) Query {
+	q.facts = facts
+	return q
+}
+
+// Empty reports whether the query has no terms and so matches every model.
+func (q Query) Empty() bool {
+	return len(q.words)+len(q.statuses)+len(q.quants)+len(q.rules) == 0
+}
+
+// Match reports whether a model matches the query and, if it does, the positions of the
+// runes of its repository id matched by the words, in ascending order.
+func (q Query) Match(m fsutils.ModelInfo) (bool, []int) {
+	for _, status := range q.statuses {
+		if !MatchesStatus(m, status) {
+			return false, nil
+		}
+	}
+	for _, quant := range q.quants {
+		if !hasQuant(m, quant) {
+			return false, nil
+		}
+	}
+	for _, rule := range q.rules {
+		if !rule.MatchesFacts(m, q.facts) {
+			return false, nil
+		}
+	}
+	matched := map[int]bool{}
+	id := m.RepoID()
+	for _, word := range q.words {
+		positions := Fuzzy(word, id)
+		if positions == nil {
+			return false, nil
+		}
+		for _, p := range positions {
+			matched[p] = true
+		}
+	}
+	var positions []int
+	for i := range []rune(id) {
+		if matched[i] {
+			positions = append(positions, i)
+		}
+	}
+	return true, positions
+}
+
+// Filter returns the models matching the query.
+func (q Query) Filter(models []fsutils.ModelInfo) []fsutils.ModelInfo {
+	if q.Empty() {
+		return models
+	}
+	var filtered []fsutils.ModelInfo
+	for _, m := range models {
+		if ok, _ := q.Match(m); ok {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+// MatchesStatus reports whether a model is in a state. Unlinked models excluded by a rule
+// are excluded rather than unlinked.
+func MatchesStatus(m fsutils.ModelInfo, status string) bool {
+	switch status {
+	case "linked":
+		return m.IsLinked && !m.IsStale
+	case "unlinked":
+		return m.IsLinkable() && !m.IsLinked && !m.IsExcluded
+	case "stale":
+		return m.IsStale
+	case "outdated":
+		return m.IsOutdated
+	case "excluded":
+		return m.IsExcluded && !m.IsLinked
+	}
+	return false
+}
+
+// hasQuant reports whether any quantization type of the model starts with prefix.
+func hasQuant(m fsutils.ModelInfo, prefix string) bool {
+	for _, quant := range m.Quants {
+		if strings.HasPrefix(quant, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fuzzy matches pattern against text case-insensitively and returns the positions of the
+// matched runes of text, or nil if it does not match. A contiguous match is preferred;
+// otherwise the runes of pattern must appear in text in order, each as early as possible.
+func Fuzzy(pattern, text string) []int {
+	pattern, text = strings.ToLower(pattern), strings.ToLower(text)
+	p := []rune(pattern)
+	if start := strings.Index(text, pattern); start >= 0 {
+		start = utf8.RuneCountInString(text[:start])
+		positions := make([]int, len(p))
+		for i := range p {
+			positions[i] = start + i
+		}
+		return positions
+	}
+	positions := []int{}
+	for i, r := range []rune(text) {
+		if len(positions) < len(p) && r == p[len(positions)] {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) < len(p) {
+		return nil
+	}
+	return positions
+}
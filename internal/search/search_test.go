@@ -0,0 +1,92 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+)
+
+// model returns an unlinked GGUF model of the given size and quantization types.
+func model(org, name string, size int64, quants ...string) fsutils.ModelInfo {
+	return fsutils.ModelInfo{
+		RepoType:         fsutils.RepoTypeModel,
+		OrganizationName: org,
+		ModelName:        name,
+		Format:           fsutils.FormatGGUF,
+		Size:             size,
+		Quants:           quants,
+	}
+}
+
+// TestFuzzy tests contiguous, scattered and failed matches and their positions.
+func TestFuzzy(t *testing.T) {
+	cases := []struct {
+		pattern, text string
+		expected      []int
+	}{
+		{"llama", "meta-llama/Llama-3-8B", []int{5, 6, 7, 8, 9}},
+		{"LLAMA-3", "meta-llama/Llama-3-8B", []int{11, 12, 13, 14, 15, 16, 17}},
+		{"ml8b", "meta-llama/Llama-3-8B", []int{0, 5, 19, 20}},
+		{"b8", "meta-llama/Llama-3-8B", nil},
+		{"é", "org/modèle-é", []int{11}},
+		{"", "anything", []int{}},
+	}
+	for _, tc := range cases {
+		if got := Fuzzy(tc.pattern, tc.text); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Fuzzy(%q, %q) = %v, want %v", tc.pattern, tc.text, got, tc.expected)
+		}
+	}
+}
+
+// TestQuery tests that every term must match and that words report their positions.
+func TestQuery(t *testing.T) {
+	small := model("bartowski", "Llama-3-8B-GGUF", 5<<30, "Q4_K_M", "Q8_0")
+	large := model("bartowski", "Llama-3-70B-GGUF", 40<<30, "Q4_K_M")
+	linked := model("TheBloke", "Mistral-7B-GGUF", 4<<30, "Q5_K_S")
+	linked.IsLinked = true
+	models := []fsutils.ModelInfo{small, large, linked}
+
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{"bartowski/Llama-3-8B-GGUF", "bartowski/Llama-3-70B-GGUF", "TheBloke/Mistral-7B-GGUF"}},
+		{"org:bartowski quant:Q4 size:<10G status:unlinked", []string{"bartowski/Llama-3-8B-GGUF"}},
+		{"quant:q8", []string{"bartowski/Llama-3-8B-GGUF"}},
+		{"status:linked", []string{"TheBloke/Mistral-7B-GGUF"}},
+		{"lama 70", []string{"bartowski/Llama-3-70B-GGUF"}},
+		{"mistral org:", []string{"TheBloke/Mistral-7B-GGUF"}},
+		{"format:mlx", nil},
+	}
+	for _, tc := range cases {
+		q, err := Parse(tc.query)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.query, err)
+		}
+		var got []string
+		for _, m := range q.Filter(models) {
+			got = append(got, m.RepoID())
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%q: got %v, want %v", tc.query, got, tc.expected)
+		}
+	}
+
+	q, _ := Parse("70 bart")
+	if ok, positions := q.Match(large); !ok || !reflect.DeepEqual(positions, []int{0, 1, 2, 3, 18, 19}) {
+		t.Errorf("expected a match at [0 1 2 3 18 19], got %v %v", ok, positions)
+	}
+	if ok, positions := q.Match(linked); ok || positions != nil {
+		t.Errorf("expected no match, got %v", positions)
+	}
+}
+
+// TestParseErrors tests that unknown states and conditions are rejected.
+func TestParseErrors(t *testing.T) {
+	for _, query := range []string{"status:broken", "color:red", "size:10G"} {
+		if _, err := Parse(query); err == nil {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+}
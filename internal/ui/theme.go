@@ -0,0 +1,167 @@
+// internal/ui/theme.go
+package ui
+
+import (
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jmfirth/hf-lms-sync/internal/config"
+	"github.com/jmfirth/hf-lms-sync/internal/desired"
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+)
+
+// activeTheme maps theme color names to colors; it starts out as the built-in theme
+var activeTheme = copyTheme(config.DefaultTheme)
+
+// Styles that depend on the theme, rebuilt by applyTheme
+var (
+	titleStyle   lipgloss.Style
+	infoStyle    lipgloss.Style
+	statusStyle  lipgloss.Style
+	confirmStyle lipgloss.Style
+	failureStyle lipgloss.Style
+	markedStyle  lipgloss.Style
+	driftStyles  map[desired.ActionKind]lipgloss.Style
+	// detailPaneStyle separates the detail pane from the list
+	detailPaneStyle lipgloss.Style
+	healthStyles    map[fsutils.FileHealth]lipgloss.Style
+)
+
+func init() {
+	buildStyles()
+}
+
+// copyTheme returns a copy of a theme
+func copyTheme(theme map[string]string) map[string]string {
+	out := make(map[string]string, len(theme))
+	for name, value := range theme {
+		out[name] = value
+	}
+	return out
+}
+
+// color returns the themed color for a name
+func color(name string) lipgloss.Color {
+	return lipgloss.Color(activeTheme[name])
+}
+
+// applyTheme overrides theme colors and rebuilds the styles. Unknown names are ignored.
+func applyTheme(theme map[string]string) {
+	for name, value := range theme {
+		if _, ok := activeTheme[name]; ok && value != "" {
+			activeTheme[name] = value
+		}
+	}
+	buildStyles()
+}
+
+// buildStyles creates the theme-dependent styles - widths are updated when we get the window size
+func buildStyles() {
+	titleStyle = lipgloss.NewStyle().
+		Bold(true).
+		Foreground(color("text")).
+		Background(color("accent")).
+		PaddingLeft(2).
+		PaddingRight(2)
+
+	infoStyle = lipgloss.NewStyle().
+		Foreground(color("text")).
+		Background(color("info")).
+		PaddingLeft(1).
+		PaddingRight(1)
+
+	statusStyle = lipgloss.NewStyle().
+		Foreground(color("text")).
+		Background(color("status")).
+		PaddingLeft(1).
+		PaddingRight(1)
+
+	confirmStyle = lipgloss.NewStyle().
+		Bold(true).
+		Foreground(color("stale")).
+		Background(color("status"))
+
+	failureStyle = lipgloss.NewStyle().Foreground(color("corrupt"))
+
+	markedStyle = lipgloss.NewStyle().Foreground(color("accent")).Bold(true)
+
+	detailPaneStyle = lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder(), false, false, false, true).
+		BorderForeground(color("muted")).
+		PaddingLeft(1)
+
+	healthStyles = map[fsutils.FileHealth]lipgloss.Style{
+		fsutils.HealthOK:       lipgloss.NewStyle().Foreground(color("linked")),
+		fsutils.HealthMissing:  lipgloss.NewStyle().Foreground(color("corrupt")),
+		fsutils.HealthMismatch: lipgloss.NewStyle().Foreground(color("corrupt")),
+		fsutils.HealthSkipped:  lipgloss.NewStyle().Foreground(color("muted")),
+		fsutils.HealthNoBlob:   lipgloss.NewStyle().Foreground(color("incomplete")),
+	}
+
+	driftStyles = map[desired.ActionKind]lipgloss.Style{
+		desired.ActionOK:          lipgloss.NewStyle().Foreground(color("muted")),
+		desired.ActionLink:        lipgloss.NewStyle().Foreground(color("linked")),
+		desired.ActionRelink:      lipgloss.NewStyle().Foreground(color("incomplete")),
+		desired.ActionUnlink:      lipgloss.NewStyle().Foreground(color("unlinked")),
+		desired.ActionUnavailable: lipgloss.NewStyle().Foreground(color("stale")),
+	}
+}
+
+// bindings returns the keymap's bindings by their config action name
+func (k *keyMap) bindings() map[string]*key.Binding {
+	return map[string]*key.Binding{
+		"up":          &k.Up,
+		"down":        &k.Down,
+		"home":        &k.Home,
+		"end":         &k.End,
+		"search":      &k.Search,
+		"link":        &k.Link,
+		"unlink":      &k.Unlink,
+		"purge":       &k.Purge,
+		"link_all":    &k.LinkAll,
+		"unlink_all":  &k.UnlinkAll,
+		"purge_all":   &k.PurgeAll,
+		"prune":       &k.Prune,
+		"delete":      &k.Delete,
+		"format":      &k.Format,
+		"status":      &k.Status,
+		"sort":        &k.Sort,
+		"details":     &k.Details,
+		"detail_up":   &k.DetailUp,
+		"detail_down": &k.DetailDown,
+		"verify":      &k.Verify,
+		"verify_all":  &k.VerifyAll,
+		"drift":       &k.Drift,
+		"apply":       &k.Apply,
+		"undo":        &k.Undo,
+		"cancel":      &k.Cancel,
+		"errors":      &k.Errors,
+		"retry":       &k.Retry,
+		"retry_all":   &k.RetryAll,
+		"rescan":      &k.Rescan,
+		"mark":        &k.Mark,
+		"mark_range":  &k.MarkRange,
+		"mark_all":    &k.MarkAll,
+		"toggle_help": &k.ToggleHelp,
+		"quit":        &k.Quit,
+	}
+}
+
+// rebind replaces the keys of the named actions, keeping their help descriptions. Actions
+// with no keys keep their defaults. The space bar can be given as "space".
+func (k *keyMap) rebind(actions map[string][]string) {
+	for name, binding := range k.bindings() {
+		keys := actions[name]
+		if len(keys) == 0 {
+			continue
+		}
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			names[i] = key
+			if key == "space" {
+				names[i] = " "
+			}
+		}
+		binding.SetKeys(names...)
+		binding.SetHelp(keys[0], binding.Help().Desc)
+	}
+}
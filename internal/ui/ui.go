@@ -2,8 +2,12 @@
 package ui
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -11,12 +15,20 @@ import (
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/jmfirth/hf-lms-sync/internal/desired"
 	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+	"github.com/jmfirth/hf-lms-sync/internal/journal"
+	"github.com/jmfirth/hf-lms-sync/internal/lock"
 	"github.com/jmfirth/hf-lms-sync/internal/logger"
+	"github.com/jmfirth/hf-lms-sync/internal/rules"
+	"github.com/jmfirth/hf-lms-sync/internal/search"
+	"github.com/jmfirth/hf-lms-sync/internal/watch"
 )
 
 // Default size used for initialization before WindowSizeMsg is received
@@ -38,6 +50,27 @@ type keyMap struct {
 	LinkAll    key.Binding
 	UnlinkAll  key.Binding
 	PurgeAll   key.Binding
+	Prune      key.Binding
+	Delete     key.Binding
+	Format     key.Binding
+	Status     key.Binding
+	Sort       key.Binding
+	Details    key.Binding
+	DetailUp   key.Binding
+	DetailDown key.Binding
+	Verify     key.Binding
+	VerifyAll  key.Binding
+	Drift      key.Binding
+	Apply      key.Binding
+	Undo       key.Binding
+	Cancel     key.Binding
+	Errors     key.Binding
+	Retry      key.Binding
+	RetryAll   key.Binding
+	Rescan     key.Binding
+	Mark       key.Binding
+	MarkRange  key.Binding
+	MarkAll    key.Binding
 	ToggleHelp key.Binding
 	Quit       key.Binding
 }
@@ -51,9 +84,16 @@ func (k keyMap) ShortHelp() []key.Binding {
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.Up, k.Down, k.Home, k.End},
+		{k.Mark, k.MarkRange, k.MarkAll},
 		{k.Link, k.Unlink, k.Purge},
 		{k.LinkAll, k.UnlinkAll, k.PurgeAll},
-		{k.Search, k.ToggleHelp, k.Quit},
+		{k.Prune, k.Delete, k.Undo, k.Cancel},
+		{k.Errors, k.Retry, k.RetryAll},
+		{k.Verify, k.VerifyAll},
+		{k.Drift, k.Apply},
+		{k.Search, k.Format, k.Status, k.Sort},
+		{k.Details, k.DetailUp, k.DetailDown},
+		{k.Rescan, k.ToggleHelp, k.Quit},
 	}
 }
 
@@ -103,12 +143,96 @@ var keys = keyMap{
 		key.WithKeys("C"),
 		key.WithHelp("C", "purge all"),
 	),
+	Prune: key.NewBinding(
+		key.WithKeys("P"),
+		key.WithHelp("P", "prune cache"),
+	),
+	Delete: key.NewBinding(
+		key.WithKeys("X"),
+		key.WithHelp("X", "delete from cache"),
+	),
+	Format: key.NewBinding(
+		key.WithKeys("f"),
+		key.WithHelp("f", "filter format"),
+	),
+	Status: key.NewBinding(
+		key.WithKeys("s"),
+		key.WithHelp("s", "filter status"),
+	),
+	Sort: key.NewBinding(
+		key.WithKeys("o"),
+		key.WithHelp("o", "sort order"),
+	),
+	Details: key.NewBinding(
+		key.WithKeys("i"),
+		key.WithHelp("i", "details"),
+	),
+	DetailUp: key.NewBinding(
+		key.WithKeys("K", "shift+up"),
+		key.WithHelp("K", "scroll details up"),
+	),
+	DetailDown: key.NewBinding(
+		key.WithKeys("J", "shift+down"),
+		key.WithHelp("J", "scroll details down"),
+	),
+	Verify: key.NewBinding(
+		key.WithKeys("v"),
+		key.WithHelp("v", "verify"),
+	),
+	VerifyAll: key.NewBinding(
+		key.WithKeys("V"),
+		key.WithHelp("V", "verify all"),
+	),
+	Drift: key.NewBinding(
+		key.WithKeys("D"),
+		key.WithHelp("D", "drift view"),
+	),
+	Apply: key.NewBinding(
+		key.WithKeys("a"),
+		key.WithHelp("a", "apply desired state"),
+	),
+	Undo: key.NewBinding(
+		key.WithKeys("z"),
+		key.WithHelp("z", "undo"),
+	),
+	Cancel: key.NewBinding(
+		key.WithKeys("esc"),
+		key.WithHelp("esc", "cancel operation"),
+	),
+	Errors: key.NewBinding(
+		key.WithKeys("e"),
+		key.WithHelp("e", "failed steps"),
+	),
+	Retry: key.NewBinding(
+		key.WithKeys("r"),
+		key.WithHelp("r", "retry selected"),
+	),
+	RetryAll: key.NewBinding(
+		key.WithKeys("R"),
+		key.WithHelp("R", "retry all"),
+	),
+	Rescan: key.NewBinding(
+		key.WithKeys("ctrl+r"),
+		key.WithHelp("ctrl+r", "rescan"),
+	),
+	Mark: key.NewBinding(
+		key.WithKeys(" "),
+		key.WithHelp("space", "select"),
+	),
+	MarkRange: key.NewBinding(
+		key.WithKeys("m"),
+		key.WithHelp("m", "select range"),
+	),
+	MarkAll: key.NewBinding(
+		key.WithKeys("ctrl+a"),
+		key.WithHelp("ctrl+a", "select all shown"),
+	),
 	ToggleHelp: key.NewBinding(
 		key.WithKeys("?"),
 		key.WithHelp("?", "toggle help"),
 	),
 	Quit: key.NewBinding(
-		key.WithKeys("q", "ctrl+c", "esc"),
+		key.WithKeys("q", "ctrl+c"),
 		key.WithHelp("q", "quit"),
 	),
 }
@@ -118,32 +242,32 @@ var (
 	appStyle = lipgloss.NewStyle().
 		Padding(1, 2)
 
-	// Initialize styles - widths will be updated when we get window size
-	titleStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color("#FFFFFF")).
-			Background(lipgloss.Color("#7D56F4")).
-			PaddingLeft(2).
-			PaddingRight(2)
-
-	infoStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#FFFFFF")).
-			Background(lipgloss.Color("#3B82F6")).
-			PaddingLeft(1).
-			PaddingRight(1)
-
-	statusStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#FFFFFF")).
-			Background(lipgloss.Color("#333333")).
-			PaddingLeft(1).
-			PaddingRight(1)
+	driftIcons = map[desired.ActionKind]string{
+		desired.ActionOK:          "=",
+		desired.ActionLink:        "+",
+		desired.ActionRelink:      "~",
+		desired.ActionUnlink:      "-",
+		desired.ActionUnavailable: "!",
+	}
 )
 
+// confirmation is a pending yes/no question shown in the status bar
+type confirmation struct {
+	message   string
+	progress  string
+	// onConfirm returns the command to run; ctx is cancelled by the cancel key
+	onConfirm func(ctx context.Context) tea.Cmd
+}
+
 // modelItem represents a list item for the BubbleTea list component
 type modelItem struct {
 	model         fsutils.ModelInfo
 	titleWidth    int
 	selectedWidth int
+	sized         bool
+	marked        bool
+	// matches are the rune positions of the title matched by the search
+	matches       []int
 }
 
 // FilterValue implements list.Item interface
@@ -153,17 +277,46 @@ func (i modelItem) FilterValue() string {
 
 // Title implements list.Item interface
 func (i modelItem) Title() string {
-	return fmt.Sprintf("%s/%s", i.model.OrganizationName, i.model.ModelName)
+	return i.model.RepoID()
 }
 
 // Description implements list.Item interface
 func (i modelItem) Description() string {
+	var status string
+	if !i.model.IsModelRepo() {
+		return repoTypeLabel(i.model.RepoType) + " - not linkable"
+	}
+	if !i.model.IsLinkable() {
+		return "Not linkable - " + i.model.NotLinkableReason() + " · " + i.model.Format.String()
+	}
 	if i.model.IsStale {
 		return "Stale - " + i.model.StaleReason
+	} else if i.model.IsExcluded && !i.model.IsLinked {
+		status = "Excluded - " + rules.Describe(i.model.ExcludedBy)
+	} else if i.model.IsIncomplete {
+		status = "Downloading/incomplete - " + i.model.IncompleteReason
+	} else if i.model.IsCorrupt {
+		status = "Corrupt - " + i.model.CorruptReason
+	} else if i.model.IsLinked && i.model.IsOutdated {
+		status = "Outdated - " + i.model.OutdatedReason
 	} else if i.model.IsLinked {
-		return "Linked"
+		status = "Linked"
+	} else {
+		status = "Not linked"
+	}
+	return status + " · " + i.model.Format.String()
+}
+
+// repoTypeLabel returns a capitalized label for a repo type
+func repoTypeLabel(t fsutils.RepoType) string {
+	switch t {
+	case fsutils.RepoTypeDataset:
+		return "Dataset"
+	case fsutils.RepoTypeSpace:
+		return "Space"
+	default:
+		return "Model"
 	}
-	return "Not linked"
 }
 
 // itemDelegate implements list.ItemDelegate interface
@@ -201,9 +354,21 @@ func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list
 	var statusIcon string
 	var statusStyle lipgloss.Style
 
-	if item.model.IsStale {
+	if !item.model.IsLinkable() {
+		statusStyle = d.styles["desc"]
+		statusIcon = "·"
+	} else if item.model.IsStale {
 		statusStyle = d.styles["stale"]
 		statusIcon = "⦿"
+	} else if item.model.IsIncomplete {
+		statusStyle = d.styles["incomplete"]
+		statusIcon = "◌"
+	} else if item.model.IsCorrupt {
+		statusStyle = d.styles["corrupt"]
+		statusIcon = "✗"
+	} else if item.model.IsExcluded && !item.model.IsLinked {
+		statusStyle = d.styles["excluded"]
+		statusIcon = "⊘"
 	} else if item.model.IsLinked {
 		statusStyle = d.styles["linked"]
 		statusIcon = "⦿"
@@ -214,6 +379,10 @@ func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list
 
 	isSelected := index == m.Index()
 	titleStr := item.Title()
+	mark := " "
+	if item.marked {
+		mark = d.styles["marked"].Render("✓")
+	}
 	
 	var (
 		prefix, line string
@@ -222,47 +391,110 @@ func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list
 
 	if isSelected {
 		prefix = d.selectedPrefix
-		title = d.styles["selectedTitle"].Render(titleStr)
+		title = d.highlight(titleStr, item.matches, d.styles["selectedTitle"])
 		desc = d.styles["selectedDesc"].Render(item.Description())
+	} else if item.model.IsExcluded && !item.model.IsLinked {
+		// Excluded models are greyed out entirely
+		prefix = d.unselectedPrefix
+		title = d.highlight(titleStr, item.matches, d.styles["excluded"])
+		desc = d.styles["excluded"].Render(item.Description())
 	} else {
 		prefix = d.unselectedPrefix
-		title = d.styles["title"].Render(titleStr)
+		title = d.highlight(titleStr, item.matches, d.styles["title"])
 		desc = d.styles["desc"].Render(item.Description())
 	}
 
-	line = fmt.Sprintf("%s %s %s %s", prefix, statusStyle.Render(statusIcon), title, desc)
+	size, linkedSize := "…", "…"
+	if item.sized {
+		size = fsutils.FormatSize(item.model.Size)
+		linkedSize = "-"
+		if item.model.IsLinked && !item.model.IsStale {
+			linkedSize = fsutils.FormatSize(item.model.LinkedSize)
+		}
+	}
+	sizes := d.styles["desc"].Render(fmt.Sprintf("%9s %9s", size, linkedSize))
+
+	line = fmt.Sprintf("%s%s %s %s  %s %s", prefix, mark, statusStyle.Render(statusIcon), sizes, title, desc)
 	fmt.Fprint(w, line)
 }
 
+// highlight renders text in style, with the runes at the given positions in the match
+// style on top of it
+func (d itemDelegate) highlight(text string, positions []int, style lipgloss.Style) string {
+	if len(positions) == 0 {
+		return style.Render(text)
+	}
+	match := d.styles["match"].Copy().Inherit(style)
+	matched := make(map[int]bool, len(positions))
+	for _, p := range positions {
+		matched[p] = true
+	}
+	// Runs of matched and unmatched runes are rendered together
+	var b strings.Builder
+	runes := []rune(text)
+	for start := 0; start < len(runes); {
+		end := start + 1
+		for end < len(runes) && matched[end] == matched[start] {
+			end++
+		}
+		if matched[start] {
+			b.WriteString(match.Render(string(runes[start:end])))
+		} else {
+			b.WriteString(style.Render(string(runes[start:end])))
+		}
+		start = end
+	}
+	return b.String()
+}
+
 // newItemDelegate creates a new item delegate with custom styling
 func newItemDelegate() itemDelegate {
 	// Define styles
 	d := itemDelegate{
 		styles: map[string]lipgloss.Style{
 			"title": lipgloss.NewStyle().
-				Foreground(lipgloss.Color("#FFFFFF")),
+				Foreground(color("text")),
 			
 			"selectedTitle": lipgloss.NewStyle().
-				Foreground(lipgloss.Color("#FFFFFF")).
+				Foreground(color("text")).
 				Bold(true),
 			
 			"desc": lipgloss.NewStyle().
-				Foreground(lipgloss.Color("#888888")),
+				Foreground(color("muted")),
 			
 			"selectedDesc": lipgloss.NewStyle().
 				Foreground(lipgloss.Color("#DDDDDD")),
 			
 			"linked": lipgloss.NewStyle().
-				Foreground(lipgloss.Color("#48BB78")),  // Green
+				Foreground(color("linked")),
 			
 			"unlinked": lipgloss.NewStyle().
-				Foreground(lipgloss.Color("#F6AD55")), // Yellow
+				Foreground(color("unlinked")),
 			
 			"stale": lipgloss.NewStyle().
-				Foreground(lipgloss.Color("#F56565")), // Red
+				Foreground(color("stale")),
+			
+			"incomplete": lipgloss.NewStyle().
+				Foreground(color("incomplete")),
+			
+			"corrupt": lipgloss.NewStyle().
+				Foreground(color("corrupt")).
+				Bold(true),
+			
+			"excluded": lipgloss.NewStyle().
+				Foreground(color("excluded")).
+				Faint(true),
+			
+			"marked": lipgloss.NewStyle().
+				Foreground(color("accent")).
+				Bold(true),
+			
+			"match": lipgloss.NewStyle().
+				Foreground(color("accent")).
+				Underline(true),
 		},
-		shortHelpStyle:       lipgloss.NewStyle().Foreground(lipgloss.Color("#888888")),
-		fullHelpStyle:        lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFFFF")),
+		shortHelpStyle:       lipgloss.NewStyle().Foreground(color("muted")),
+		fullHelpStyle:        lipgloss.NewStyle().Foreground(color("text")),
 		selectedPrefix:       "›",
 		unselectedPrefix:     " ",
 		statusMessageLiftime: time.Second * 5,
@@ -284,6 +516,9 @@ type model struct {
 	keymap        keyMap
 	spinner       spinner.Model
 	searchInput   textinput.Model
+	progress      progress.Model
+	driftView     viewport.Model
+	detailView    viewport.Model
 	
 	// UI state
 	width         int
@@ -292,27 +527,83 @@ type model struct {
 	showFullHelp  bool
 	status        string
 	targetDir     string
+	extraTargets  []string
 	searching     bool
 	loading       bool
+	formatFilter  fsutils.ModelFormat
+	statusFilter  string
+	sortKey       string
+	sizesLoaded   bool
+	sizeCache     *fsutils.SizeCache
+	confirm       *confirmation
+	verifyCache   *fsutils.VerifyCache
+	verifying     bool
+	verifyState   fsutils.VerifyProgress
+	desiredFile   string
+	linkMode      fsutils.LinkMode
+	drift         *driftMsg
+	rules         *rules.Set
+	journal       *journal.Journal
+	workers       int
+	cancel        context.CancelFunc
+	verifyCancel  context.CancelFunc
+	watchCtx      context.Context
+	stopWatch     context.CancelFunc
+	quitting      bool
+	bulk          *bulkState
+	failures      []bulkFailure
+	showErrors    bool
+	errorCursor   int
+	scanning      bool
+	scanErr       *scanErrorMsg
+	index         *fsutils.Index
+	marked        map[string]bool
+	markAnchor    string
+	// query is the parsed search box, queryErr why it does not parse
+	query         search.Query
+	queryErr      error
+	// facts are the snapshot files of the models read for the scan numbered scans, so
+	// that searching for files does not read the disk
+	facts         *rules.Facts
+	scans         int
+	showDetails   bool
+	// detailFor identifies the model and state whose details were last asked for, and
+	// detailShown the model whose details are in the pane
+	detailFor     string
+	detailShown   string
 	
 	// Logging
 	logger        *logger.Logger
 }
 
+// Options configures the UI
+type Options struct {
+	// TargetDir is the LM Studio models directory the UI manages
+	TargetDir string
+	// ExtraTargets are other LM Studio models directories that may link the same models;
+	// deleting a model from the cache unlinks it from these as well
+	ExtraTargets []string
+	// DesiredFile is the desired-state file shown in the drift view; empty looks for
+	// models.toml or models.yaml
+	DesiredFile string
+	// LinkMode is how models are linked from the UI
+	LinkMode fsutils.LinkMode
+	// Theme overrides colors by name (see config.DefaultTheme)
+	Theme map[string]string
+	// KeyBindings overrides the keys of actions by name (see config.DefaultKeys)
+	KeyBindings map[string][]string
+	// Rules are the include and exclude rules; excluded models are skipped by "link all"
+	Rules *rules.Set
+	// Workers is how many models bulk operations link or unlink at once
+	Workers int
+}
+
 // New creates and returns a new UI model
-func New(targetDir string, appLogger *logger.Logger) tea.Model {
-	// Load models
-	models, _ := fsutils.LoadModels(targetDir)
-	stale, _ := fsutils.FindStaleLinks(targetDir)
-	combined := append(models, stale...)
-	sort.Slice(combined, func(i, j int) bool {
-		return combined[i].CacheDirName < combined[j].CacheDirName
-	})
-	
-	if appLogger != nil && appLogger.Verbose {
-		appLogger.Info("UI", "Initializing UI with %d models and %d stale references", len(models), len(stale))
-	}
-	
+func New(opts Options, appLogger *logger.Logger) tea.Model {
+	targetDir := opts.TargetDir
+	applyTheme(opts.Theme)
+	keys.rebind(opts.KeyBindings)
+
 	// Set up the list
 	delegate := newItemDelegate()
 	modelsList := list.New([]list.Item{}, delegate, defaultWidth, defaultHeight-7)
@@ -322,17 +613,10 @@ func New(targetDir string, appLogger *logger.Logger) tea.Model {
 	modelsList.SetShowHelp(false)
 	modelsList.SetStatusBarItemName("model", "models")
 	
-	// Convert models to list items
-	var items []list.Item
-	for _, m := range combined {
-		items = append(items, modelItem{model: m})
-	}
-	modelsList.SetItems(items)
-	
 	// Set up spinner for loading state
 	s := spinner.New()
 	s.Spinner = spinner.Dot
-	s.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4"))
+	s.Style = lipgloss.NewStyle().Foreground(color("accent"))
 	
 	// Set up help
 	h := help.New()
@@ -340,33 +624,188 @@ func New(targetDir string, appLogger *logger.Logger) tea.Model {
 	
 	// Set up search input
 	ti := textinput.New()
-	ti.Placeholder = "Search..."
-	ti.CharLimit = 32
-	ti.Width = 30
+	ti.Placeholder = "Search... (org: quant: size: status:)"
+	ti.CharLimit = 256
+	ti.Width = 50
 	
+	// Set up the verification progress bar
+	p := progress.New(progress.WithDefaultGradient(), progress.WithWidth(30))
+	
+	// Rules are evaluated whenever the background commands read the index, since file and
+	// size conditions read the disk
+	index := fsutils.NewIndex(targetDir)
+	index.Annotate(opts.Rules.Apply)
+	
+	// The watch runs until the UI quits
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	
+	// Models are scanned by Init so that a large cache does not delay the first frame
 	return model{
-		models:      models,
-		stale:       stale,
-		combined:    combined,
 		list:        modelsList,
 		help:        h,
 		keymap:      keys,
 		spinner:     s,
 		searchInput: ti,
-		status:      fmt.Sprintf("Found %d model(s) and %d stale reference(s).", len(models), len(stale)),
+		progress:    p,
+		driftView:   viewport.New(defaultWidth, defaultHeight-7),
+		detailView:  viewport.New(defaultWidth/2, defaultHeight-7),
+		status:      "Scanning...",
+		scanning:    true,
+		loading:     true,
 		targetDir:   targetDir,
+		extraTargets: opts.ExtraTargets,
+		desiredFile: opts.DesiredFile,
+		linkMode:    opts.LinkMode,
+		rules:       opts.Rules,
+		journal:     journal.OpenDefault(),
+		index:       index,
+		watchCtx:    watchCtx,
+		facts:       &rules.Facts{},
+		stopWatch:   stopWatch,
+		marked:      map[string]bool{},
+		workers:     opts.Workers,
+		sizeCache:   fsutils.LoadDefaultSizeCache(),
+		verifyCache: fsutils.LoadDefaultVerifyCache(),
 		logger:      appLogger,
 	}
 }
 
+// quit stops watching for changes and ends the program
+func (m model) quit() (tea.Model, tea.Cmd) {
+	m.stopWatch()
+	return m, tea.Quit
+}
+
 // Init initializes the model
 func (m model) Init() tea.Cmd {
 	return tea.Batch(
 		tea.EnterAltScreen,
 		m.spinner.Tick,
+		scanCmd(m.index, m.journal, m.logger),
+		watchCmd(m.watchCtx, m.index.TargetDir(), m.logger),
 	)
 }
 
+// scanErrorMsg reports a scan that failed, with suggestions for fixing it
+type scanErrorMsg struct {
+	err  error
+	help []string
+}
+
+// scanCmd scans the Hugging Face cache and the target directory in the background. The
+// status offers to roll back operations that a crash interrupted; while another instance
+// holds the lock its operations are still running, not interrupted.
+func scanCmd(ix *fsutils.Index, j *journal.Journal, logger *logger.Logger) tea.Cmd {
+	return func() tea.Msg {
+		targetDir := ix.TargetDir()
+		err := ix.Scan()
+		if err == nil {
+			models, stale := ix.Models()
+			if logger != nil && logger.Verbose {
+				logger.Info("UI", "Scanned %d models and %d stale references", len(models), len(stale))
+			}
+			status := fmt.Sprintf("Found %d model(s) and %d stale reference(s).", len(models), len(stale))
+			caches, _ := fsutils.GetHfCacheDirs()
+			if err := lock.Status(append([]string{targetDir}, caches...)...); err != nil {
+				status = lockMessage(targetDir, err) + " Changes are blocked until it finishes."
+			} else if interrupted, _ := j.Interrupted(); len(interrupted) > 0 {
+				status = fmt.Sprintf("Interrupted operation: %s. Press %s to roll it back or run `hf-lms-sync resume`.", interrupted[0].Describe(), keys.Undo.Help().Key)
+			}
+			return opResultMsg{status: status, models: models, stale: stale}
+		}
+		if logger != nil && logger.Verbose {
+			logger.Error("UI", "Error scanning models: %v", err)
+		}
+		return scanErrorMsg{err: err, help: scanHelp(targetDir)}
+	}
+}
+
+// scanHelp suggests how to fix a failed scan
+func scanHelp(targetDir string) []string {
+	var help []string
+	if hfCache, err := fsutils.GetHfCacheDir(); err != nil {
+		help = append(help, "The Hugging Face cache location could not be determined; pass it with --hf-cache or set hf_cache in the config file.")
+	} else if info, err := os.Stat(hfCache); err != nil || !info.IsDir() {
+		help = append(help, "No Hugging Face cache at "+hfCache+". Download a model with huggingface-cli first, or point to the cache with --hf-cache or the hf_cache setting.")
+	}
+	if info, err := os.Stat(targetDir); err != nil || !info.IsDir() {
+		help = append(help, "No LM Studio models directory at "+targetDir+". Start LM Studio once to create it, or pass the directory as an argument or set target in the config file.")
+	}
+	if len(help) == 0 {
+		help = append(help, "Check that both directories are readable.")
+	}
+	return help
+}
+
+// rescan scans the cache and the target directory again. After a failed scan the loading
+// screen is shown; otherwise the list stays while the scan runs.
+func (m model) rescan() (tea.Model, tea.Cmd) {
+	if m.scanErr != nil {
+		m.scanErr = nil
+		m.scanning = true
+	}
+	m.loading = true
+	m.status = "Scanning..."
+	return m, tea.Batch(m.spinner.Tick, scanCmd(m.index, m.journal, m.logger))
+}
+
+// watchChangedMsg reports that the Hugging Face cache or the target directory changed
+type watchChangedMsg struct {
+	changes <-chan struct{}
+}
+
+// watchCmd watches the Hugging Face cache and the target directory until ctx is done so
+// that the list follows downloads and changes made by other programs, and waits for the
+// first change. Directories that do not exist are not watched.
+func watchCmd(ctx context.Context, targetDir string, logger *logger.Logger) tea.Cmd {
+	return func() tea.Msg {
+		roots, _ := fsutils.GetHfCacheDirs()
+		roots = append(roots, targetDir)
+		var existing []string
+		for _, root := range roots {
+			if info, err := os.Stat(root); err == nil && info.IsDir() {
+				existing = append(existing, root)
+			}
+		}
+		if len(existing) == 0 {
+			return nil
+		}
+		w, err := watch.Watch(ctx, watch.Options{
+			Ignore: func(name string) bool { return name == lock.FileName },
+		}, existing...)
+		if err != nil {
+			if logger != nil && logger.Verbose {
+				logger.Error("UI", "Error watching for changes: %v", err)
+			}
+			return nil
+		}
+		if logger != nil && logger.Verbose {
+			logger.Info("UI", "Watching %s for changes (%s)", strings.Join(existing, ", "), w.Backend)
+		}
+		return waitForChangeCmd(w.C)()
+	}
+}
+
+// waitForChangeCmd waits for the next change reported by a watch
+func waitForChangeCmd(changes <-chan struct{}) tea.Cmd {
+	return func() tea.Msg {
+		if _, ok := <-changes; !ok {
+			return nil
+		}
+		return watchChangedMsg{changes: changes}
+	}
+}
+
+// refreshCmd updates the list after a change made outside the UI
+func refreshCmd(ix *fsutils.Index) tea.Cmd {
+	return func() tea.Msg {
+		if !ix.Changed() {
+			return nil
+		}
+		return updateState(ix, "Updated after changes to the cache or the target directory.")
+	}
+}
+
 // opResultMsg is used to update the UI state with fresh model data
 type opResultMsg struct {
 	status string
@@ -374,6 +813,105 @@ type opResultMsg struct {
 	stale  []fsutils.ModelInfo
 }
 
+// sizesMsg carries copies of the model lists with their disk usage and cached
+// verification state filled in
+type sizesMsg struct {
+	models []fsutils.ModelInfo
+	stale  []fsutils.ModelInfo
+}
+
+// computeSizesCmd measures disk usage in the background so that large caches do not
+// delay startup. Repositories whose blobs did not change are answered from the size cache.
+// Earlier verification failures are carried over from the verify cache without hashing.
+func computeSizesCmd(cache *fsutils.SizeCache, verifyCache *fsutils.VerifyCache, models, stale []fsutils.ModelInfo, logger *logger.Logger) tea.Cmd {
+	models = append([]fsutils.ModelInfo(nil), models...)
+	stale = append([]fsutils.ModelInfo(nil), stale...)
+	return func() tea.Msg {
+		fsutils.ApplyVerifyCache(models, verifyCache)
+		fsutils.ComputeSizes(models, cache)
+		fsutils.ComputeSizes(stale, cache)
+		if err := cache.Save(); err != nil && logger != nil && logger.Verbose {
+			logger.Error("UI", "Error saving size cache: %v", err)
+		}
+		return sizesMsg{models: models, stale: stale}
+	}
+}
+
+// modelKey identifies a model entry across rescans
+func modelKey(m fsutils.ModelInfo) string {
+	return m.CacheDirName + "|" + m.TargetPath
+}
+
+// mergeSizes copies known sizes and verification state from src into the matching
+// entries of dst
+func mergeSizes(dst, src []fsutils.ModelInfo) {
+	sizes := make(map[string]fsutils.ModelInfo, len(src))
+	for _, m := range src {
+		sizes[modelKey(m)] = m
+	}
+	for i := range dst {
+		if s, ok := sizes[modelKey(dst[i])]; ok {
+			dst[i].Size = s.Size
+			dst[i].LinkedSize = s.LinkedSize
+			dst[i].IsCorrupt = s.IsCorrupt
+			dst[i].CorruptReason = s.CorruptReason
+		}
+	}
+}
+
+// setModels replaces the model data and rebuilds the sorted combined list. The include and
+// exclude rules were evaluated by the index when the models were read in the background.
+func (m *model) setModels(models, stale []fsutils.ModelInfo) {
+	m.models = models
+	m.stale = stale
+	m.combined = make([]fsutils.ModelInfo, 0, len(models)+len(stale))
+	m.combined = append(m.combined, models...)
+	m.combined = append(m.combined, stale...)
+	sort.Slice(m.combined, func(i, j int) bool {
+		return m.combined[i].CacheDirName < m.combined[j].CacheDirName
+	})
+	// Models that went away cannot stay selected
+	present := make(map[string]bool, len(m.combined))
+	for _, mdl := range m.combined {
+		present[mdl.CacheDirName] = true
+	}
+	for name := range m.marked {
+		if !present[name] {
+			delete(m.marked, name)
+		}
+	}
+}
+
+// listItems converts models into list items
+func (m model) listItems(models []fsutils.ModelInfo) []list.Item {
+	var items []list.Item
+	for _, mdl := range models {
+		_, matches := m.query.Match(mdl)
+		items = append(items, modelItem{model: mdl, sized: m.sizesLoaded, marked: m.marked[mdl.CacheDirName], matches: matches})
+	}
+	return items
+}
+
+// parseQuery parses the search box into the query that filters and highlights the list.
+// An invalid query still highlights what its valid terms match.
+func (m *model) parseQuery() {
+	m.query, m.queryErr = search.Parse(m.searchInput.Value())
+	m.query = m.query.WithFacts(m.facts)
+}
+
+// factsMsg carries the snapshot files of the models of a scan
+type factsMsg struct {
+	scan  int
+	facts *rules.Facts
+}
+
+// loadFactsCmd reads the snapshot files of models in the background
+func loadFactsCmd(scan int, models []fsutils.ModelInfo) tea.Cmd {
+	return func() tea.Msg {
+		return factsMsg{scan: scan, facts: rules.LoadFacts(models)}
+	}
+}
+
 // errorMsg is used to pass error information to the UI
 type errorMsg string
 
@@ -383,11 +921,7 @@ type ListItemsMsg []list.Item
 // updateModelListCmd updates the model list after operations
 func updateModelListCmd(m model, combined []fsutils.ModelInfo) tea.Cmd {
 	return func() tea.Msg {
-		var items []list.Item
-		for _, mdl := range combined {
-			items = append(items, modelItem{model: mdl})
-		}
-		return ListItemsMsg(items)
+		return ListItemsMsg(m.listItems(combined))
 	}
 }
 
@@ -403,9 +937,36 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// Custom message to update list items
 		items := []list.Item(msg)
 		m.list.SetItems(items)
-		return m, nil
+		return m, m.followCursor()
 		
 	case tea.KeyMsg:
+		// A pending confirmation captures all keys until it is answered
+		if m.confirm != nil {
+			switch msg.String() {
+			case "y", "Y":
+				onConfirm := m.confirm.onConfirm
+				m.status = m.confirm.progress
+				m.confirm = nil
+				m.loading = true
+				return m, tea.Batch(m.spinner.Tick, opDone(onConfirm(m.startOp())))
+			case "n", "N", "esc", "q", "ctrl+c":
+				m.confirm = nil
+				m.status = "Cancelled"
+			}
+			return m, nil
+		}
+		
+		// Until a scan succeeds there is nothing to act on
+		if m.scanning || m.scanErr != nil {
+			switch {
+			case key.Matches(msg, keys.Quit):
+				return m.quit()
+			case key.Matches(msg, keys.Rescan) && !m.scanning:
+				return m.rescan()
+			}
+			return m, nil
+		}
+		
 		// Handle key shortcuts based on current mode
 		if m.searching {
 			// In search mode, handle only specific control keys specially
@@ -414,11 +975,16 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.searching = false
 				m.searchInput.Blur()
 				m.searchInput.SetValue("")
-				return m, updateModelListCmd(m, m.combined)
+				m.parseQuery()
+				return m, updateModelListCmd(m, m.visibleModels())
 				
 			case tea.KeyEnter: // Complete search
 				m.searching = false
 				m.searchInput.Blur()
+				if m.queryErr != nil {
+					m.status = fmt.Sprintf("Invalid search: %v", m.queryErr)
+					return m, nil
+				}
 				m.status = fmt.Sprintf("Found %d matches for: %s", len(m.list.Items()), m.searchInput.Value())
 				return m, nil
 			}
@@ -426,25 +992,218 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// Process all other input for search box
 			var searchCmd tea.Cmd
 			m.searchInput, searchCmd = m.searchInput.Update(msg)
+			m.parseQuery()
+			if m.queryErr != nil {
+				m.status = fmt.Sprintf("Invalid search: %v", m.queryErr)
+			} else {
+				m.status = ""
+			}
 			
 			// Filter list based on search input
-			cmds = append(cmds, searchCmd)
-			if m.searchInput.Value() != "" {
-				filtered := filterModels(m.combined, m.searchInput.Value())
-				return m, updateModelListCmd(m, filtered)
-			} else {
-				return m, updateModelListCmd(m, m.combined)
+			return m, tea.Batch(searchCmd, updateModelListCmd(m, m.visibleModels()))
+		}
+		
+		// While an operation runs, the cancel key stops it, quitting waits for it to stop
+		// and nothing else may change the target directory
+		if m.cancel != nil || m.verifyCancel != nil {
+			switch {
+			case key.Matches(msg, keys.Cancel):
+				m.cancelRunning()
+				m.status = "Cancelling..."
+				return m, nil
+			case key.Matches(msg, keys.Quit):
+				m.cancelRunning()
+				m.quitting = true
+				m.status = "Cancelling before quitting..."
+				return m, nil
+			case m.cancel != nil && key.Matches(msg, keys.Link, keys.Unlink, keys.Purge, keys.LinkAll, keys.UnlinkAll,
+				keys.PurgeAll, keys.Prune, keys.Delete, keys.Undo, keys.Apply, keys.Retry, keys.RetryAll, keys.Rescan):
+				m.status = "An operation is still running; press " + keys.Cancel.Help().Key + " to cancel it"
+				return m, nil
+			}
+		}
+		
+		// The error pane replaces the list until it is closed
+		if m.showErrors {
+			switch {
+			case key.Matches(msg, keys.Errors), msg.String() == "esc":
+				m.showErrors = false
+				m.status = "Closed failed steps"
+			case key.Matches(msg, keys.Up):
+				if m.errorCursor > 0 {
+					m.errorCursor--
+				}
+			case key.Matches(msg, keys.Down):
+				if m.errorCursor < len(m.failures)-1 {
+					m.errorCursor++
+				}
+			case key.Matches(msg, keys.Retry):
+				selected := m.failures[m.errorCursor]
+				keep := append(append([]bulkFailure{}, m.failures[:m.errorCursor]...), m.failures[m.errorCursor+1:]...)
+				return m.retry([]bulkFailure{selected}, keep)
+			case key.Matches(msg, keys.RetryAll):
+				return m.retry(m.failures, nil)
+			case key.Matches(msg, keys.Quit):
+				return m.quit()
+			}
+			return m, nil
+		}
+		
+		// The drift view replaces the list until it is closed
+		if m.drift != nil {
+			switch {
+			case key.Matches(msg, keys.Drift), msg.String() == "esc":
+				m.drift = nil
+				m.status = "Closed drift view"
+				return m, nil
+			case key.Matches(msg, keys.Apply):
+				plan := m.drift.plan
+				if plan.Count(desired.ActionLink)+plan.Count(desired.ActionRelink)+plan.Count(desired.ActionUnlink) == 0 {
+					m.status = "Nothing to apply"
+					return m, nil
+				}
+				m.confirm = &confirmation{
+					message:   "Apply " + plan.Summary() + "? (y/n)",
+					progress:  "Applying desired state...",
+					onConfirm: func(ctx context.Context) tea.Cmd {
+						return withLock(m.targetDir, applyDriftCmd(ctx, plan, m.targetDir, m.index, m.journal, m.logger))
+					},
+				}
+				return m, nil
+			case key.Matches(msg, keys.Quit):
+				return m.quit()
 			}
+			m.driftView, cmd = m.driftView.Update(msg)
+			return m, cmd
 		}
 		
 		// Normal mode keyboard shortcuts
 		switch {
+		case msg.String() == "esc" && len(m.marked) > 0:
+			m.clearMarks()
+			m.status = "Selection cleared"
+			return m, m.list.SetItems(m.listItems(m.visibleModels()))
+			
 		case key.Matches(msg, keys.Quit):
-			return m, tea.Quit
+			return m.quit()
 			
 		case key.Matches(msg, keys.ToggleHelp):
 			m.showFullHelp = !m.showFullHelp
 			
+		case key.Matches(msg, keys.Rescan):
+			return m.rescan()
+			
+		case key.Matches(msg, keys.Mark):
+			return m, m.toggleMark()
+			
+		case key.Matches(msg, keys.MarkRange):
+			return m, m.markRange()
+			
+		case key.Matches(msg, keys.MarkAll):
+			return m, m.markAll()
+			
+		case key.Matches(msg, keys.Errors):
+			if len(m.failures) == 0 {
+				m.status = "No failed steps"
+				return m, nil
+			}
+			m.showErrors = true
+			m.errorCursor = 0
+			m.status = fmt.Sprintf("%d failed step(s)", len(m.failures))
+			return m, nil
+			
+		case key.Matches(msg, keys.Prune):
+			m.status = "Scanning Hugging Face cache for prunable data..."
+			m.loading = true
+			return m, tea.Batch(m.spinner.Tick, opDone(planPruneCmd(m.startOp(), m.allTargets(), m.logger)))
+			
+		case key.Matches(msg, keys.Delete):
+			if len(m.list.Items()) > 0 {
+				selectedItem, ok := m.list.SelectedItem().(modelItem)
+				if ok && !selectedItem.model.IsStale {
+					m.status = "Measuring " + selectedItem.model.RepoID() + "..."
+					m.loading = true
+					return m, tea.Batch(m.spinner.Tick, opDone(planDeleteCmd(m.startOp(), selectedItem.model, m.allTargets())))
+				}
+			}
+			
+		case key.Matches(msg, keys.Undo):
+			ops, err := m.journal.Undoable(1)
+			if err != nil {
+				m.status = "Cannot undo: " + err.Error()
+				return m, nil
+			}
+			m.confirm = &confirmation{
+				message:   "Undo " + ops[0].Describe() + "? (y/n)",
+				progress:  "Undoing " + ops[0].Kind + "...",
+				onConfirm: func(context.Context) tea.Cmd {
+					return withLock(ops[0].TargetDir, undoCmd(m.index, m.journal, m.logger))
+				},
+			}
+			return m, nil
+			
+		case key.Matches(msg, keys.Verify):
+			if m.verifying || len(m.list.Items()) == 0 {
+				return m, nil
+			}
+			if len(m.marked) > 0 {
+				return m.applyMarked(markVerify)
+			}
+			selectedItem, ok := m.list.SelectedItem().(modelItem)
+			if ok && selectedItem.model.IsLinked && !selectedItem.model.IsStale {
+				return m.startVerify([]fsutils.ModelInfo{selectedItem.model})
+			}
+			
+		case key.Matches(msg, keys.VerifyAll):
+			if m.verifying {
+				return m, nil
+			}
+			var linked []fsutils.ModelInfo
+			for _, mdl := range m.models {
+				if mdl.IsLinked && !mdl.IsStale {
+					linked = append(linked, mdl)
+				}
+			}
+			if len(linked) == 0 {
+				m.status = "No linked models to verify"
+				return m, nil
+			}
+			return m.startVerify(linked)
+			
+		case key.Matches(msg, keys.Drift):
+			m.status = "Comparing with desired state..."
+			m.loading = true
+			return m, tea.Batch(m.spinner.Tick, planDriftCmd(m.desiredFile, m.targetDir, m.rules))
+			
+		case key.Matches(msg, keys.Format):
+			m.formatFilter = nextFormatFilter(m.formatFilter)
+			m.status = "Showing formats: " + formatFilterLabel(m.formatFilter)
+			return m, updateModelListCmd(m, m.visibleModels())
+			
+		case key.Matches(msg, keys.Status):
+			m.statusFilter = nextStatusFilter(m.statusFilter)
+			m.status = "Showing status: " + statusFilterLabel(m.statusFilter)
+			return m, updateModelListCmd(m, m.visibleModels())
+			
+		case key.Matches(msg, keys.Sort):
+			m.sortKey = nextSortKey(m.sortKey)
+			m.status = "Sorted by " + sortLabels[m.sortKey]
+			return m, updateModelListCmd(m, m.visibleModels())
+			
+		case key.Matches(msg, keys.Details):
+			m.showDetails = !m.showDetails
+			m.layout()
+			m.detailFor, m.detailShown = "", ""
+			return m, m.followCursor()
+			
+		case key.Matches(msg, keys.DetailUp) && m.showDetails:
+			m.detailView.LineUp(1)
+			return m, nil
+			
+		case key.Matches(msg, keys.DetailDown) && m.showDetails:
+			m.detailView.LineDown(1)
+			return m, nil
+			
 		case key.Matches(msg, keys.Search):
 			m.searching = true
 			m.searchInput.Focus()
@@ -452,84 +1211,78 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 			
 		case key.Matches(msg, keys.Link):
+			if len(m.marked) > 0 {
+				return m.applyMarked(markLink)
+			}
 			if len(m.list.Items()) > 0 {
 				selectedItem, ok := m.list.SelectedItem().(modelItem)
 				if ok && !selectedItem.model.IsStale && !selectedItem.model.IsLinked {
+					if !selectedItem.model.IsLinkable() {
+						m.status = "Cannot link " + selectedItem.model.RepoID() + ": " + selectedItem.model.NotLinkableReason()
+						return m, nil
+					}
+					if selectedItem.model.IsIncomplete {
+						m.status = "Deferred " + selectedItem.model.ModelName + ": " + selectedItem.model.IncompleteReason
+						return m, nil
+					}
+					if selectedItem.model.Format == fsutils.FormatUnsupported {
+						m.status = "Cannot link " + selectedItem.model.ModelName + ": no GGUF or MLX files found"
+						return m, nil
+					}
 					m.status = "Linking model: " + selectedItem.model.ModelName
 					m.loading = true
-					return m, tea.Batch(
-						m.spinner.Tick,
-						func() tea.Msg {
-							return linkModelCmd(selectedItem.model, m.targetDir, m.logger)()
-						},
-					)
+					ctx := m.startOp()
+					return m, tea.Batch(m.spinner.Tick, opDone(withLock(m.targetDir, linkModelCmd(ctx, selectedItem.model, m.linkMode, m.targetDir, m.index, m.journal, m.logger))))
 				}
 			}
 			
 		case key.Matches(msg, keys.Unlink):
+			if len(m.marked) > 0 {
+				return m.applyMarked(markUnlink)
+			}
 			if len(m.list.Items()) > 0 {
 				selectedItem, ok := m.list.SelectedItem().(modelItem)
 				if ok && !selectedItem.model.IsStale && selectedItem.model.IsLinked {
 					m.status = "Unlinking model: " + selectedItem.model.ModelName
 					m.loading = true
-					return m, tea.Batch(
-						m.spinner.Tick,
-						func() tea.Msg {
-							return unlinkModelCmd(selectedItem.model, m.targetDir, m.logger)()
-						},
-					)
+					ctx := m.startOp()
+					return m, tea.Batch(m.spinner.Tick, opDone(withLock(m.targetDir, unlinkModelCmd(ctx, selectedItem.model, m.targetDir, m.index, m.journal, m.logger))))
 				}
 			}
 			
 		case key.Matches(msg, keys.Purge):
+			if len(m.marked) > 0 {
+				return m.applyMarked(markPurge)
+			}
 			if len(m.list.Items()) > 0 {
 				selectedItem, ok := m.list.SelectedItem().(modelItem)
 				if ok && selectedItem.model.IsStale {
 					m.status = "Purging stale model: " + selectedItem.model.ModelName
 					m.loading = true
-					return m, tea.Batch(
-						m.spinner.Tick,
-						func() tea.Msg {
-							return purgeModelCmd(selectedItem.model, m.targetDir, m.logger)()
-						},
-					)
+					ctx := m.startOp()
+					return m, tea.Batch(m.spinner.Tick, opDone(withLock(m.targetDir, purgeModelCmd(ctx, selectedItem.model, m.targetDir, m.index, m.journal, m.logger))))
 				}
 			}
 			
 		case key.Matches(msg, keys.LinkAll):
 			m.status = "Linking all models..."
 			m.loading = true
-			return m, tea.Batch(
-				m.spinner.Tick,
-				func() tea.Msg {
-					return linkAllCmd(m.models, m.targetDir, m.logger)()
-				},
-			)
+			return m, tea.Batch(m.spinner.Tick, linkAllCmd(m.startOp(), m.models, m.linkMode, m.targetDir, m.workers, m.index, m.journal, m.logger))
 			
 		case key.Matches(msg, keys.UnlinkAll):
 			m.status = "Unlinking all models..."
 			m.loading = true
-			return m, tea.Batch(
-				m.spinner.Tick,
-				func() tea.Msg {
-					return unlinkAllCmd(m.models, m.targetDir, m.logger)()
-				},
-			)
+			return m, tea.Batch(m.spinner.Tick, unlinkAllCmd(m.startOp(), m.models, m.targetDir, m.workers, m.index, m.journal, m.logger))
 			
 		case key.Matches(msg, keys.PurgeAll):
 			m.status = "Purging all stale links..."
 			m.loading = true
-			return m, tea.Batch(
-				m.spinner.Tick,
-				func() tea.Msg {
-					return purgeAllCmd(m.stale, m.targetDir, m.logger)()
-				},
-			)
+			return m, tea.Batch(m.spinner.Tick, purgeAllCmd(m.startOp(), m.stale, m.targetDir, m.workers, m.index, m.journal, m.logger))
 		}
 		
 	case tea.WindowSizeMsg:
-		headerHeight := 3
-		footerHeight := 4
+		headerHeight := 4
+		footerHeight := 5
 		verticalMarginHeight := headerHeight + footerHeight
 
 		if !m.ready {
@@ -546,6 +1299,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		
 		m.help.Width = msg.Width
+		m.driftView.Width = msg.Width - 4
+		m.driftView.Height = msg.Height - verticalMarginHeight - 1
+		m.layout()
 		
 	case spinner.TickMsg:
 		if m.loading {
@@ -556,61 +1312,565 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
         
     // We don't need to handle list.SetItemsMsg anymore as we're using our custom ListItemsMsg
 	
-	case opResultMsg:
-		m.status = msg.status
-		m.models = msg.models
-		m.stale = msg.stale
-		m.combined = append(msg.models, msg.stale...)
-		sort.Slice(m.combined, func(i, j int) bool {
-			return m.combined[i].CacheDirName < m.combined[j].CacheDirName
-		})
+	case opDoneMsg:
+		m.endOp()
+		next, cmd := m.Update(msg.msg)
+		if nm := next.(model); nm.quitting && nm.verifyCancel == nil {
+			return nm.quit()
+		}
+		return next, cmd
+		
+	case bulkDoneMsg:
+		m.failures = msg.failures
+		if m.errorCursor >= len(m.failures) {
+			m.errorCursor = 0
+		}
+		if len(m.failures) == 0 {
+			m.showErrors = false
+		}
+		return m.Update(msg.result)
 		
-		// Update the list with new data
-		var items []list.Item
-		for _, mdl := range m.combined {
-			items = append(items, modelItem{model: mdl})
+	case watchChangedMsg:
+		cmds = append(cmds, waitForChangeCmd(msg.changes))
+		// A running operation rescans whatever changed when it finishes
+		if m.cancel == nil && !m.scanning && m.scanErr == nil {
+			cmds = append(cmds, refreshCmd(m.index))
 		}
 		
+	case scanErrorMsg:
+		m.scanning = false
 		m.loading = false
-		cmds = append(cmds, m.list.SetItems(items))
+		m.scanErr = &msg
+		m.status = "Scan failed"
+		return m, nil
+		
+	case opResultMsg:
+		m.scanning = false
+		m.scanErr = nil
+		m.status = msg.status
+		// Keep showing the previous sizes until the rescan has been measured
+		mergeSizes(msg.models, m.models)
+		mergeSizes(msg.stale, m.stale)
+		m.setModels(msg.models, msg.stale)
 		
-	case errorMsg:
-		m.status = string(msg)
 		m.loading = false
+		m.scans++
+		cmds = append(cmds,
+			m.list.SetItems(m.listItems(m.visibleModels())),
+			computeSizesCmd(m.sizeCache, m.verifyCache, m.models, m.stale, m.logger),
+			loadFactsCmd(m.scans, m.models),
+		)
+		// Keep an open drift view current
+		if m.drift != nil {
+			cmds = append(cmds, planDriftCmd(m.drift.path, m.targetDir, m.rules))
+		}
+		
+	case factsMsg:
+		// Facts of an older scan would hide files of models added since
+		if msg.scan != m.scans {
+			return m, nil
+		}
+		m.facts = msg.facts
+		m.parseQuery()
+		cmds = append(cmds, m.list.SetItems(m.listItems(m.visibleModels())))
+		
+	case sizesMsg:
+		mergeSizes(m.models, msg.models)
+		mergeSizes(m.stale, msg.stale)
+		m.setModels(m.models, m.stale)
+		m.sizesLoaded = true
+		cmds = append(cmds, m.list.SetItems(m.listItems(m.visibleModels())))
+		
+	case bulkStepMsg:
+		if m.bulk == nil {
+			m.bulk = &bulkState{}
+		}
+		m.bulk.done, m.bulk.total, m.bulk.failed = msg.done, msg.total, msg.failed
+		m.bulk.addLog(describeStep(msg.step, msg.err))
+		m.status = msg.label + "..."
+		if msg.failed > 0 {
+			m.status += fmt.Sprintf(" (%d failed)", msg.failed)
+		}
+		if msg.err == nil {
+			m.applyStep(msg.step)
+			cmds = append(cmds, m.list.SetItems(m.listItems(m.visibleModels())))
+		}
+		cmds = append(cmds, waitForBulkCmd(msg.ch))
+		
+	case bulkProgressMsg:
+		if m.bulk == nil {
+			m.bulk = &bulkState{total: msg.total}
+		}
+		m.status = fmt.Sprintf("%s: %s %s", msg.label, msg.step.Repo, msg.progress.File)
+		if msg.progress.BytesTotal > 0 {
+			m.status += fmt.Sprintf(" (%s of %s)", fsutils.FormatSize(msg.progress.BytesDone), fsutils.FormatSize(msg.progress.BytesTotal))
+		}
+		return m, waitForBulkCmd(msg.ch)
+		
+	case verifyProgressMsg:
+		m.verifyState = msg.progress
+		if msg.progress.File != "" {
+			m.status = "Verifying " + filepath.Base(msg.progress.File)
+		}
+		return m, waitForVerifyCmd(msg.ch)
+		
+	case verifyDoneMsg:
+		m.verifying = false
+		m.verifyCancel()
+		m.verifyCancel = nil
+		m.status = describeVerifyResults(msg.results)
+		if msg.cancelled {
+			m.status = "Cancelled: " + m.status
+		}
+		corrupt := make(map[string]fsutils.VerifyResult, len(msg.results))
+		for _, r := range msg.results {
+			corrupt[modelKey(r.Model)] = r
+		}
+		for i := range m.models {
+			if r, ok := corrupt[modelKey(m.models[i])]; ok {
+				fsutils.MarkCorrupt(&m.models[i], r)
+			}
+		}
+		m.setModels(m.models, m.stale)
+		if err := m.verifyCache.Save(); err != nil && m.logger != nil && m.logger.Verbose {
+			m.logger.Error("UI", "Error saving verify cache: %v", err)
+		}
+		cmds = append(cmds, m.list.SetItems(m.listItems(m.visibleModels())))
+		if m.quitting && m.cancel == nil {
+			return m.quit()
+		}
+		
+	case driftMsg:
+		m.loading = false
+		if m.drift == nil {
+			m.status = msg.path + ": " + msg.plan.Summary()
+		}
+		m.drift = &msg
+		m.driftView.SetContent(renderDrift(msg.plan))
+		
+	case prunePlanMsg:
+		m.loading = false
+		plan := fsutils.PrunePlan(msg)
+		if len(plan.Items) == 0 {
+			m.status = "Nothing to prune"
+			break
+		}
+		m.confirm = &confirmation{
+			message:   describePrunePlan(plan) + ". Delete? (y/n)",
+			progress:  "Pruning Hugging Face cache...",
+			onConfirm: func(ctx context.Context) tea.Cmd {
+				// Links in any target keep their revisions alive, so all of them are locked
+				return withLocks(m.lockTargets(), executePruneCmd(ctx, plan, m.allTargets(), m.index, m.journal, m.logger))
+			},
+		}
+		
+	case deletePlanMsg:
+		m.loading = false
+		m.confirm = &confirmation{
+			message:   describeDeletePlan(msg, m.targetDir) + " (y/n)",
+			progress:  "Deleting " + msg.model.RepoID() + " from the Hugging Face cache...",
+			onConfirm: func(context.Context) tea.Cmd {
+				// The model is unlinked from every target, so all of them are locked
+				return withLocks(m.lockTargets(), deleteModelCmd(msg.model, m.allTargets(), m.targetDir, m.index, m.journal, m.logger))
+			},
+		}
+		
+	case errorMsg:
+		m.status = string(msg)
+		m.loading = false
+		
+	case detailsMsg:
+		// Details for a model the cursor has since left are dropped
+		if msg.key != m.detailFor {
+			return m, nil
+		}
+		m.detailView.SetContent(lipgloss.NewStyle().Width(m.detailView.Width).Render(renderDetails(msg.model, msg.details, msg.err)))
+		if msg.model.CacheDirName != m.detailShown {
+			m.detailView.GotoTop()
+		}
+		m.detailShown = msg.model.CacheDirName
+		return m, nil
+	}
+	
+	// The list does not see input while the drift view or error pane covers it
+	if m.drift != nil || m.showErrors {
+		return m, tea.Batch(cmds...)
+	}
+	
+	// Update list with any pending commands
+	m.list, cmd = m.list.Update(msg)
+	cmds = append(cmds, cmd, m.followCursor())
+	
+	return m, tea.Batch(cmds...)
+}
+
+// scanView shows the progress of the first scan, or why it failed
+func (m model) scanView() string {
+	if m.scanErr == nil {
+		return m.spinner.View() + " Scanning the Hugging Face cache and LM Studio models..."
+	}
+	lines := []string{failureStyle.Render("Cannot scan models: " + m.scanErr.err.Error()), ""}
+	lines = append(lines, m.scanErr.help...)
+	lines = append(lines, "", lipgloss.NewStyle().Foreground(color("muted")).Render(
+		"Press "+keys.Rescan.Help().Key+" to scan again or "+keys.Quit.Help().Key+" to quit."))
+	return lipgloss.NewStyle().Width(m.width - 4).Render(strings.Join(lines, "\n"))
+}
+
+// allTargets returns the managed target directory followed by any extra targets
+func (m model) allTargets() []string {
+	return append([]string{m.targetDir}, m.extraTargets...)
+}
+
+// lockTargets returns the managed target directory followed by the extra targets that
+// exist, which are the directories to lock when an operation depends on every target's links
+func (m model) lockTargets() []string {
+	dirs := []string{m.targetDir}
+	for _, dir := range m.extraTargets {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// opDoneMsg wraps the final message of an operation started with startOp
+type opDoneMsg struct {
+	msg tea.Msg
+}
+
+// startOp returns the context of a new operation, which the cancel key cancels. The
+// operation's command must be wrapped in opDone so the context is released when it ends.
+func (m *model) startOp() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	return ctx
+}
+
+// endOp releases the context of the finished operation
+func (m *model) endOp() {
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+	}
+	m.bulk = nil
+}
+
+// cancelRunning cancels the running operation and verification
+func (m *model) cancelRunning() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	if m.verifyCancel != nil {
+		m.verifyCancel()
+	}
+}
+
+// opDone marks the message of a command as the end of an operation
+func opDone(cmd tea.Cmd) tea.Cmd {
+	return func() tea.Msg {
+		return opDoneMsg{msg: cmd()}
+	}
+}
+
+// toggleMark selects or deselects the model under the cursor and moves to the next one
+func (m *model) toggleMark() tea.Cmd {
+	item, ok := m.list.SelectedItem().(modelItem)
+	if !ok {
+		return nil
+	}
+	name := item.model.CacheDirName
+	if m.marked[name] {
+		delete(m.marked, name)
+	} else {
+		m.marked[name] = true
+	}
+	m.markAnchor = name
+	m.list.CursorDown()
+	m.status = fmt.Sprintf("%d selected", len(m.marked))
+	return m.list.SetItems(m.listItems(m.visibleModels()))
+}
+
+// markRange selects every shown model between the one last toggled and the cursor
+func (m *model) markRange() tea.Cmd {
+	visible := m.visibleModels()
+	if len(visible) == 0 {
+		return nil
+	}
+	from, to, anchored := markSpan(visible, m.markAnchor, m.list.Index())
+	for _, mdl := range visible[from : to+1] {
+		m.marked[mdl.CacheDirName] = true
+	}
+	m.status = fmt.Sprintf("%d selected", len(m.marked))
+	if !anchored {
+		m.status += " (the model last toggled is not shown, so only the one under the cursor was added)"
+	}
+	return m.list.SetItems(m.listItems(visible))
+}
+
+// markSpan returns the indexes of the first and last of the shown models between the
+// anchor and the cursor, in either direction. If the anchor is not shown, e.g. because a
+// filter hides it, the span is only the cursor and anchored is false.
+func markSpan(visible []fsutils.ModelInfo, anchor string, cursor int) (from, to int, anchored bool) {
+	if cursor >= len(visible) {
+		cursor = len(visible) - 1
+	}
+	from, to = cursor, cursor
+	for i, mdl := range visible {
+		if anchor != "" && mdl.CacheDirName == anchor {
+			from, anchored = i, true
+		}
+	}
+	if from > to {
+		from, to = to, from
+	}
+	return from, to, anchored
+}
+
+// markAll selects every shown model, or deselects them if they all are selected already
+func (m *model) markAll() tea.Cmd {
+	visible := m.visibleModels()
+	all := true
+	for _, mdl := range visible {
+		all = all && m.marked[mdl.CacheDirName]
+	}
+	for _, mdl := range visible {
+		if all {
+			delete(m.marked, mdl.CacheDirName)
+		} else {
+			m.marked[mdl.CacheDirName] = true
+		}
+	}
+	m.status = fmt.Sprintf("%d selected", len(m.marked))
+	return m.list.SetItems(m.listItems(visible))
+}
+
+// clearMarks deselects every model
+func (m *model) clearMarks() {
+	m.marked = map[string]bool{}
+	m.markAnchor = ""
+}
+
+// markedModels returns the selected models in list order, including those the search
+// or format filter hides
+func (m model) markedModels() []fsutils.ModelInfo {
+	var marked []fsutils.ModelInfo
+	for _, mdl := range m.combined {
+		if m.marked[mdl.CacheDirName] {
+			marked = append(marked, mdl)
+		}
+	}
+	return marked
+}
+
+// Actions that apply to the selected models
+const (
+	markLink   = "link"
+	markUnlink = "unlink"
+	markPurge  = "purge"
+	markVerify = "verify"
+)
+
+// applyMarked runs an action on the selected models instead of the one under the cursor.
+// Selected models the action does not apply to are skipped; the selection is cleared once
+// the action starts.
+func (m model) applyMarked(action string) (tea.Model, tea.Cmd) {
+	marked := m.markedModels()
+	if action == markVerify {
+		var linked []fsutils.ModelInfo
+		for _, mdl := range marked {
+			if mdl.IsLinked && !mdl.IsStale {
+				linked = append(linked, mdl)
+			}
+		}
+		if len(linked) == 0 {
+			m.status = fmt.Sprintf("None of the %d selected model(s) are linked", len(marked))
+			return m, nil
+		}
+		m.clearMarks()
+		next, cmd := m.startVerify(linked)
+		return next, tea.Batch(cmd, m.list.SetItems(m.listItems(m.visibleModels())))
+	}
+	
+	op := markedOp(action, marked, m.linkMode)
+	if len(op.steps) == 0 {
+		m.status = fmt.Sprintf("Nothing to %s among the %d selected model(s)", action, len(marked))
+		return m, nil
+	}
+	m.clearMarks()
+	m.status = op.label + "..."
+	m.loading = true
+	return m, tea.Batch(m.spinner.Tick, m.list.SetItems(m.listItems(m.visibleModels())),
+		runBulkCmd(m.startOp(), op, m.targetDir, m.workers, m.index, m.journal, m.logger))
+}
+
+// markedOp builds the bulk operation that links, unlinks or purges the selected models.
+// Unlike "link all", linking ignores the include and exclude rules: the models were picked
+// by hand.
+func markedOp(action string, marked []fsutils.ModelInfo, mode fsutils.LinkMode) bulkOp {
+	var steps []journal.Step
+	for _, mdl := range marked {
+		switch {
+		case action == markLink && !mdl.IsStale && !mdl.IsLinked && mdl.IsLinkable() && !mdl.IsIncomplete && mdl.Format != fsutils.FormatUnsupported:
+			steps = append(steps, journal.LinkStep(mdl, fsutils.LinkOptions{Mode: mode}))
+		case action == markUnlink && mdl.IsLinked && !mdl.IsStale, action == markPurge && mdl.IsStale:
+			steps = append(steps, journal.UnlinkStep(mdl))
+		}
+	}
+	labels := map[string]string{markLink: "Linking", markUnlink: "Unlinking", markPurge: "Purging"}
+	verbs := map[string]string{markLink: "linked", markUnlink: "unlinked", markPurge: "purged"}
+	skipped := len(marked) - len(steps)
+	return bulkOp{
+		kind:  action + " selected",
+		label: fmt.Sprintf("%s %d selected model(s)", labels[action], len(steps)),
+		steps: steps,
+		summary: func(succeeded int) string {
+			status := fmt.Sprintf("Successfully %s %d selected model(s)", verbs[action], succeeded)
+			if skipped > 0 {
+				status += fmt.Sprintf(" (%d skipped: nothing to %s)", skipped, action)
+			}
+			return status
+		},
+	}
+}
+
+// visibleModels returns the combined model list narrowed by the format and status filters
+// and search term, in the chosen sort order
+func (m model) visibleModels() []fsutils.ModelInfo {
+	filtered := filterByStatus(filterByFormat(m.combined, m.formatFilter), m.statusFilter)
+	// While the query is invalid, e.g. half-typed, the list is not narrowed by it
+	if m.queryErr == nil {
+		filtered = m.query.Filter(filtered)
+	}
+	return sortModels(filtered, m.sortKey)
+}
+
+// formatFilters is the cycle order of the format filter; the empty format shows everything
+var formatFilters = []fsutils.ModelFormat{"", fsutils.FormatGGUF, fsutils.FormatMLX, fsutils.FormatUnsupported}
+
+// nextFormatFilter returns the filter following current in the cycle
+func nextFormatFilter(current fsutils.ModelFormat) fsutils.ModelFormat {
+	for i, f := range formatFilters {
+		if f == current {
+			return formatFilters[(i+1)%len(formatFilters)]
+		}
+	}
+	return ""
+}
+
+// formatFilterLabel returns the header label for a format filter
+func formatFilterLabel(f fsutils.ModelFormat) string {
+	if f == "" {
+		return "All"
+	}
+	return f.String()
+}
+
+// filterByFormat keeps models of the given format. Stale links have no source to classify
+// and are always kept so they remain purgeable.
+func filterByFormat(models []fsutils.ModelInfo, format fsutils.ModelFormat) []fsutils.ModelInfo {
+	if format == "" {
+		return models
+	}
+	var filtered []fsutils.ModelInfo
+	for _, m := range models {
+		if m.IsStale || m.Format == format {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+// statusFilters is the cycle order of the status filter; the empty status shows everything
+var statusFilters = append([]string{""}, search.Statuses...)
+
+// nextStatusFilter returns the filter following current in the cycle
+func nextStatusFilter(current string) string {
+	for i, s := range statusFilters {
+		if s == current {
+			return statusFilters[(i+1)%len(statusFilters)]
+		}
+	}
+	return ""
+}
+
+// statusFilterLabel returns the header label for a status filter
+func statusFilterLabel(status string) string {
+	if status == "" {
+		return "All"
+	}
+	return strings.ToUpper(status[:1]) + status[1:]
+}
+
+// filterByStatus keeps models in the given state
+func filterByStatus(models []fsutils.ModelInfo, status string) []fsutils.ModelInfo {
+	if status == "" {
+		return models
+	}
+	var filtered []fsutils.ModelInfo
+	for _, m := range models {
+		if search.MatchesStatus(m, status) {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+// sortKeys is the cycle order of the sort modes; the empty key sorts by organization
+var sortKeys = []string{"", "name", "size", "modified", "linked"}
+
+// sortLabels describes each sort mode in the header
+var sortLabels = map[string]string{
+	"":         "organization",
+	"name":     "name",
+	"size":     "size, largest first",
+	"modified": "last modified, newest first",
+	"linked":   "link date, newest first",
+}
+
+// nextSortKey returns the sort mode following current in the cycle
+func nextSortKey(current string) string {
+	for i, k := range sortKeys {
+		if k == current {
+			return sortKeys[(i+1)%len(sortKeys)]
+		}
+	}
+	return ""
+}
+
+// sortModels returns a copy of models sorted by a sort mode. Names compare without regard
+// to case, and ties are broken by organization and name.
+func sortModels(models []fsutils.ModelInfo, by string) []fsutils.ModelInfo {
+	sorted := append([]fsutils.ModelInfo(nil), models...)
+	byRepo := func(a, b fsutils.ModelInfo) bool {
+		orgA, orgB := strings.ToLower(a.OrganizationName), strings.ToLower(b.OrganizationName)
+		if orgA != orgB {
+			return orgA < orgB
+		}
+		return strings.ToLower(a.ModelName) < strings.ToLower(b.ModelName)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch {
+		case by == "name" && !strings.EqualFold(a.ModelName, b.ModelName):
+			return strings.ToLower(a.ModelName) < strings.ToLower(b.ModelName)
+		case by == "size" && a.Size != b.Size:
+			return a.Size > b.Size
+		case by == "modified" && !a.ModifiedAt.Equal(b.ModifiedAt):
+			return a.ModifiedAt.After(b.ModifiedAt)
+		case by == "linked" && !a.LinkedAt.Equal(b.LinkedAt):
+			return a.LinkedAt.After(b.LinkedAt)
+		}
+		return byRepo(a, b)
+	})
+	return sorted
+}
+
+// View renders the UI
+func (m model) View() string {
+	if !m.ready {
+		return "\nInitializing..."
 	}
 	
-	// Update list with any pending commands
-	m.list, cmd = m.list.Update(msg)
-	cmds = append(cmds, cmd)
-	
-	return m, tea.Batch(cmds...)
-}
-
-// Filter models based on search term
-func filterModels(models []fsutils.ModelInfo, searchTerm string) []fsutils.ModelInfo {
-    if searchTerm == "" {
-        return models
-    }
-    
-    lowerSearch := strings.ToLower(searchTerm)
-    var filtered []fsutils.ModelInfo
-    
-    for _, m := range models {
-        if strings.Contains(strings.ToLower(m.ModelName), lowerSearch) ||
-           strings.Contains(strings.ToLower(m.OrganizationName), lowerSearch) {
-            filtered = append(filtered, m)
-        }
-    }
-    
-    return filtered
-}
-
-// View renders the UI
-func (m model) View() string {
-	if !m.ready {
-		return "\nInitializing..."
-	}
-	
 	// Update styles to use current window width
 	titleStyleWidth := titleStyle.Copy().Width(m.width - 4)
 	statusStyleWidth := statusStyle.Copy().Width(m.width - 4)
@@ -619,23 +1879,64 @@ func (m model) View() string {
 	header := titleStyleWidth.Align(lipgloss.Center).Render("Hugging Face to LM Studio Sync")
 	
 	// Render info section
-	hfCache, _ := fsutils.GetHfCacheDir()
+	caches, _ := fsutils.GetHfCacheDirs()
 	infoSection := lipgloss.JoinVertical(lipgloss.Left,
-		fmt.Sprintf("Hugging Face Cache: %s", hfCache),
+		fmt.Sprintf("Hugging Face Cache: %s", strings.Join(caches, ", ")),
 		fmt.Sprintf("LM Studio Models: %s", m.targetDir),
+		fmt.Sprintf("Format: %s · Status: %s · Sort: %s", formatFilterLabel(m.formatFilter), statusFilterLabel(m.statusFilter), sortLabels[m.sortKey]),
 	)
 	
+	// Until a scan succeeds a loading or error screen takes the place of the list
+	if m.scanning || m.scanErr != nil {
+		return appStyle.Render(lipgloss.JoinVertical(lipgloss.Left, header, infoSection, "", m.scanView()))
+	}
+	
 	// Render status bar
 	var statusBar string
-	if m.loading {
+	if m.confirm != nil {
+		statusBar = confirmStyle.Render(m.confirm.message)
+	} else if m.bulk != nil {
+		statusBar = fmt.Sprintf("%s %d/%d %s", m.progress.ViewAs(m.bulk.percent()), m.bulk.done, m.bulk.total, m.status)
+	} else if m.verifying {
+		var percent float64
+		if m.verifyState.BytesTotal > 0 {
+			percent = float64(m.verifyState.BytesDone) / float64(m.verifyState.BytesTotal)
+		}
+		statusBar = fmt.Sprintf("%s %d/%d files %s",
+			m.progress.ViewAs(percent),
+			m.verifyState.FilesDone, m.verifyState.FilesTotal,
+			m.status)
+	} else if m.loading {
 		statusBar = lipgloss.JoinHorizontal(lipgloss.Left, 
 			m.spinner.View(),
 			" "+m.status,
 		)
 	} else {
 		statusBar = m.status
+		if len(m.marked) > 0 {
+			statusBar += markedStyle.Render(fmt.Sprintf("  [%d selected · esc to clear]", len(m.marked)))
+		}
+		if len(m.failures) > 0 && !m.showErrors {
+			statusBar += failureStyle.Render(fmt.Sprintf("  [%d failed · %s]", len(m.failures), keys.Errors.Help().Key))
+		}
 	}
 	
+	// Render disk usage totals
+	var totalsView string
+	if m.sizesLoaded {
+		t := fsutils.SummarizeSizes(m.combined)
+		totalsView = fmt.Sprintf("Linked %d (%s, %s in links) · Unlinked %d (%s) · Stale %d · Cache total %s",
+			t.LinkedCount, fsutils.FormatSize(t.LinkedBytes), fsutils.FormatSize(t.LinkedFileBytes),
+			t.UnlinkedCount, fsutils.FormatSize(t.UnlinkedBytes),
+			t.StaleCount, fsutils.FormatSize(t.TotalBytes))
+		if t.OtherCount > 0 {
+			totalsView += fmt.Sprintf(" (%d not linkable, %s)", t.OtherCount, fsutils.FormatSize(t.OtherBytes))
+		}
+	} else {
+		totalsView = "Calculating disk usage..."
+	}
+	totalsView = lipgloss.NewStyle().Foreground(color("muted")).Render(totalsView)
+	
 	// Render help
 	var helpView string
 	if m.showFullHelp {
@@ -648,7 +1949,13 @@ func (m model) View() string {
 			keys.LinkAll,
 			keys.UnlinkAll,
 			keys.PurgeAll,
+			keys.Prune,
+			keys.Delete,
+			keys.Verify,
+			keys.Drift,
 			keys.Search,
+			keys.Format,
+			keys.Details,
 			keys.ToggleHelp,
 			keys.Quit,
 		})
@@ -659,12 +1966,42 @@ func (m model) View() string {
 	if m.searching {
 		searchStyle := lipgloss.NewStyle().
 			Border(lipgloss.RoundedBorder()).
-			BorderForeground(lipgloss.Color("#7D56F4")).
+			BorderForeground(color("accent")).
 			Padding(0, 1)
 		
 		searchView = searchStyle.Render(m.searchInput.View())
 	}
 	
+	// The drift view takes the place of the list; a running bulk operation lists its latest
+	// results under it
+	listView := m.list.View()
+	if m.bulk != nil && len(m.bulk.log) > 0 {
+		l := m.list
+		l.SetHeight(l.Height() - len(m.bulk.log))
+		listView = lipgloss.JoinVertical(lipgloss.Left,
+			l.View(),
+			lipgloss.NewStyle().Foreground(color("muted")).Render(strings.Join(m.bulk.log, "\n")),
+		)
+	}
+	if m.showErrors {
+		listView = lipgloss.JoinVertical(lipgloss.Left,
+			titleStyle.Copy().Background(color("corrupt")).Render(fmt.Sprintf("%d failed step(s)  (%s retry · %s retry all · %s close)",
+				len(m.failures), keys.Retry.Help().Key, keys.RetryAll.Help().Key, keys.Errors.Help().Key)),
+			lipgloss.NewStyle().Height(m.list.Height()-1).Render(renderFailures(m.failures, m.errorCursor, m.list.Height()-1)),
+		)
+	} else if m.drift != nil {
+		listView = lipgloss.JoinVertical(lipgloss.Left,
+			titleStyle.Copy().Background(color("info")).Render("Drift from "+m.drift.path+": "+m.drift.plan.Summary()+"  ("+keys.Apply.Help().Key+" apply · "+keys.Drift.Help().Key+" close)"),
+			m.driftView.View(),
+		)
+	} else if m.showDetails {
+		listWidth := m.list.Width()
+		listView = lipgloss.JoinHorizontal(lipgloss.Top,
+			lipgloss.NewStyle().Width(listWidth).MaxWidth(listWidth).Render(listView),
+			detailPaneStyle.Render(m.detailView.View()),
+		)
+	}
+	
 	// Compose the UI
 	var view string
 	if m.searching {
@@ -672,7 +2009,8 @@ func (m model) View() string {
 			header,
 			infoSection,
 			searchView,
-			m.list.View(),
+			listView,
+			totalsView,
 			statusStyleWidth.Render(statusBar),
 			helpView,
 		)
@@ -680,7 +2018,8 @@ func (m model) View() string {
 		view = lipgloss.JoinVertical(lipgloss.Left,
 			header,
 			infoSection,
-			m.list.View(),
+			listView,
+			totalsView,
 			statusStyleWidth.Render(statusBar),
 			helpView,
 		)
@@ -692,9 +2031,23 @@ func (m model) View() string {
 // All the command helpers below are retained from the original implementation
 // but updated to work with the new UI
 
-func updateState(targetDir, status string) tea.Msg {
-	models, _ := fsutils.LoadModels(targetDir)
-	stale, _ := fsutils.FindStaleLinks(targetDir)
+// updateState rescans the models linked at the given target paths, or everything if the
+// cache or the target directory was changed by something else, and reports the new state
+func updateState(ix *fsutils.Index, status string, targets ...string) tea.Msg {
+	return stateMsg(ix, ix.Refresh(targets...), status)
+}
+
+// rescanState rescans the cache and the target directory and reports the new state
+func rescanState(ix *fsutils.Index, status string) tea.Msg {
+	return stateMsg(ix, ix.Scan(), status)
+}
+
+// stateMsg reports the indexed models, or the error of the scan that updated them
+func stateMsg(ix *fsutils.Index, err error, status string) tea.Msg {
+	if err != nil {
+		return scanErrorMsg{err: err, help: scanHelp(ix.TargetDir())}
+	}
+	models, stale := ix.Models()
 	return opResultMsg{
 		status: status,
 		models: models,
@@ -703,12 +2056,16 @@ func updateState(targetDir, status string) tea.Msg {
 }
 
 // linkModelCmd creates a command to link a model.
-func linkModelCmd(m fsutils.ModelInfo, targetDir string, logger *logger.Logger) tea.Cmd {
+func linkModelCmd(ctx context.Context, m fsutils.ModelInfo, mode fsutils.LinkMode, targetDir string, ix *fsutils.Index, j *journal.Journal, logger *logger.Logger) tea.Cmd {
 	return func() tea.Msg {
 		if logger != nil && logger.Verbose {
 			logger.Info("UI", "Linking model: %s/%s", m.OrganizationName, m.ModelName)
 		}
-		if err := fsutils.LinkModel(m); err != nil {
+		err := runStep(ctx, j, "link", targetDir, journal.LinkStep(m, fsutils.LinkOptions{Mode: mode}))
+		if errors.Is(err, context.Canceled) {
+			return errorMsg(fmt.Sprintf("Cancelled linking model %s", m.ModelName))
+		}
+		if err != nil {
 			if logger != nil && logger.Verbose {
 				logger.Error("UI", "Error linking model %s/%s: %v", m.OrganizationName, m.ModelName, err)
 			}
@@ -717,17 +2074,21 @@ func linkModelCmd(m fsutils.ModelInfo, targetDir string, logger *logger.Logger)
 		if logger != nil && logger.Verbose {
 			logger.Info("UI", "Successfully linked model: %s/%s", m.OrganizationName, m.ModelName)
 		}
-		return updateState(targetDir, fmt.Sprintf("Linked model: %s", m.ModelName))
+		return updateState(ix, fmt.Sprintf("Linked model: %s", m.ModelName), m.TargetPath)
 	}
 }
 
 // unlinkModelCmd creates a command to unlink a model.
-func unlinkModelCmd(m fsutils.ModelInfo, targetDir string, logger *logger.Logger) tea.Cmd {
+func unlinkModelCmd(ctx context.Context, m fsutils.ModelInfo, targetDir string, ix *fsutils.Index, j *journal.Journal, logger *logger.Logger) tea.Cmd {
 	return func() tea.Msg {
 		if logger != nil && logger.Verbose {
 			logger.Info("UI", "Unlinking model: %s/%s", m.OrganizationName, m.ModelName)
 		}
-		if err := fsutils.UnlinkModel(m); err != nil {
+		err := runStep(ctx, j, "unlink", targetDir, journal.UnlinkStep(m))
+		if errors.Is(err, context.Canceled) {
+			return errorMsg(fmt.Sprintf("Cancelled unlinking model %s", m.ModelName))
+		}
+		if err != nil {
 			if logger != nil && logger.Verbose {
 				logger.Error("UI", "Error unlinking model %s/%s: %v", m.OrganizationName, m.ModelName, err)
 			}
@@ -736,17 +2097,21 @@ func unlinkModelCmd(m fsutils.ModelInfo, targetDir string, logger *logger.Logger
 		if logger != nil && logger.Verbose {
 			logger.Info("UI", "Successfully unlinked model: %s/%s", m.OrganizationName, m.ModelName)
 		}
-		return updateState(targetDir, fmt.Sprintf("Unlinked model: %s", m.ModelName))
+		return updateState(ix, fmt.Sprintf("Unlinked model: %s", m.ModelName), m.TargetPath)
 	}
 }
 
 // purgeModelCmd creates a command to purge a stale model.
-func purgeModelCmd(m fsutils.ModelInfo, targetDir string, logger *logger.Logger) tea.Cmd {
+func purgeModelCmd(ctx context.Context, m fsutils.ModelInfo, targetDir string, ix *fsutils.Index, j *journal.Journal, logger *logger.Logger) tea.Cmd {
 	return func() tea.Msg {
 		if logger != nil && logger.Verbose {
 			logger.Info("UI", "Purging stale model: %s/%s (Reason: %s)", m.OrganizationName, m.ModelName, m.StaleReason)
 		}
-		if err := fsutils.UnlinkModel(m); err != nil {
+		err := runStep(ctx, j, "purge", targetDir, journal.UnlinkStep(m))
+		if errors.Is(err, context.Canceled) {
+			return errorMsg(fmt.Sprintf("Cancelled purging model %s", m.ModelName))
+		}
+		if err != nil {
 			if logger != nil && logger.Verbose {
 				logger.Error("UI", "Error purging stale model %s/%s: %v", m.OrganizationName, m.ModelName, err)
 			}
@@ -755,88 +2120,817 @@ func purgeModelCmd(m fsutils.ModelInfo, targetDir string, logger *logger.Logger)
 		if logger != nil && logger.Verbose {
 			logger.Info("UI", "Successfully purged stale model: %s/%s", m.OrganizationName, m.ModelName)
 		}
-		return updateState(targetDir, fmt.Sprintf("Purged stale model: %s", m.ModelName))
+		return updateState(ix, fmt.Sprintf("Purged stale model: %s", m.ModelName), m.TargetPath)
 	}
 }
 
-// linkAllCmd creates a command to link all unlinked models.
-func linkAllCmd(models []fsutils.ModelInfo, targetDir string, logger *logger.Logger) tea.Cmd {
-	return func() tea.Msg {
-		if logger != nil && logger.Verbose {
-			logger.Info("UI", "Linking all unlinked models (%d total)", len(models))
+// linkAllCmd creates a command to link all unlinked models of a supported format as one
+// journaled operation. Models that are still downloading are deferred until a later run;
+// excluded models are skipped.
+func linkAllCmd(ctx context.Context, models []fsutils.ModelInfo, mode fsutils.LinkMode, targetDir string, workers int, ix *fsutils.Index, j *journal.Journal, logger *logger.Logger) tea.Cmd {
+	if logger != nil && logger.Verbose {
+		logger.Info("UI", "Linking all unlinked models (%d total)", len(models))
+	}
+	deferredCount := 0
+	excludedCount := 0
+	var steps []journal.Step
+	for _, m := range models {
+		if m.IsExcluded && !m.IsLinked {
+			excludedCount++
+			if logger != nil && logger.Verbose {
+				logger.Debug("UI", "Skipped model %s/%s: %s", m.OrganizationName, m.ModelName, rules.Describe(m.ExcludedBy))
+			}
+			continue
+		}
+		if m.IsIncomplete && !m.IsLinked {
+			deferredCount++
+			if logger != nil && logger.Verbose {
+				logger.Debug("UI", "Deferred incomplete model: %s/%s (%s)", m.OrganizationName, m.ModelName, m.IncompleteReason)
+			}
+			continue
 		}
-		linkedCount := 0
-		for _, m := range models {
-			if !m.IsLinked {
-				if err := fsutils.LinkModel(m); err != nil {
+		if !m.IsLinked && m.IsLinkable() && m.Format != fsutils.FormatUnsupported {
+			steps = append(steps, journal.LinkStep(m, fsutils.LinkOptions{Files: fsutils.ExactFiles(m.RuleFiles), Mode: mode}))
+		}
+	}
+	return runBulkCmd(ctx, bulkOp{
+		kind:  "link all",
+		label: "Linking all models",
+		steps: steps,
+		summary: func(linkedCount int) string {
+			status := fmt.Sprintf("Successfully linked %d models", linkedCount)
+			if deferredCount > 0 {
+				status += fmt.Sprintf(" (%d incomplete download(s) deferred)", deferredCount)
+			}
+			if excludedCount > 0 {
+				status += fmt.Sprintf(" (%d excluded by rules)", excludedCount)
+			}
+			return status
+		},
+	}, targetDir, workers, ix, j, logger)
+}
+
+// unlinkAllCmd creates a command to unlink all linked models as one journaled operation.
+func unlinkAllCmd(ctx context.Context, models []fsutils.ModelInfo, targetDir string, workers int, ix *fsutils.Index, j *journal.Journal, logger *logger.Logger) tea.Cmd {
+	if logger != nil && logger.Verbose {
+		logger.Info("UI", "Unlinking all linked models")
+	}
+	var steps []journal.Step
+	for _, m := range models {
+		if m.IsLinked {
+			steps = append(steps, journal.UnlinkStep(m))
+		}
+	}
+	return runBulkCmd(ctx, bulkOp{
+		kind:  "unlink all",
+		label: "Unlinking all models",
+		steps: steps,
+		summary: func(unlinkedCount int) string {
+			return fmt.Sprintf("Successfully unlinked %d models", unlinkedCount)
+		},
+	}, targetDir, workers, ix, j, logger)
+}
+
+// purgeAllCmd creates a command to purge all stale links as one journaled operation.
+func purgeAllCmd(ctx context.Context, stale []fsutils.ModelInfo, targetDir string, workers int, ix *fsutils.Index, j *journal.Journal, logger *logger.Logger) tea.Cmd {
+	if logger != nil && logger.Verbose {
+		logger.Info("UI", "Purging all stale links (%d total)", len(stale))
+	}
+	var steps []journal.Step
+	for _, m := range stale {
+		steps = append(steps, journal.UnlinkStep(m))
+	}
+	return runBulkCmd(ctx, bulkOp{
+		kind:  "purge all",
+		label: "Purging all stale links",
+		steps: steps,
+		summary: func(purgedCount int) string {
+			return fmt.Sprintf("Successfully purged %d stale links", purgedCount)
+		},
+	}, targetDir, workers, ix, j, logger)
+}
+
+// runStep runs a single step as a journaled operation
+func runStep(ctx context.Context, j *journal.Journal, kind, targetDir string, step journal.Step) error {
+	errs, err := j.Run(ctx, kind, targetDir, []journal.Step{step})
+	if err != nil {
+		return err
+	}
+	return errs[0]
+}
+
+// bulkOp describes a bulk operation for runBulkCmd
+type bulkOp struct {
+	// kind names the operation in the journal, e.g. "link all"
+	kind string
+	// label is shown in the status bar while the operation runs
+	label string
+	steps []journal.Step
+	// summary returns the final status given how many steps succeeded
+	summary func(succeeded int) string
+	// keep are failures of an earlier operation that are still listed afterwards
+	keep []bulkFailure
+}
+
+// bulkFailure is a step of a bulk operation that failed
+type bulkFailure struct {
+	step journal.Step
+	err  error
+}
+
+// bulkDoneMsg ends a bulk operation with the rescanned state and the steps that failed
+type bulkDoneMsg struct {
+	result   tea.Msg
+	failures []bulkFailure
+}
+
+// bulkStepMsg reports a completed step of a bulk operation and carries the channel to
+// keep reading
+type bulkStepMsg struct {
+	label  string
+	step   journal.Step
+	err    error
+	done   int
+	total  int
+	failed int
+	ch     <-chan tea.Msg
+}
+
+// bulkProgressMsg reports the file a step of a bulk operation is linking and carries the
+// channel to keep reading
+type bulkProgressMsg struct {
+	label    string
+	step     journal.Step
+	total    int
+	progress fsutils.LinkProgress
+	ch       <-chan tea.Msg
+}
+
+// bulkLogLines is how many completed steps are listed while a bulk operation runs
+const bulkLogLines = 5
+
+// bulkState is the progress of a running bulk operation
+type bulkState struct {
+	done   int
+	total  int
+	failed int
+	// log holds the latest completed steps, oldest first
+	log []string
+}
+
+// addLog appends a completed step to the log, dropping the oldest beyond bulkLogLines
+func (b *bulkState) addLog(line string) {
+	b.log = append(b.log, line)
+	if len(b.log) > bulkLogLines {
+		b.log = b.log[len(b.log)-bulkLogLines:]
+	}
+}
+
+// percent returns the share of completed steps
+func (b *bulkState) percent() float64 {
+	if b.total == 0 {
+		return 0
+	}
+	return float64(b.done) / float64(b.total)
+}
+
+// describeStep returns the log line of a completed step
+func describeStep(step journal.Step, err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "cancelled " + step.Repo
+	case err != nil:
+		return failureStyle.Render(fmt.Sprintf("failed %s: %v", step.Repo, err))
+	case step.Action == journal.StepLink:
+		return "linked " + step.Repo
+	}
+	return "unlinked " + step.Repo
+}
+
+// runBulkCmd runs the steps of a bulk operation as one journaled operation on a pool of
+// workers in the background. Every completed step is streamed to the UI as it finishes;
+// the last message rescans the target directory and lists the steps that failed. Once ctx
+// is cancelled, steps that have not started are skipped.
+func runBulkCmd(ctx context.Context, op bulkOp, targetDir string, workers int, ix *fsutils.Index, j *journal.Journal, logger *logger.Logger) tea.Cmd {
+	// Room for every message, so workers never wait for the UI
+	ch := make(chan tea.Msg, len(op.steps)+1)
+	go func() {
+		l, err := lockDirs(targetDir)
+		if err != nil {
+			ch <- opDoneMsg{msg: errorMsg(lockMessage(targetDir, err))}
+			return
+		}
+		defer l.Release()
+
+		done := 0
+		failures := append([]bulkFailure{}, op.keep...)
+		failed := 0
+		errs, err := j.RunWith(ctx, op.kind, targetDir, op.steps, journal.RunOptions{
+			Workers: workers,
+			// Progress is only sent while the UI keeps up, so it never delays a step report
+			OnProgress: func(i int, p fsutils.LinkProgress) {
+				if len(ch) > 0 {
+					return
+				}
+				select {
+				case ch <- bulkProgressMsg{label: op.label, step: op.steps[i], total: len(op.steps), progress: p, ch: ch}:
+				default:
+				}
+			},
+			OnStep: func(i int, stepErr error) {
+				step := op.steps[i]
+				done++
+				if stepErr != nil && errors.Is(stepErr, context.Canceled) {
 					if logger != nil && logger.Verbose {
-						logger.Error("UI", "Error linking model %s/%s: %v", m.OrganizationName, m.ModelName, err)
+						logger.Debug("UI", "%s of %s cancelled", step.Action, step.Repo)
 					}
-				} else {
-					linkedCount++
+				} else if stepErr != nil {
+					failed++
+					failures = append(failures, bulkFailure{step: step, err: stepErr})
 					if logger != nil && logger.Verbose {
-						logger.Debug("UI", "Linked model: %s/%s", m.OrganizationName, m.ModelName)
+						logger.Error("UI", "Error during %s of %s: %v", step.Action, step.Repo, stepErr)
 					}
+				} else if logger != nil && logger.Verbose {
+					logger.Debug("UI", "%s: %s", step.Action, step.Repo)
 				}
+				ch <- bulkStepMsg{label: op.label, step: step, err: stepErr, done: done, total: len(op.steps), failed: failed, ch: ch}
+			},
+		})
+		if err != nil {
+			ch <- opDoneMsg{msg: errorMsg(fmt.Sprintf("Error during %s: %v", op.kind, err))}
+			return
+		}
+		skipped := 0
+		for _, stepErr := range errs {
+			if errors.Is(stepErr, context.Canceled) {
+				skipped++
 			}
 		}
+		status := op.summary(len(op.steps) - failed - skipped)
 		if logger != nil && logger.Verbose {
-			logger.Info("UI", "Successfully linked %d models", linkedCount)
+			logger.Info("UI", "%s", status)
+		}
+		if failed > 0 {
+			status += fmt.Sprintf("; %d failed (press %s for details)", failed, keys.Errors.Help().Key)
+		}
+		if skipped > 0 {
+			status = fmt.Sprintf("Cancelled after %d of %d: %s", len(op.steps)-skipped, len(op.steps), status)
+		}
+		sort.SliceStable(failures, func(a, b int) bool { return failures[a].step.Repo < failures[b].step.Repo })
+		targets := make([]string, len(op.steps))
+		for i, step := range op.steps {
+			targets[i] = step.Target
+		}
+		ch <- opDoneMsg{msg: bulkDoneMsg{result: updateState(ix, status, targets...), failures: failures}}
+	}()
+	return waitForBulkCmd(ch)
+}
+
+// waitForBulkCmd waits for the next message from a running bulk operation
+func waitForBulkCmd(ch <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-ch
+	}
+}
+
+// applyStep shows the outcome of a completed step in the list without waiting for the
+// rescan at the end of the operation
+func (m *model) applyStep(step journal.Step) {
+	for i := range m.models {
+		if m.models[i].TargetPath == step.Target {
+			m.models[i].IsLinked = step.Action == journal.StepLink
+		}
+	}
+	if step.Action == journal.StepUnlink {
+		stale := m.stale[:0:0]
+		for _, s := range m.stale {
+			if s.TargetPath != step.Target {
+				stale = append(stale, s)
+			}
+		}
+		m.stale = stale
+	}
+	m.setModels(m.models, m.stale)
+}
+
+
+// retry runs failed steps again as one journaled operation; keep are the failures that
+// stay listed
+func (m model) retry(selected, keep []bulkFailure) (tea.Model, tea.Cmd) {
+	steps := make([]journal.Step, len(selected))
+	for i, f := range selected {
+		steps[i] = f.step
+	}
+	m.status = fmt.Sprintf("Retrying %d failed step(s)...", len(steps))
+	m.loading = true
+	if m.logger != nil && m.logger.Verbose {
+		m.logger.Info("UI", "Retrying %d failed step(s)", len(steps))
+	}
+	return m, tea.Batch(m.spinner.Tick, runBulkCmd(m.startOp(), bulkOp{
+		kind:  "retry",
+		label: "Retrying failed steps",
+		steps: steps,
+		summary: func(succeeded int) string {
+			return fmt.Sprintf("Retried %d of %d step(s) successfully", succeeded, len(steps))
+		},
+		keep: keep,
+	}, m.targetDir, m.workers, m.index, m.journal, m.logger))
+}
+
+// renderFailures lists failed steps with their causes, keeping the selected one in view
+func renderFailures(failures []bulkFailure, cursor, height int) string {
+	start := 0
+	if height > 0 && cursor >= height {
+		start = cursor - height + 1
+	}
+	var lines []string
+	for i := start; i < len(failures) && (height <= 0 || i < start+height); i++ {
+		f := failures[i]
+		prefix := "  "
+		line := fmt.Sprintf("%-7s %-50s %v", f.step.Action, f.step.Repo, f.err)
+		if i == cursor {
+			prefix = "> "
+			line = lipgloss.NewStyle().Bold(true).Render(line)
 		}
-		return updateState(targetDir, fmt.Sprintf("Successfully linked %d models", linkedCount))
+		lines = append(lines, prefix+failureStyle.Render("!")+" "+line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// prunePlanMsg carries a prune plan waiting for confirmation
+type prunePlanMsg fsutils.PrunePlan
+
+// describePrunePlan summarizes what a prune plan removes and how much space it reclaims
+func describePrunePlan(plan fsutils.PrunePlan) string {
+	counts := map[fsutils.PruneKind]int{}
+	for _, item := range plan.Items {
+		counts[item.Kind]++
 	}
+	return fmt.Sprintf("Prune %d unused revision(s), %d orphan blob(s) and %d incomplete download(s), reclaiming %s",
+		counts[fsutils.PruneRevision], counts[fsutils.PruneOrphanBlob], counts[fsutils.PruneIncomplete],
+		fsutils.FormatSize(plan.ReclaimableBytes))
 }
 
-// unlinkAllCmd creates a command to unlink all linked models.
-func unlinkAllCmd(models []fsutils.ModelInfo, targetDir string, logger *logger.Logger) tea.Cmd {
+// planPruneCmd creates a command that finds prunable revisions and blobs. Revisions linked
+// from any of the targets are kept.
+func planPruneCmd(ctx context.Context, targets []string, logger *logger.Logger) tea.Cmd {
 	return func() tea.Msg {
+		caches, err := fsutils.GetHfCacheDirs()
+		if err != nil {
+			return errorMsg(fmt.Sprintf("Error determining Hugging Face cache: %v", err))
+		}
+		plan, err := fsutils.PlanPruneCaches(ctx, caches, targets, fsutils.PruneOptions{})
+		if errors.Is(err, context.Canceled) {
+			return errorMsg("Cancelled pruning")
+		}
+		if err != nil {
+			if logger != nil && logger.Verbose {
+				logger.Error("UI", "Error planning prune: %v", err)
+			}
+			return errorMsg(fmt.Sprintf("Error scanning cache for pruning: %v", err))
+		}
 		if logger != nil && logger.Verbose {
-			logger.Info("UI", "Unlinking all linked models")
+			logger.Info("UI", "Prune plan: %d item(s), %d reclaimable bytes", len(plan.Items), plan.ReclaimableBytes)
 		}
-		unlinkedCount := 0
-		for _, m := range models {
-			if m.IsLinked {
-				if err := fsutils.UnlinkModel(m); err != nil {
-					if logger != nil && logger.Verbose {
-						logger.Error("UI", "Error unlinking model %s/%s: %v", m.OrganizationName, m.ModelName, err)
-					}
-				} else {
-					unlinkedCount++
-					if logger != nil && logger.Verbose {
-						logger.Debug("UI", "Unlinked model: %s/%s", m.OrganizationName, m.ModelName)
-					}
-				}
+		return prunePlanMsg(plan)
+	}
+}
+
+// executePruneCmd creates a command that deletes the items of a confirmed prune plan. The
+// first target is the managed one the prune is recorded against.
+func executePruneCmd(ctx context.Context, plan fsutils.PrunePlan, targets []string, ix *fsutils.Index, j *journal.Journal, logger *logger.Logger) tea.Cmd {
+	return func() tea.Msg {
+		targetDir := targets[0]
+		reclaimed, err := fsutils.ExecutePrune(ctx, plan, targets)
+		if reclaimed > 0 {
+			recordIrreversible(j, "prune", targetDir, fmt.Sprintf("%d item(s), %s", len(plan.Items), fsutils.FormatSize(reclaimed)), logger)
+		}
+		if errors.Is(err, context.Canceled) {
+			return rescanState(ix, fmt.Sprintf("Cancelled pruning after reclaiming %s", fsutils.FormatSize(reclaimed)))
+		}
+		if err != nil {
+			if logger != nil && logger.Verbose {
+				logger.Error("UI", "Error pruning cache: %v", err)
 			}
+			return rescanState(ix, fmt.Sprintf("Pruned %s with errors: %v", fsutils.FormatSize(reclaimed), err))
 		}
 		if logger != nil && logger.Verbose {
-			logger.Info("UI", "Successfully unlinked %d models", unlinkedCount)
+			logger.Info("UI", "Pruned cache, reclaimed %d bytes", reclaimed)
 		}
-		return updateState(targetDir, fmt.Sprintf("Successfully unlinked %d models", unlinkedCount))
+		return rescanState(ix, fmt.Sprintf("Pruned cache, reclaimed %s", fsutils.FormatSize(reclaimed)))
 	}
 }
 
-// purgeAllCmd creates a command to purge all stale links.
-func purgeAllCmd(stale []fsutils.ModelInfo, targetDir string, logger *logger.Logger) tea.Cmd {
+// undoCmd creates a command that reverses the last journaled operation.
+func undoCmd(ix *fsutils.Index, j *journal.Journal, logger *logger.Logger) tea.Cmd {
 	return func() tea.Msg {
+		ops, err := j.Undo(1)
+		if err != nil {
+			if logger != nil && logger.Verbose {
+				logger.Error("UI", "Error undoing: %v", err)
+			}
+			return errorMsg(fmt.Sprintf("Error undoing: %v", err))
+		}
 		if logger != nil && logger.Verbose {
-			logger.Info("UI", "Purging all stale links (%d total)", len(stale))
+			logger.Info("UI", "Undid %s", ops[0].Describe())
 		}
-		purgedCount := 0
-		for _, m := range stale {
-			if err := fsutils.UnlinkModel(m); err != nil {
-				if logger != nil && logger.Verbose {
-					logger.Error("UI", "Error purging stale model %s/%s: %v", m.OrganizationName, m.ModelName, err)
-				}
-			} else {
-				purgedCount++
+		var targets []string
+		for _, step := range ops[0].Steps {
+			targets = append(targets, step.Target)
+		}
+		return updateState(ix, "Undid "+ops[0].Describe(), targets...)
+	}
+}
+
+// lockDirs locks the target directories and the Hugging Face caches against other
+// hf-lms-sync processes, then cleans up links an interrupted run left half-built
+func lockDirs(targetDirs ...string) (*lock.Lock, error) {
+	caches, _ := fsutils.GetHfCacheDirs()
+	l, err := lock.Acquire(append(append([]string{}, targetDirs...), caches...)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range targetDirs {
+		fsutils.CleanupStaging(dir)
+	}
+	return l, nil
+}
+
+// lockMessage explains why a lock could not be acquired
+func lockMessage(targetDir string, err error) string {
+	var held *lock.HeldError
+	if errors.As(err, &held) && held.Holder.PID == os.Getpid() {
+		return "Another operation is still running; try again when it finishes."
+	}
+	if errors.As(err, &held) {
+		return fmt.Sprintf("Another hf-lms-sync (%s) is changing %s; try again when it finishes.", held.Holder, filepath.Dir(held.Path))
+	}
+	return fmt.Sprintf("Cannot lock %s: %v", targetDir, err)
+}
+
+// withLock wraps a command that changes the target directory or the Hugging Face cache so
+// that it fails instead of racing another hf-lms-sync process, such as a scheduled run.
+func withLock(targetDir string, cmd tea.Cmd) tea.Cmd {
+	return withLocks([]string{targetDir}, cmd)
+}
+
+// withLocks is withLock for a command that reads or changes several target directories.
+// The first directory is the one named if the lock cannot be acquired.
+func withLocks(targetDirs []string, cmd tea.Cmd) tea.Cmd {
+	return func() tea.Msg {
+		l, err := lockDirs(targetDirs...)
+		if err != nil {
+			return errorMsg(lockMessage(targetDirs[0], err))
+		}
+		defer l.Release()
+		return cmd()
+	}
+}
+
+// recordIrreversible adds an operation that cannot be undone to the history
+func recordIrreversible(j *journal.Journal, kind, targetDir, note string, logger *logger.Logger) {
+	if err := j.Record(kind, targetDir, note); err != nil && logger != nil && logger.Verbose {
+		logger.Error("UI", "Error recording %s in the journal: %v", kind, err)
+	}
+}
+
+// deletePlanMsg describes a model deletion waiting for confirmation
+type deletePlanMsg struct {
+	model   fsutils.ModelInfo
+	size    int64
+	targets []string
+}
+
+// describeDeletePlan summarizes what deleting a model frees and which targets lose a link
+func describeDeletePlan(plan deletePlanMsg, primary string) string {
+	desc := fmt.Sprintf("Delete %s from the Hugging Face cache, freeing %s?", plan.model.RepoID(), fsutils.FormatSize(plan.size))
+	var others []string
+	for _, target := range plan.targets {
+		if target != primary {
+			others = append(others, target)
+		}
+	}
+	if len(others) > 0 {
+		desc += " Also linked in: " + strings.Join(others, ", ") + "."
+	}
+	return desc
+}
+
+// planDeleteCmd creates a command that measures a model and finds the targets linking it.
+func planDeleteCmd(ctx context.Context, m fsutils.ModelInfo, targets []string) tea.Cmd {
+	return func() tea.Msg {
+		plan := deletePlanMsg{
+			model:   m,
+			size:    fsutils.DirSize(m.SourcePath),
+			targets: fsutils.LinkedTargets(m, targets),
+		}
+		if ctx.Err() != nil {
+			return errorMsg("Cancelled deleting " + m.RepoID())
+		}
+		return plan
+	}
+}
+
+// deleteModelCmd creates a command that unlinks a model everywhere and deletes it from the cache.
+func deleteModelCmd(m fsutils.ModelInfo, targets []string, targetDir string, ix *fsutils.Index, j *journal.Journal, logger *logger.Logger) tea.Cmd {
+	return func() tea.Msg {
+		if logger != nil && logger.Verbose {
+			logger.Info("UI", "Deleting model from cache: %s (%s)", m.RepoID(), m.SourcePath)
+		}
+		if err := fsutils.DeleteModel(m, targets); err != nil {
+			if logger != nil && logger.Verbose {
+				logger.Error("UI", "Error deleting model %s: %v", m.RepoID(), err)
+			}
+			return errorMsg(fmt.Sprintf("Error deleting model %s: %v", m.ModelName, err))
+		}
+		recordIrreversible(j, "delete", targetDir, m.RepoID(), logger)
+		if logger != nil && logger.Verbose {
+			logger.Info("UI", "Successfully deleted model: %s", m.RepoID())
+		}
+		return updateState(ix, fmt.Sprintf("Deleted model from cache: %s", m.RepoID()), m.TargetPath)
+	}
+}
+
+// verifyProgressMsg reports verification progress and carries the channel to keep reading
+type verifyProgressMsg struct {
+	progress fsutils.VerifyProgress
+	ch       <-chan tea.Msg
+}
+
+// verifyDoneMsg carries the results of a verification run
+type verifyDoneMsg struct {
+	results []fsutils.VerifyResult
+	// cancelled is set when the run was stopped and results cover only some models
+	cancelled bool
+}
+
+// startVerify hashes the given models in the background and shows a progress bar
+func (m model) startVerify(models []fsutils.ModelInfo) (tea.Model, tea.Cmd) {
+	m.verifying = true
+	m.verifyState = fsutils.VerifyProgress{}
+	m.status = fmt.Sprintf("Verifying %d model(s)...", len(models))
+	if m.logger != nil && m.logger.Verbose {
+		m.logger.Info("UI", "Verifying %d model(s)", len(models))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.verifyCancel = cancel
+	return m, verifyCmd(ctx, models, m.verifyCache)
+}
+
+// verifyCmd runs the verification in a goroutine and streams its progress over a channel.
+// Progress updates are dropped while the UI is still busy with the previous one.
+func verifyCmd(ctx context.Context, models []fsutils.ModelInfo, cache *fsutils.VerifyCache) tea.Cmd {
+	ch := make(chan tea.Msg, 1)
+	go func() {
+		results := fsutils.VerifyModels(ctx, models, cache, 0, func(p fsutils.VerifyProgress) {
+			select {
+			case ch <- verifyProgressMsg{progress: p, ch: ch}:
+			default:
+			}
+		})
+		ch <- verifyDoneMsg{results: results, cancelled: ctx.Err() != nil}
+	}()
+	return waitForVerifyCmd(ch)
+}
+
+// waitForVerifyCmd waits for the next message from a running verification
+func waitForVerifyCmd(ch <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-ch
+	}
+}
+
+// describeVerifyResults summarizes a verification run for the status bar
+func describeVerifyResults(results []fsutils.VerifyResult) string {
+	var files, corrupt int
+	for _, r := range results {
+		files += r.Checked
+		if len(r.Issues) > 0 {
+			corrupt++
+		}
+	}
+	if corrupt == 0 {
+		return fmt.Sprintf("Verified %d file(s) in %d model(s): all OK", files, len(results))
+	}
+	return fmt.Sprintf("Verified %d file(s) in %d model(s): %d corrupt", files, len(results), corrupt)
+}
+
+// detailsMsg carries the details of a model for the detail pane
+type detailsMsg struct {
+	key     string
+	model   fsutils.ModelInfo
+	details fsutils.ModelDetails
+	err     error
+}
+
+// detailsCmd reads the details of a model; key identifies the request
+func detailsCmd(key string, mdl fsutils.ModelInfo) tea.Cmd {
+	return func() tea.Msg {
+		details, err := fsutils.LoadDetails(mdl)
+		return detailsMsg{key: key, model: mdl, details: details, err: err}
+	}
+}
+
+// detailKey identifies a model in a state whose details may differ from other states
+func detailKey(mdl fsutils.ModelInfo) string {
+	return fmt.Sprint(mdl.CacheDirName, mdl.IsLinked, mdl.IsStale, mdl.LinkedAt.UnixNano(), mdl.ModifiedAt.UnixNano())
+}
+
+// layout splits the width between the list and the detail pane while it is shown
+func (m *model) layout() {
+	width := m.width
+	if m.showDetails {
+		// The pane takes the right half, less its border and padding
+		width = (m.width - 4) / 2
+		m.detailView.Width = m.width - 4 - width - 2
+		m.detailView.Height = m.list.Height()
+	}
+	m.list.SetWidth(width)
+}
+
+// followCursor asks for the details of the model under the cursor while the detail pane is
+// shown, unless they are already there
+func (m *model) followCursor() tea.Cmd {
+	if !m.showDetails {
+		return nil
+	}
+	item, ok := m.list.SelectedItem().(modelItem)
+	if !ok {
+		m.detailFor, m.detailShown = "", ""
+		m.detailView.SetContent("No model selected.")
+		return nil
+	}
+	key := detailKey(item.model)
+	if key == m.detailFor {
+		return nil
+	}
+	m.detailFor = key
+	// The details of the model shown stay up while they are read again
+	if item.model.CacheDirName != m.detailShown {
+		m.detailView.SetContent("Loading " + item.model.RepoID() + "...")
+		m.detailView.GotoTop()
+	}
+	return detailsCmd(key, item.model)
+}
+
+// renderDetails renders the detail pane for a model
+func renderDetails(mdl fsutils.ModelInfo, d fsutils.ModelDetails, err error) string {
+	label := lipgloss.NewStyle().Foreground(color("muted"))
+	section := lipgloss.NewStyle().Bold(true)
+	field := func(name, value string) string {
+		return label.Render(fmt.Sprintf("%-9s", name)) + " " + value
+	}
+
+	lines := []string{section.Render(mdl.RepoID()), ""}
+	source := mdl.SourcePath
+	if mdl.IsStale {
+		source += " (gone)"
+	}
+	lines = append(lines, field("Source", source))
+	if d.Revision != "" {
+		revision := d.Revision
+		if len(d.Refs) > 0 {
+			revision += " (" + strings.Join(d.Refs, ", ") + ")"
+		}
+		lines = append(lines, field("Revision", revision))
+	}
+	if err != nil {
+		lines = append(lines, failureStyle.Render(err.Error()))
+	}
+	if mdl.TargetPath == "" {
+		lines = append(lines, field("Target", "none ("+mdl.NotLinkableReason()+")"))
+	} else {
+		lines = append(lines, field("Target", mdl.TargetPath))
+	}
+	if marker := d.Marker; marker != nil {
+		mode := marker.Mode
+		if mode == "" {
+			mode = fsutils.LinkSymlink
+		}
+		linked := string(mode)
+		if !marker.LinkedAt.IsZero() {
+			linked = marker.LinkedAt.Local().Format("2006-01-02 15:04:05") + " · " + linked
+		}
+		lines = append(lines, field("Linked", linked))
+		if marker.Revision != "" && marker.Revision != d.Revision {
+			lines = append(lines, field("", failureStyle.Render("revision "+marker.Revision+" is no longer in the cache")))
+		}
+		if len(marker.Files) > 0 {
+			lines = append(lines, field("Files", strings.Join(marker.Files, ", ")))
+		}
+	} else if mdl.IsLinkable() {
+		lines = append(lines, field("Linked", "no"))
+	}
+
+	if len(d.Files) > 0 {
+		var total int64
+		for _, f := range d.Files {
+			total += f.Size
+		}
+		lines = append(lines, "", section.Render(fmt.Sprintf("Snapshot files (%d, %s)", len(d.Files), fsutils.FormatSize(total))))
+		for _, f := range d.Files {
+			health := ""
+			if f.Health != "" {
+				health = healthStyles[f.Health].Render(fmt.Sprintf("%-12s", f.Health))
+			}
+			lines = append(lines, fmt.Sprintf("%9s %s %s", fsutils.FormatSize(f.Size), health, f.Path))
+		}
+	}
+
+	if d.GGUFFile != "" {
+		lines = append(lines, "", section.Render("GGUF metadata")+label.Render(" "+d.GGUFFile))
+		if d.GGUFErr != nil {
+			lines = append(lines, failureStyle.Render(d.GGUFErr.Error()))
+		} else {
+			for _, f := range d.GGUF.Summary() {
+				lines = append(lines, label.Render(fmt.Sprintf("%-20s", f.Key))+" "+f.Value)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// driftMsg carries the drift of the target directory from a desired-state file
+type driftMsg struct {
+	path string
+	plan desired.Plan
+}
+
+// planDriftCmd loads the desired-state file and compares it with the target directory
+func planDriftCmd(path, targetDir string, ruleSet *rules.Set) tea.Cmd {
+	return func() tea.Msg {
+		if path == "" {
+			found, err := desired.FindFile()
+			if err != nil {
+				return errorMsg(fmt.Sprintf("Error finding desired state: %v", err))
+			}
+			path = found
+		}
+		state, err := desired.Load(path)
+		if err != nil {
+			return errorMsg(fmt.Sprintf("Error loading desired state: %v", err))
+		}
+		models, err := fsutils.LoadModels(targetDir)
+		if err != nil {
+			return errorMsg(fmt.Sprintf("Error loading models: %v", err))
+		}
+		ruleSet.Apply(models)
+		stale, _ := fsutils.FindStaleLinks(targetDir)
+		return driftMsg{path: path, plan: desired.Diff(state, models, stale, desired.Options{})}
+	}
+}
+
+// renderDrift renders one line per action of a drift plan
+func renderDrift(plan desired.Plan) string {
+	if len(plan.Actions) == 0 {
+		return "The desired state lists no models and nothing is linked."
+	}
+	var lines []string
+	for _, a := range plan.Actions {
+		style := driftStyles[a.Kind]
+		reason := a.Reason
+		if a.Kind == desired.ActionOK {
+			reason = "in sync"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %-60s %s",
+			style.Render(driftIcons[a.Kind]), style.Render(fmt.Sprintf("%-11s", a.Kind)), a.Repo, reason))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// applyDriftCmd converges the target directory to the desired state
+func applyDriftCmd(ctx context.Context, plan desired.Plan, targetDir string, ix *fsutils.Index, j *journal.Journal, logger *logger.Logger) tea.Cmd {
+	return func() tea.Msg {
+		results, err := desired.Apply(ctx, plan, j, "apply", targetDir)
+		if err != nil {
+			return errorMsg(fmt.Sprintf("Error applying desired state: %v", err))
+		}
+		applied, skipped := 0, 0
+		var targets []string
+		var failures []bulkFailure
+		for _, r := range results {
+			targets = append(targets, r.Action.Model.TargetPath)
+			if errors.Is(r.Err, context.Canceled) {
+				skipped++
+				continue
+			}
+			if r.Err != nil {
+				failures = append(failures, bulkFailure{step: r.Step, err: r.Err})
 				if logger != nil && logger.Verbose {
-					logger.Debug("UI", "Purged stale model: %s/%s", m.OrganizationName, m.ModelName)
+					logger.Error("UI", "%s %s failed: %v", r.Action.Kind, r.Action.Repo, r.Err)
 				}
+				continue
 			}
+			applied++
 		}
-		if logger != nil && logger.Verbose {
-			logger.Info("UI", "Successfully purged %d stale links", purgedCount)
+		status := fmt.Sprintf("Applied desired state: %d change(s)", applied)
+		if len(failures) > 0 {
+			status += fmt.Sprintf(", %d failed", len(failures))
+		}
+		if skipped > 0 {
+			status = fmt.Sprintf("Cancelled: %s, %d skipped", status, skipped)
 		}
-		return updateState(targetDir, fmt.Sprintf("Successfully purged %d stale links", purgedCount))
+		// Failed actions are listed in the error pane, from where they can be retried
+		return bulkDoneMsg{result: updateState(ix, status, targets...), failures: failures}
 	}
 }
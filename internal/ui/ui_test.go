@@ -0,0 +1,203 @@
+package ui
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+	"github.com/jmfirth/hf-lms-sync/internal/search"
+)
+
+// testModel returns an unlinked GGUF model.
+func testModel(org, name string) fsutils.ModelInfo {
+	return fsutils.ModelInfo{
+		RepoType:         fsutils.RepoTypeModel,
+		CacheDirName:     "models--" + org + "--" + name,
+		OrganizationName: org,
+		ModelName:        name,
+		Format:           fsutils.FormatGGUF,
+	}
+}
+
+// repoIDs returns the organization/name of each model.
+func repoIDs(models []fsutils.ModelInfo) []string {
+	ids := []string{}
+	for _, m := range models {
+		ids = append(ids, m.RepoID())
+	}
+	return ids
+}
+
+// TestSortModels tests each sort order, including ties and models without a date.
+func TestSortModels(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	with := func(m fsutils.ModelInfo, size int64, modified, linked time.Time) fsutils.ModelInfo {
+		m.Size, m.ModifiedAt, m.LinkedAt = size, modified, linked
+		return m
+	}
+	models := []fsutils.ModelInfo{
+		with(testModel("zeta", "Alpha"), 10, day, time.Time{}),
+		with(testModel("Meta", "llama"), 30, time.Time{}, day),
+		with(testModel("acme", "beta"), 10, day.Add(time.Hour), day.Add(time.Hour)),
+		with(testModel("acme", "Alpha"), 20, day, time.Time{}),
+		with(testModel("meta", "Beta"), 10, time.Time{}, day),
+	}
+	cases := []struct {
+		by       string
+		expected []string
+	}{
+		// Organizations and names compare without regard to case
+		{"", []string{"acme/Alpha", "acme/beta", "meta/Beta", "Meta/llama", "zeta/Alpha"}},
+		// Equal names are ordered by organization
+		{"name", []string{"acme/Alpha", "zeta/Alpha", "acme/beta", "meta/Beta", "Meta/llama"}},
+		// Largest first, equal sizes by organization and name
+		{"size", []string{"Meta/llama", "acme/Alpha", "acme/beta", "meta/Beta", "zeta/Alpha"}},
+		// Newest first, models without a date last
+		{"modified", []string{"acme/beta", "acme/Alpha", "zeta/Alpha", "meta/Beta", "Meta/llama"}},
+		{"linked", []string{"acme/beta", "meta/Beta", "Meta/llama", "acme/Alpha", "zeta/Alpha"}},
+	}
+	for _, tc := range cases {
+		if got := repoIDs(sortModels(models, tc.by)); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("sortModels(%q) = %v, want %v", tc.by, got, tc.expected)
+		}
+	}
+	if models[0].ModelName != "Alpha" || models[0].OrganizationName != "zeta" {
+		t.Errorf("expected sortModels to leave its input unchanged")
+	}
+}
+
+// TestVisibleModels tests that the format and status filters and the search combine.
+func TestVisibleModels(t *testing.T) {
+	linkedGGUF := testModel("meta", "llama-gguf")
+	linkedGGUF.IsLinked = true
+	mlx := testModel("meta", "llama-mlx")
+	mlx.Format = fsutils.FormatMLX
+	excluded := testModel("meta", "llama-excluded")
+	excluded.IsExcluded = true
+	stale := testModel("gone", "llama-stale")
+	stale.IsStale, stale.IsLinked, stale.Format = true, true, ""
+	combined := []fsutils.ModelInfo{
+		testModel("meta", "llama-unlinked"), linkedGGUF, mlx, excluded, stale, testModel("qwen", "qwen-unlinked"),
+	}
+
+	cases := []struct {
+		format   fsutils.ModelFormat
+		status   string
+		query    string
+		expected []string
+	}{
+		{"", "", "", []string{"gone/llama-stale", "meta/llama-excluded", "meta/llama-gguf", "meta/llama-mlx", "meta/llama-unlinked", "qwen/qwen-unlinked"}},
+		// Stale links have no format but stay visible to be purged
+		{fsutils.FormatGGUF, "", "", []string{"gone/llama-stale", "meta/llama-excluded", "meta/llama-gguf", "meta/llama-unlinked", "qwen/qwen-unlinked"}},
+		{fsutils.FormatGGUF, "unlinked", "", []string{"meta/llama-unlinked", "qwen/qwen-unlinked"}},
+		{fsutils.FormatGGUF, "unlinked", "llama", []string{"meta/llama-unlinked"}},
+		{"", "stale", "llama", []string{"gone/llama-stale"}},
+		{fsutils.FormatMLX, "linked", "", []string{}},
+		{"", "excluded", "llama", []string{"meta/llama-excluded"}},
+		// An invalid query does not narrow the list
+		{fsutils.FormatMLX, "", "status:bogus", []string{"gone/llama-stale", "meta/llama-mlx"}},
+	}
+	for _, tc := range cases {
+		m := model{combined: combined, formatFilter: tc.format, statusFilter: tc.status}
+		m.query, m.queryErr = search.Parse(tc.query)
+		if got := repoIDs(m.visibleModels()); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("format %q, status %q, query %q: got %v, want %v", tc.format, tc.status, tc.query, got, tc.expected)
+		}
+	}
+}
+
+// TestFilterCycles tests that the status and format filters cycle through every value
+// back to showing everything.
+func TestFilterCycles(t *testing.T) {
+	status, seen := "", []string{}
+	for i := 0; i <= len(search.Statuses); i++ {
+		status = nextStatusFilter(status)
+		seen = append(seen, status)
+	}
+	if expected := append(append([]string{}, search.Statuses...), ""); !reflect.DeepEqual(seen, expected) {
+		t.Errorf("status filter cycles through %v, want %v", seen, expected)
+	}
+	if nextStatusFilter("bogus") != "" {
+		t.Errorf("expected an unknown status filter to reset to everything")
+	}
+	if nextFormatFilter(fsutils.FormatUnsupported) != "" || nextFormatFilter("") != fsutils.FormatGGUF {
+		t.Errorf("unexpected format filter cycle")
+	}
+}
+
+// TestMarkSpan tests ranges in both directions and an anchor hidden by a filter.
+func TestMarkSpan(t *testing.T) {
+	visible := []fsutils.ModelInfo{testModel("a", "one"), testModel("a", "two"), testModel("a", "three"), testModel("a", "four")}
+	cases := []struct {
+		anchor       string
+		cursor       int
+		from, to     int
+		wantAnchored bool
+	}{
+		{"models--a--two", 3, 1, 3, true},
+		// The cursor moved up from the anchor
+		{"models--a--four", 0, 0, 3, true},
+		{"models--a--three", 2, 2, 2, true},
+		// The anchor is filtered out or was never set
+		{"models--a--hidden", 2, 2, 2, false},
+		{"", 1, 1, 1, false},
+		// A cursor past the end of a list that shrank
+		{"models--a--one", 7, 0, 3, true},
+	}
+	for _, tc := range cases {
+		from, to, anchored := markSpan(visible, tc.anchor, tc.cursor)
+		if from != tc.from || to != tc.to || anchored != tc.wantAnchored {
+			t.Errorf("markSpan(%q, %d) = %d, %d, %v; want %d, %d, %v", tc.anchor, tc.cursor, from, to, anchored, tc.from, tc.to, tc.wantAnchored)
+		}
+	}
+}
+
+// TestMarkedOp tests which selected models each action applies to and how the skipped
+// ones are reported.
+func TestMarkedOp(t *testing.T) {
+	unlinked := testModel("org", "unlinked")
+	linked := testModel("org", "linked")
+	linked.IsLinked = true
+	stale := testModel("org", "stale")
+	stale.IsStale, stale.IsLinked = true, true
+	incomplete := testModel("org", "incomplete")
+	incomplete.IsIncomplete = true
+	unsupported := testModel("org", "unsupported")
+	unsupported.Format = fsutils.FormatUnsupported
+	noOrg := testModel("", "gpt2")
+	dataset := testModel("org", "dataset")
+	dataset.RepoType = fsutils.RepoTypeDataset
+	marked := []fsutils.ModelInfo{unlinked, linked, stale, incomplete, unsupported, noOrg, dataset}
+
+	cases := []struct {
+		action  string
+		repos   []string
+		label   string
+		summary string
+	}{
+		{markLink, []string{"org/unlinked"}, "Linking 1 selected model(s)", "Successfully linked 1 selected model(s) (6 skipped: nothing to link)"},
+		{markUnlink, []string{"org/linked"}, "Unlinking 1 selected model(s)", "Successfully unlinked 1 selected model(s) (6 skipped: nothing to unlink)"},
+		{markPurge, []string{"org/stale"}, "Purging 1 selected model(s)", "Successfully purged 1 selected model(s) (6 skipped: nothing to purge)"},
+	}
+	for _, tc := range cases {
+		op := markedOp(tc.action, marked, fsutils.LinkSymlink)
+		var repos []string
+		for _, step := range op.steps {
+			repos = append(repos, step.Repo)
+		}
+		if !reflect.DeepEqual(repos, tc.repos) {
+			t.Errorf("%s: expected steps for %v, got %v", tc.action, tc.repos, repos)
+		}
+		if op.label != tc.label {
+			t.Errorf("%s: expected label %q, got %q", tc.action, tc.label, op.label)
+		}
+		if got := op.summary(len(op.steps)); got != tc.summary {
+			t.Errorf("%s: expected summary %q, got %q", tc.action, tc.summary, got)
+		}
+	}
+
+	if op := markedOp(markLink, []fsutils.ModelInfo{unlinked}, fsutils.LinkSymlink); op.summary(1) != "Successfully linked 1 selected model(s)" {
+		t.Errorf("expected no skipped note when nothing was skipped, got %q", op.summary(1))
+	}
+}
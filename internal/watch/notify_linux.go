@@ -0,0 +1,111 @@
+//go:build linux
+
+package watch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask selects the events that add, remove or finish writing entries. Plain writes
+// are left out: a download in progress would report a change for every chunk.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_CLOSE_WRITE | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotify watches every directory below a set of roots. inotify is not recursive, so new
+// subdirectories are added as they appear.
+type inotify struct {
+	fd      int
+	file    *os.File
+	ignore  func(string) bool
+	watches map[int32]string
+}
+
+// startNative watches roots with inotify. It fails when inotify is unavailable or the
+// per-user watch limit is too low for the trees, so that Watch can fall back to polling.
+func startNative(ctx context.Context, roots []string, ignore func(string) bool, changed func()) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("inotify: %v", err)
+	}
+	// A non-blocking descriptor is served by the runtime poller, so closing the file
+	// interrupts a pending read.
+	w := &inotify{fd: fd, file: os.NewFile(uintptr(fd), "inotify"), ignore: ignore, watches: map[int32]string{}}
+	for _, root := range roots {
+		if err := w.addTree(root); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		w.file.Close()
+	}()
+	go w.run(changed)
+	return nil
+}
+
+// addTree adds a watch for dir and every directory below it.
+func (w *inotify) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Directories removed while walking are reported by their parent's watch
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err == syscall.ENOSPC {
+			return fmt.Errorf("inotify: watch limit reached at %s (raise fs.inotify.max_user_watches)", path)
+		}
+		if err != nil {
+			return nil
+		}
+		w.watches[int32(wd)] = path
+		return nil
+	})
+}
+
+// run reads events until the file is closed.
+func (w *inotify) run(changed func()) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		report := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+
+			switch {
+			case event.Mask&syscall.IN_Q_OVERFLOW != 0:
+				report = true
+			case event.Mask&syscall.IN_IGNORED != 0:
+				delete(w.watches, event.Wd)
+			case name != "" && w.ignore(name):
+			default:
+				report = true
+				dir, ok := w.watches[event.Wd]
+				if ok && name != "" && event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					// Errors leave the new directory unwatched; its parent still reports it
+					// being removed or replaced
+					w.addTree(filepath.Join(dir, name))
+				}
+			}
+		}
+		if report {
+			changed()
+		}
+	}
+}
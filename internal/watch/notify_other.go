@@ -0,0 +1,16 @@
+//go:build !linux
+
+package watch
+
+import (
+	"context"
+	"errors"
+)
+
+// errUnsupported is returned by startNative on systems without a native backend.
+var errUnsupported = errors.New("not supported on this system")
+
+// startNative has no backend outside Linux; Watch falls back to polling.
+func startNative(ctx context.Context, roots []string, ignore func(string) bool, changed func()) error {
+	return errUnsupported
+}
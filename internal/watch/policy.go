@@ -0,0 +1,161 @@
+package watch
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+	"github.com/jmfirth/hf-lms-sync/internal/journal"
+	"github.com/jmfirth/hf-lms-sync/internal/rules"
+)
+
+// Policy names, settable as watch_policy.
+const (
+	PolicyLink         = "link"
+	PolicyMarkOutdated = "mark_outdated"
+	PolicyPurgeStale   = "purge_stale"
+)
+
+// Policy is what a watch pass does about the changes it finds.
+type Policy struct {
+	// Link links new downloads that the include and exclude rules allow once they are complete.
+	Link bool
+	// MarkOutdated reports linked models whose cache repository has a newer revision.
+	MarkOutdated bool
+	// PurgeStale removes links whose cache repository was deleted.
+	PurgeStale bool
+}
+
+// ParsePolicy builds a policy from policy names.
+func ParsePolicy(names []string) (Policy, error) {
+	var p Policy
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case PolicyLink:
+			p.Link = true
+		case PolicyMarkOutdated:
+			p.MarkOutdated = true
+		case PolicyPurgeStale:
+			p.PurgeStale = true
+		default:
+			return Policy{}, fmt.Errorf("unknown watch policy %q (want %s, %s or %s)", name, PolicyLink, PolicyMarkOutdated, PolicyPurgeStale)
+		}
+	}
+	return p, nil
+}
+
+// String lists the enabled policies, e.g. "link, mark_outdated".
+func (p Policy) String() string {
+	var names []string
+	if p.Link {
+		names = append(names, PolicyLink)
+	}
+	if p.MarkOutdated {
+		names = append(names, PolicyMarkOutdated)
+	}
+	if p.PurgeStale {
+		names = append(names, PolicyPurgeStale)
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// Notice kinds.
+const (
+	NoticeDeferred = "deferred"
+	NoticeExcluded = "excluded"
+	NoticeOutdated = "outdated"
+)
+
+// Notice is something a pass reports without changing anything.
+type Notice struct {
+	Kind   string
+	Repo   string
+	Reason string
+}
+
+// Plan is what one watch pass does.
+type Plan struct {
+	Steps   []journal.Step
+	Notices []Notice
+}
+
+// Planner decides what each watch pass does. It remembers the models it has seen, so that
+// only models downloaded after the watch started are linked and a model the user unlinked
+// stays unlinked, and gives each notice once until its reason changes.
+type Planner struct {
+	policy       Policy
+	opts         fsutils.LinkOptions
+	linkExisting bool
+	started      bool
+	// known holds the cache directory names of models that were decided on
+	known map[string]bool
+	// noticed maps a repository to the notice last given for it
+	noticed map[string]Notice
+}
+
+// NewPlanner returns a planner applying policy. Models are linked with opts; unlinked
+// models already in the cache on the first pass are left alone unless linkExisting is set.
+func NewPlanner(policy Policy, opts fsutils.LinkOptions, linkExisting bool) *Planner {
+	return &Planner{policy: policy, opts: opts, linkExisting: linkExisting, known: map[string]bool{}, noticed: map[string]Notice{}}
+}
+
+// Plan decides a pass over models, with the include and exclude rules applied, and stale links.
+func (p *Planner) Plan(models, stale []fsutils.ModelInfo) Plan {
+	var plan Plan
+	first := !p.started
+	p.started = true
+	present := map[string]bool{}
+	noticed := map[string]Notice{}
+	notice := func(n Notice) {
+		noticed[n.Repo] = n
+		if p.noticed[n.Repo] != n {
+			plan.Notices = append(plan.Notices, n)
+		}
+	}
+
+	for _, m := range models {
+		name := m.CacheDirName
+		present[name] = true
+		switch {
+		case !m.IsLinkable():
+			p.known[name] = true
+		case m.IsLinked:
+			p.known[name] = true
+			if p.policy.MarkOutdated && m.IsOutdated {
+				notice(Notice{Kind: NoticeOutdated, Repo: m.RepoID(), Reason: m.OutdatedReason})
+			}
+		case p.known[name] || !p.policy.Link:
+		case m.IsIncomplete:
+			// Downloads are decided once they finish, even those that started before the watch
+			notice(Notice{Kind: NoticeDeferred, Repo: m.RepoID(), Reason: m.IncompleteReason})
+		case m.Format == fsutils.FormatUnsupported || (first && !p.linkExisting):
+			p.known[name] = true
+		case m.IsExcluded:
+			p.known[name] = true
+			notice(Notice{Kind: NoticeExcluded, Repo: m.RepoID(), Reason: rules.Describe(m.ExcludedBy)})
+		default:
+			p.known[name] = true
+			opts := p.opts
+			if m.RuleFiles != nil {
+				opts.Files = fsutils.ExactFiles(m.RuleFiles)
+			}
+			plan.Steps = append(plan.Steps, journal.LinkStep(m, opts))
+		}
+	}
+	// A repository deleted from the cache is new again if it is downloaded again
+	for name := range p.known {
+		if !present[name] {
+			delete(p.known, name)
+		}
+	}
+	if p.policy.PurgeStale {
+		for _, m := range stale {
+			plan.Steps = append(plan.Steps, journal.UnlinkStep(m))
+		}
+	}
+	p.noticed = noticed
+	return plan
+}
@@ -0,0 +1,116 @@
+package watch
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jmfirth/hf-lms-sync/internal/fsutils"
+	"github.com/jmfirth/hf-lms-sync/internal/journal"
+)
+
+// model returns an unlinked, complete GGUF model.
+func model(org, name string) fsutils.ModelInfo {
+	return fsutils.ModelInfo{
+		CacheDirName:     fsutils.CacheDirName(fsutils.RepoTypeModel, org, name),
+		RepoType:         fsutils.RepoTypeModel,
+		OrganizationName: org,
+		ModelName:        name,
+		SourcePath:       "/hf/" + org + "/" + name,
+		TargetPath:       "/lms/" + org + "/" + name,
+		Format:           fsutils.FormatGGUF,
+	}
+}
+
+// stepRepos returns the action and repository of every step.
+func stepRepos(steps []journal.Step) []string {
+	var repos []string
+	for _, s := range steps {
+		repos = append(repos, s.Action+" "+s.Repo)
+	}
+	return repos
+}
+
+// TestParsePolicy tests policy names and the error for unknown ones.
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy([]string{"link", " purge_stale"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Policy{Link: true, PurgeStale: true}); p != want {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+	if p.String() != "link, purge_stale" {
+		t.Errorf("unexpected String: %q", p.String())
+	}
+	if (Policy{}).String() != "none" {
+		t.Errorf("expected an empty policy to print as none")
+	}
+	if _, err := ParsePolicy([]string{"relink"}); err == nil {
+		t.Errorf("expected an error for an unknown policy")
+	}
+}
+
+// TestPlanner tests that only new downloads are linked, incomplete ones once they finish,
+// that notices are given once, and that stale links are purged when the policy says so.
+func TestPlanner(t *testing.T) {
+	existing := model("org", "existing")
+	linked := model("org", "linked")
+	linked.IsLinked = true
+	downloading := model("org", "downloading")
+	downloading.IsIncomplete, downloading.IncompleteReason = true, "Download in progress"
+
+	p := NewPlanner(Policy{Link: true, MarkOutdated: true, PurgeStale: true}, fsutils.LinkOptions{Mode: fsutils.LinkHardlink}, false)
+	plan := p.Plan([]fsutils.ModelInfo{existing, linked, downloading}, nil)
+	if len(plan.Steps) != 0 {
+		t.Errorf("expected the first pass to leave existing models alone, got %v", stepRepos(plan.Steps))
+	}
+	if want := []Notice{{NoticeDeferred, "org/downloading", "Download in progress"}}; !reflect.DeepEqual(plan.Notices, want) {
+		t.Errorf("got notices %+v, want %+v", plan.Notices, want)
+	}
+
+	// Nothing changed: no steps and no repeated notices.
+	if plan = p.Plan([]fsutils.ModelInfo{existing, linked, downloading}, nil); len(plan.Steps) != 0 || len(plan.Notices) != 0 {
+		t.Errorf("expected a quiet pass, got %v and %+v", stepRepos(plan.Steps), plan.Notices)
+	}
+
+	// The download finished, a new model and an excluded one arrived, the linked model got
+	// a new revision and a repository was deleted.
+	downloading.IsIncomplete, downloading.IncompleteReason = false, ""
+	fresh := model("org", "fresh")
+	excluded := model("org", "excluded")
+	excluded.IsExcluded, excluded.ExcludedBy = true, "org:org"
+	linked.IsOutdated, linked.OutdatedReason = true, "linked revision a, latest b"
+	gone := model("org", "gone")
+	gone.IsStale, gone.IsLinked = true, true
+	plan = p.Plan([]fsutils.ModelInfo{existing, linked, downloading, fresh, excluded}, []fsutils.ModelInfo{gone})
+	if want := []string{"link org/downloading", "link org/fresh", "unlink org/gone"}; !reflect.DeepEqual(stepRepos(plan.Steps), want) {
+		t.Errorf("got steps %v, want %v", stepRepos(plan.Steps), want)
+	}
+	if plan.Steps[0].Mode != fsutils.LinkHardlink {
+		t.Errorf("expected the link options to be used, got mode %q", plan.Steps[0].Mode)
+	}
+	if len(plan.Notices) != 2 || plan.Notices[0].Kind != NoticeOutdated || plan.Notices[1].Kind != NoticeExcluded {
+		t.Errorf("expected outdated and excluded notices, got %+v", plan.Notices)
+	}
+
+	// The user unlinked the fresh model: it stays unlinked. Once deleted and downloaded
+	// again it is new.
+	if plan = p.Plan([]fsutils.ModelInfo{existing, fresh}, nil); len(plan.Steps) != 0 {
+		t.Errorf("expected an unlinked known model to stay unlinked, got %v", stepRepos(plan.Steps))
+	}
+	p.Plan([]fsutils.ModelInfo{existing}, nil)
+	if plan = p.Plan([]fsutils.ModelInfo{existing, fresh}, nil); !reflect.DeepEqual(stepRepos(plan.Steps), []string{"link org/fresh"}) {
+		t.Errorf("expected a downloaded again model to be linked, got %v", stepRepos(plan.Steps))
+	}
+}
+
+// TestPlannerLinkExisting tests that existing models are linked on the first pass when asked.
+func TestPlannerLinkExisting(t *testing.T) {
+	unsupported := model("org", "safetensors")
+	unsupported.Format = fsutils.FormatUnsupported
+	p := NewPlanner(Policy{Link: true}, fsutils.LinkOptions{}, true)
+	plan := p.Plan([]fsutils.ModelInfo{model("org", "existing"), unsupported}, nil)
+	if want := []string{"link org/existing"}; !reflect.DeepEqual(stepRepos(plan.Steps), want) {
+		t.Errorf("got steps %v, want %v", stepRepos(plan.Steps), want)
+	}
+}
@@ -0,0 +1,168 @@
+// Package watch notices changes to directory trees, like new downloads appearing in the
+// Hugging Face cache, and decides what a watch pass should do about them. Changes are
+// reported through inotify on Linux and by polling elsewhere or when inotify is
+// unavailable, and are debounced so that a burst of writes is reported once.
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultDebounce is how long a tree must be quiet before a change is reported.
+	DefaultDebounce = 2 * time.Second
+	// DefaultPollInterval is how often the polling backend compares the trees.
+	DefaultPollInterval = 5 * time.Second
+)
+
+// Backend names reported by Watcher.Backend.
+const (
+	BackendInotify = "inotify"
+	BackendPolling = "polling"
+)
+
+// Options configures Watch.
+type Options struct {
+	// Debounce is how long the trees must be quiet after a change before it is reported;
+	// DefaultDebounce if zero.
+	Debounce time.Duration
+	// PollInterval is how often the polling backend compares the trees;
+	// DefaultPollInterval if zero.
+	PollInterval time.Duration
+	// Poll uses the polling backend even where a native one is available.
+	Poll bool
+	// Ignore reports file names whose changes are not reported, like lock files.
+	Ignore func(name string) bool
+}
+
+// Watcher reports changes below its roots.
+type Watcher struct {
+	// C receives a value once the trees have been quiet for the debounce period after a
+	// change. Changes made while a value is pending are folded into it. C is closed when
+	// the context of Watch is done.
+	C <-chan struct{}
+	// Backend is BackendInotify or BackendPolling.
+	Backend string
+	// Fallback tells why the native backend is not used, nil if it is or polling was asked for.
+	Fallback error
+}
+
+// Watch watches the directory trees below roots until ctx is done.
+func Watch(ctx context.Context, opts Options, roots ...string) (*Watcher, error) {
+	if len(roots) == 0 {
+		return nil, errors.New("no directories to watch")
+	}
+	for _, root := range roots {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", root)
+		}
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = DefaultDebounce
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	if opts.Ignore == nil {
+		opts.Ignore = func(string) bool { return false }
+	}
+
+	changes := make(chan struct{}, 1)
+	changed := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+	w := &Watcher{Backend: BackendInotify}
+	if opts.Poll {
+		w.Backend = BackendPolling
+	} else if err := startNative(ctx, roots, opts.Ignore, changed); err != nil {
+		w.Backend, w.Fallback = BackendPolling, err
+	}
+	if w.Backend == BackendPolling {
+		// The first fingerprint is taken before returning so no later change is missed
+		go poll(ctx, roots, fingerprint(roots, opts.Ignore), opts.PollInterval, opts.Ignore, changed)
+	}
+	out := make(chan struct{}, 1)
+	w.C = out
+	go debounce(ctx, changes, out, opts.Debounce)
+	return w, nil
+}
+
+// debounce forwards a value to out once in has been quiet for the given period.
+func debounce(ctx context.Context, in <-chan struct{}, out chan<- struct{}, quiet time.Duration) {
+	defer close(out)
+	timer := time.NewTimer(quiet)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-in:
+			timer.Reset(quiet)
+		case <-timer.C:
+			select {
+			case out <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// poll calls changed whenever the fingerprint of the trees differs from the last one.
+func poll(ctx context.Context, roots []string, last uint64, interval time.Duration, ignore func(string) bool, changed func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if current := fingerprint(roots, ignore); current != last {
+				last = current
+				changed()
+			}
+		}
+	}
+}
+
+// fingerprint hashes the path, type, size and modification time of every entry below
+// roots, so that any added, removed, renamed or rewritten file changes it. Directories
+// only contribute their path: their times also move when ignored files come and go.
+func fingerprint(roots []string, ignore func(string) bool) uint64 {
+	h := fnv.New64a()
+	for _, root := range roots {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if ignore(d.Name()) {
+				return nil
+			}
+			if d.IsDir() {
+				fmt.Fprintf(h, "%s\x00dir\n", path)
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			fmt.Fprintf(h, "%s\x00%v\x00%d\x00%d\n", path, info.Mode().Type(), info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+	}
+	return h.Sum64()
+}
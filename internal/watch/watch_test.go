@@ -0,0 +1,102 @@
+package watch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// expectChange fails unless the watcher reports a change within a second.
+func expectChange(t *testing.T, w *Watcher, what string) {
+	t.Helper()
+	select {
+	case <-w.C:
+	case <-time.After(time.Second):
+		t.Fatalf("expected a change after %s", what)
+	}
+}
+
+// expectQuiet fails if the watcher reports a change within the given time.
+func expectQuiet(t *testing.T, w *Watcher, d time.Duration, what string) {
+	t.Helper()
+	select {
+	case <-w.C:
+		t.Fatalf("expected no change after %s", what)
+	case <-time.After(d):
+	}
+}
+
+// testWatch tests that new files in new subdirectories are reported once the tree is
+// quiet, that bursts are reported once, and that ignored files are not reported.
+func testWatch(t *testing.T, poll bool) *Watcher {
+	root := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := Watch(ctx, Options{
+		Debounce:     100 * time.Millisecond,
+		PollInterval: 20 * time.Millisecond,
+		Poll:         poll,
+		Ignore:       func(name string) bool { return name == "ignored.lock" },
+	}, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := filepath.Join(root, "models--org--model", "snapshots", "rev")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, w, "creating directories")
+	for i := 0; i < 5; i++ {
+		name := filepath.Join(repo, "part"+string(rune('a'+i)))
+		if err := ioutil.WriteFile(name, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expectChange(t, w, "writing files in a new directory")
+	expectQuiet(t, w, 300*time.Millisecond, "a burst was reported")
+
+	if err := ioutil.WriteFile(filepath.Join(root, "ignored.lock"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(root, "ignored.lock"))
+	expectQuiet(t, w, 300*time.Millisecond, "touching an ignored file")
+
+	cancel()
+	select {
+	case _, ok := <-w.C:
+		if ok {
+			t.Errorf("expected C to be closed after cancelling")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected C to be closed after cancelling")
+	}
+	return w
+}
+
+// TestWatchNative tests the native backend where there is one.
+func TestWatchNative(t *testing.T) {
+	w := testWatch(t, false)
+	if runtime.GOOS == "linux" && w.Backend != BackendInotify {
+		t.Errorf("expected inotify on Linux, got %s: %v", w.Backend, w.Fallback)
+	}
+}
+
+// TestWatchPolling tests the polling backend.
+func TestWatchPolling(t *testing.T) {
+	if w := testWatch(t, true); w.Backend != BackendPolling {
+		t.Errorf("expected polling, got %s", w.Backend)
+	}
+}
+
+// TestWatchMissing tests that a missing root is an error.
+func TestWatchMissing(t *testing.T) {
+	if _, err := Watch(context.Background(), Options{}, filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("expected an error for a missing directory")
+	}
+}